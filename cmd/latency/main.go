@@ -0,0 +1,373 @@
+// Command latency stands in for the SFU and reports end to end latency per
+// path. The first packet of each frame carries the frame's capture time in the
+// absolute capture time extension and its latency is the arrival time less the
+// capture time, so the tool should run on the sending host or on one with a
+// synchronized clock.
+//
+// Point the muxer's -dest at this tool's -addr. With -ecn the media socket
+// reads the ECN codepoint of each packet and reports CE marks to the muxer with
+// RFC 6679 feedback, which the muxer negotiates with its ecn socket option.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/muxable/rtpmagic/api"
+	"github.com/muxable/rtpmagic/pkg/muxer/abscapture"
+	"github.com/muxable/rtpmagic/pkg/muxer/balancer"
+	"github.com/muxable/rtpmagic/pkg/muxer/ecn"
+	"github.com/muxable/rtpmagic/pkg/muxer/multipath"
+	signalapi "github.com/muxable/signal/api"
+	sig "github.com/muxable/signal/pkg/signal"
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+type key struct {
+	path     uint8
+	mimeType string
+}
+
+// histogramBuckets is the number of millisecond buckets, latencies beyond
+// the last bucket are counted in it.
+const histogramBuckets = 10000
+
+// histogram counts latencies in millisecond buckets so that long runs use
+// constant memory.
+type histogram struct {
+	buckets [histogramBuckets]uint64
+	count   uint64
+	max     time.Duration
+}
+
+func (h *histogram) Add(latency time.Duration) {
+	ms := latency.Milliseconds()
+	switch {
+	case ms < 0:
+		ms = 0
+	case ms >= histogramBuckets:
+		ms = histogramBuckets - 1
+	}
+	h.buckets[ms]++
+	h.count++
+	if latency > h.max {
+		h.max = latency
+	}
+}
+
+// Percentile returns the latency, to the millisecond, that the fraction p of
+// the latencies are below.
+func (h *histogram) Percentile(p float64) time.Duration {
+	rank := uint64(p * float64(h.count-1))
+	var seen uint64
+	for ms, n := range h.buckets {
+		seen += n
+		if seen > rank {
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+	return h.max
+}
+
+// recorder collects latencies per path and codec.
+type recorder struct {
+	sync.Mutex
+
+	interval map[key]*histogram
+	total    map[key]*histogram
+}
+
+func (r *recorder) Add(k key, latency time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	get(r.interval, k).Add(latency)
+	get(r.total, k).Add(latency)
+}
+
+// get returns the histogram for k, adding it if it's new.
+func get(histograms map[key]*histogram, k key) *histogram {
+	h, ok := histograms[k]
+	if !ok {
+		h = &histogram{}
+		histograms[k] = h
+	}
+	return h
+}
+
+// Flush returns and resets the latencies of the last interval.
+func (r *recorder) Flush() map[key]*histogram {
+	r.Lock()
+	defer r.Unlock()
+
+	latencies := r.interval
+	r.interval = make(map[key]*histogram)
+	return latencies
+}
+
+// Total returns a copy of the latencies since the start.
+func (r *recorder) Total() map[key]*histogram {
+	r.Lock()
+	defer r.Unlock()
+
+	total := make(map[key]*histogram, len(r.total))
+	for k, h := range r.total {
+		c := *h
+		total[k] = &c
+	}
+	return total
+}
+
+func report(latencies map[key]*histogram) {
+	keys := make([]key, 0, len(latencies))
+	for k := range latencies {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].path != keys[j].path {
+			return keys[i].path < keys[j].path
+		}
+		return keys[i].mimeType < keys[j].mimeType
+	})
+	for _, k := range keys {
+		h := latencies[k]
+		fmt.Printf("path %3d %-12s %8d pkts  p50 %6dms  p95 %6dms  p99 %6dms  max %6dms\n", k.path, k.mimeType, h.count,
+			h.Percentile(0.5).Milliseconds(), h.Percentile(0.95).Milliseconds(),
+			h.Percentile(0.99).Milliseconds(), h.max.Milliseconds())
+	}
+}
+
+// newAPI accepts the codecs and extensions the muxer offers. Media is received
+// on conn if it's set.
+func newAPI(redDepth int, conn net.PacketConn) (*webrtc.API, error) {
+	m := &webrtc.MediaEngine{}
+	for _, c := range balancer.Codecs {
+		if err := m.RegisterCodec(c.RTPCodecParameters, c.Kind); err != nil {
+			return nil, err
+		}
+		if c.RTXPayloadType != 0 {
+			if err := m.RegisterCodec(webrtc.RTPCodecParameters{RTPCodecCapability: c.RTXCodec(), PayloadType: c.RTXPayloadType}, webrtc.RTPCodecTypeVideo); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := m.RegisterCodec(balancer.FlexFECCodec, webrtc.RTPCodecTypeVideo); err != nil {
+		return nil, err
+	}
+	if err := m.RegisterCodec(balancer.REDCodec(redDepth), webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, err
+	}
+	for _, uri := range []string{abscapture.URI, multipath.URI} {
+		for _, typ := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+			if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: uri}, typ); err != nil {
+				return nil, err
+			}
+		}
+	}
+	i := &interceptor.Registry{}
+	if err := webrtc.ConfigureRTCPReports(i); err != nil {
+		return nil, err
+	}
+	if err := webrtc.ConfigureTWCCSender(m, i); err != nil {
+		return nil, err
+	}
+	s := webrtc.SettingEngine{}
+	if conn != nil {
+		s.SetICEUDPMux(webrtc.NewICEUDPMux(nil, conn))
+	}
+	return webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i), webrtc.WithSettingEngine(s)), nil
+}
+
+// declareECN adds the ECN capability to an outgoing answer.
+func declareECN(pb *anypb.Any) (*anypb.Any, error) {
+	signal := &signalapi.Signal{}
+	if err := pb.UnmarshalTo(signal); err != nil {
+		return nil, err
+	}
+	payload, ok := signal.Payload.(*signalapi.Signal_AnswerSdp)
+	if !ok {
+		return pb, nil
+	}
+	payload.AnswerSdp = ecn.AddCapability(payload.AnswerSdp)
+	return anypb.New(signal)
+}
+
+// reportECN sends the codepoint counts of a track until it ends.
+func reportECN(pc *webrtc.PeerConnection, receiver *ecn.Receiver, ssrc uint32, done <-chan struct{}) {
+	defer receiver.Forget(ssrc)
+
+	ticker := time.NewTicker(ecn.FeedbackInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pkts := receiver.Feedback(ssrc, 0)
+			if pkts == nil {
+				continue
+			}
+			if err := pc.WriteRTCP(pkts); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+type server struct {
+	api.UnimplementedSFUServer
+
+	webrtc   *webrtc.API
+	recorder *recorder
+	// ecn counts the codepoints of the received media, nil if disabled.
+	ecn *ecn.Receiver
+}
+
+// Publish answers a single path's peer connection.
+func (s *server) Publish(stream api.SFU_PublishServer) error {
+	pc, err := s.webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+
+	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		var absCaptureID, multipathID uint8
+		for _, ext := range receiver.GetParameters().HeaderExtensions {
+			switch ext.URI {
+			case abscapture.URI:
+				absCaptureID = uint8(ext.ID)
+			case multipath.URI:
+				multipathID = uint8(ext.ID)
+			}
+		}
+		mimeType := track.Codec().MimeType
+		log.Info().Str("Track", track.ID()).Str("MimeType", mimeType).Msg("receiving track")
+		if s.ecn != nil {
+			done := make(chan struct{})
+			defer close(done)
+			go reportECN(pc, s.ecn, uint32(track.SSRC()), done)
+		}
+		for {
+			p, _, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+			now := time.Now()
+			var capture abscapture.Extension
+			if err := capture.Unmarshal(p.GetExtension(absCaptureID)); err != nil {
+				continue
+			}
+			var path multipath.Extension
+			if err := path.Unmarshal(p.GetExtension(multipathID)); err != nil {
+				continue
+			}
+			s.recorder.Add(key{path: path.PathID, mimeType: mimeType}, now.Sub(capture.CaptureTime()))
+		}
+	})
+
+	signaller := sig.NewSignaller(pc)
+	go func() {
+		for {
+			pb, err := signaller.ReadSignal()
+			if err != nil {
+				return
+			}
+			if s.ecn != nil {
+				if pb, err = declareECN(pb); err != nil {
+					return
+				}
+			}
+			if err := stream.Send(pb); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		pb, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if err := signaller.WriteSignal(pb); err != nil {
+			return err
+		}
+	}
+}
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	addr := flag.String("addr", ":50051", "address to accept signalling on")
+	interval := flag.Duration("interval", 5*time.Second, "how often to print the latency distributions")
+	redDepth := flag.Int("red", 1, "red depth the muxer is configured with")
+	mediaAddr := flag.String("media-addr", ":5004", "udp address to receive media on")
+	enableECN := flag.Bool("ecn", true, "report ce marks to muxers that negotiate ecn")
+	flag.Parse()
+
+	udpAddr, err := net.ResolveUDPAddr("udp4", *mediaAddr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to resolve media address")
+	}
+	conn, err := net.ListenUDP("udp4", udpAddr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to listen for media")
+	}
+	var mediaConn net.PacketConn = conn
+	var receiver *ecn.Receiver
+	if *enableECN {
+		if receiver, err = ecn.NewReceiver(conn); err != nil {
+			log.Fatal().Err(err).Msg("failed to enable ecn")
+		}
+		mediaConn = receiver
+	}
+
+	w, err := newAPI(*redDepth, mediaConn)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create webrtc api")
+	}
+	r := &recorder{
+		interval: make(map[key]*histogram),
+		total:    make(map[key]*histogram),
+	}
+
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to listen")
+	}
+	g := grpc.NewServer()
+	api.RegisterSFUServer(g, &server{webrtc: w, recorder: r, ecn: receiver})
+	go func() {
+		if err := g.Serve(lis); err != nil {
+			log.Fatal().Err(err).Msg("failed to serve")
+		}
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			report(r.Flush())
+			fmt.Println()
+		case <-sigs:
+			g.Stop()
+			fmt.Println("total")
+			report(r.Total())
+			return
+		}
+	}
+}
@@ -2,11 +2,17 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/muxable/rtpmagic/pkg/control"
 	"github.com/muxable/rtpmagic/pkg/muxer/balancer"
 	"github.com/muxable/rtpmagic/pkg/muxer/ffmpeg"
+	"github.com/muxable/rtpmagic/pkg/muxer/quota"
 	"github.com/muxable/sfu/pkg/av"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -20,72 +26,292 @@ func main() {
 	cname := flag.String("cname", "mugit", "join session name")
 	audioSrc := flag.String("audio-src", "plughw:CARD=RX", "audio src")
 	videoSrc := flag.String("video-src", "/dev/video0", "video src")
-	dest := flag.String("dest", "100.105.100.81:50051", "rtp sink destination")
+	dest := flag.String("dest", "100.105.100.81:50051", "comma separated sfu destinations in failover order, host:port or a _service._proto.name srv record")
+	activeActive := flag.Bool("active-active", false, "publish to the first two destinations at once")
+	ladder := flag.String("ladder", "", "video quality ladder, comma separated WIDTHxHEIGHT@FPS:BITRATE rungs, for example 1920x1080@30:4000000,1280x720@30:2000000,854x480@30:1000000,640x360@15:500000, this needs av decoders that can rescale")
+	enableFEC := flag.Bool("fec", false, "protect video with flexfec repair packets")
+	redDepth := flag.Int("red", 0, "number of redundant opus packets to carry with red if the remote accepts it, 0 to disable")
+	enableCCFB := flag.Bool("ccfb", false, "negotiate rfc 8888 congestion control feedback for receivers without transport wide cc")
+	enableRTX := flag.Bool("rtx", true, "send video retransmissions on a separate rtx stream")
+	retransmissionBudget := flag.Float64("retransmission-budget", 0.25, "fraction of the estimated bitrate available for retransmissions")
+	latency := flag.Duration("latency", 0, "playout latency target, media older than this is dropped, 0 to never drop")
+	standby := flag.String("standby", "", "comma separated interfaces to keep connected without media until needed")
+	standbyThreshold := flag.Int("standby-threshold", 1000000, "combined active bitrate below which standby interfaces are promoted")
+	quotas := flag.String("quota", "", "comma separated interface data quotas, for example usb0=2GB/monthly,usb1=500MB/daily")
+	quotaFile := flag.String("quota-file", "/var/lib/rtpmagic/usage.json", "file to persist data usage in")
+	quotaWarning := flag.Float64("quota-warning", 0.8, "fraction of a quota at which to warn")
+	quotaDisable := flag.Bool("quota-disable", false, "disable interfaces that reach their quota instead of deprioritising them")
+	linkQuality := flag.Bool("link-quality", false, "shift traffic away from interfaces with degrading radio signal")
+	socketOptions := flag.String("socket-options", "", "per interface socket options, for example usb0:sndbuf=1048576,priority=6;*:audio-dscp=46,video-dscp=34,ecn=1")
+	binding := flag.String("binding", "device", "how to bind sockets to interfaces, device uses SO_BINDTODEVICE and needs root, source binds to the interface address and needs the routes helper")
+	audioCodecs := flag.String("audio-codecs", "opus,pcmu,pcma", "audio codecs to offer in order of preference, from opus, pcmu and pcma")
+	videoCodecs := flag.String("video-codecs", "h265,h264,vp9,vp8", "video codecs to offer in order of preference, from h265, h264, vp9, vp8 and av1")
+	congestionControllers := flag.String("cc", "", "per interface congestion controllers, gcc or scream, for example usb0=scream,*=gcc")
 	flag.Parse()
 
-	audio, err := av.NewDeviceDemuxer("alsa", *audioSrc)
+	audio, err := av.NewDevice("alsa", *audioSrc)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to create audio device")
 	}
 
-	video, err := av.NewDeviceDemuxer("v4l2", *videoSrc)
+	video, err := av.NewDevice("v4l2", *videoSrc)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to create video device")
 	}
 
-	mpcg, err := balancer.NewManagedPeerConnection(*dest, 1 * time.Second)
-	if err != nil {
-		log.Fatal().Err(err).Msg("failed to create managed peer connection")
-	}
-
 	audioBitrate := int64(96000)
 	minimumBitrate := int64(500000)
 
-	audioCodec := &av.EncoderConfiguration{
-		Name: "libopus",
-		Codec: webrtc.RTPCodecCapability{
-			MimeType: webrtc.MimeTypeOpus,
-			ClockRate: 48000,
-			Channels: 2,
+	// the codecs flags choose among these encoders.
+	audioEncoders := map[string]*av.EncoderConfiguration{
+		"opus": {
+			Name: "libopus",
+			Codec: webrtc.RTPCodecCapability{
+				MimeType:  webrtc.MimeTypeOpus,
+				ClockRate: 48000,
+				Channels:  2,
+			},
+			Bitrate: audioBitrate,
+		},
+		"pcmu": {
+			Name: "pcm_mulaw",
+			Codec: webrtc.RTPCodecCapability{
+				MimeType:  webrtc.MimeTypePCMU,
+				ClockRate: 8000,
+				Channels:  1,
+			},
+			Bitrate: 64000,
+		},
+		"pcma": {
+			Name: "pcm_alaw",
+			Codec: webrtc.RTPCodecCapability{
+				MimeType:  webrtc.MimeTypePCMA,
+				ClockRate: 8000,
+				Channels:  1,
+			},
+			Bitrate: 64000,
 		},
-		Bitrate: audioBitrate,
 	}
-
-	videoCodec :=  &av.EncoderConfiguration{
-		// Name: "libwz265",
-		Name: "hevc_nvv4l2",
-		Codec: webrtc.RTPCodecCapability{
-			MimeType: webrtc.MimeTypeH265,
-			ClockRate: 90000,
+	videoEncoders := map[string]*av.EncoderConfiguration{
+		"h265": {
+			// Name: "libwz265",
+			Name: "hevc_nvv4l2",
+			Codec: webrtc.RTPCodecCapability{
+				MimeType:  webrtc.MimeTypeH265,
+				ClockRate: 90000,
+			},
+			Bitrate:              minimumBitrate,
+			FrameRateNumerator:   7013,
+			FrameRateDenominator: 234,
+			Options: map[string]interface{}{
+				// "preset": "ultrafast",
+				// "wz265-params": "preset=ultrafast:bframes=15:lookahead=2:rc=3:reduce-cplx-tool=1:reduce-cplx-qp=51:fpp=0",
+				"preset": "4",
+				"2pass":  "1",
+				"g":      "10",
+			},
 		},
-		Bitrate: minimumBitrate,
-		FrameRateNumerator: 7013,
-		FrameRateDenominator: 234,
-		Options: map[string]interface{}{
-			// "preset": "ultrafast",
-			// "wz265-params": "preset=ultrafast:bframes=15:lookahead=2:rc=3:reduce-cplx-tool=1:reduce-cplx-qp=51:fpp=0",
-			"preset": "4",
-			"2pass": "1",
-			"g": "10",
+		"h264": {
+			Name: "h264_nvv4l2",
+			Codec: webrtc.RTPCodecCapability{
+				MimeType:  webrtc.MimeTypeH264,
+				ClockRate: 90000,
+			},
+			Bitrate:              minimumBitrate,
+			FrameRateNumerator:   7013,
+			FrameRateDenominator: 234,
+			Options: map[string]interface{}{
+				"preset": "4",
+				"2pass":  "1",
+				"g":      "10",
+			},
 		},
+		"vp9": {
+			Name: "libvpx-vp9",
+			Codec: webrtc.RTPCodecCapability{
+				MimeType:  webrtc.MimeTypeVP9,
+				ClockRate: 90000,
+			},
+			Bitrate:              minimumBitrate,
+			FrameRateNumerator:   7013,
+			FrameRateDenominator: 234,
+			Options: map[string]interface{}{
+				"deadline": "realtime",
+				"cpu-used": "8",
+				"row-mt":   "1",
+				"g":        "10",
+			},
+		},
+		"vp8": {
+			Name: "libvpx",
+			Codec: webrtc.RTPCodecCapability{
+				MimeType:  webrtc.MimeTypeVP8,
+				ClockRate: 90000,
+			},
+			Bitrate:              minimumBitrate,
+			FrameRateNumerator:   7013,
+			FrameRateDenominator: 234,
+			Options: map[string]interface{}{
+				"deadline": "realtime",
+				"cpu-used": "8",
+				"g":        "10",
+			},
+		},
+		"av1": {
+			Name: "libaom-av1",
+			Codec: webrtc.RTPCodecCapability{
+				MimeType:  webrtc.MimeTypeAV1,
+				ClockRate: 90000,
+			},
+			Bitrate:              minimumBitrate,
+			FrameRateNumerator:   7013,
+			FrameRateDenominator: 234,
+			Options: map[string]interface{}{
+				"usage":    "realtime",
+				"cpu-used": "8",
+				"g":        "10",
+			},
+		},
+	}
+	audioConfigs, err := encoderConfigurations(*audioCodecs, audioEncoders)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse audio codecs")
+	}
+	videoConfigs, err := encoderConfigurations(*videoCodecs, videoEncoders)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse video codecs")
+	}
+	var codecs []webrtc.RTPCodecCapability
+	for _, config := range append(append([]*av.EncoderConfiguration{}, videoConfigs...), audioConfigs...) {
+		codecs = append(codecs, config.Codec)
+	}
+
+	opts := []balancer.Option{
+		balancer.WithCodecs(codecs...),
+		balancer.WithRetransmissionBudget(*retransmissionBudget),
+		balancer.WithLatencyTarget(*latency),
+	}
+	switch *binding {
+	case "device":
+	case "source":
+		opts = append(opts, balancer.WithBinding(balancer.BindToSource))
+	default:
+		log.Fatal().Str("Binding", *binding).Msg("unknown binding")
+	}
+	if *socketOptions != "" {
+		o, err := balancer.ParseSocketOptions(*socketOptions)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to parse socket options")
+		}
+		opts = append(opts, balancer.WithSocketOptions(o))
+	}
+	if *congestionControllers != "" {
+		c, err := balancer.ParseCongestionControllers(*congestionControllers)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to parse congestion controllers")
+		}
+		opts = append(opts, balancer.WithCongestionControllers(c))
+	}
+	var monitor *control.LinkQualityMonitor
+	if *linkQuality {
+		monitor = control.NewLinkQualityMonitor(2*time.Second, control.WifiLinkQuality, control.CellularLinkQuality)
+		opts = append(opts, balancer.WithLinkQuality(monitor))
+	}
+	if *quotas != "" {
+		q, err := quota.ParseQuotas(*quotas, *quotaWarning, *quotaDisable)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to parse quotas")
+		}
+		accountant, err := quota.Open(*quotaFile, q)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load data usage")
+		}
+		opts = append(opts, balancer.WithQuotas(accountant))
+	}
+	if *standby != "" {
+		opts = append(opts, balancer.WithStandby(*standbyThreshold, strings.Split(*standby, ",")...))
+	}
+	if *enableFEC {
+		opts = append(opts, balancer.WithFEC())
+	}
+	if *enableCCFB {
+		opts = append(opts, balancer.WithCCFB())
+	}
+	if *enableRTX {
+		opts = append(opts, balancer.WithRTX())
+	}
+	if *redDepth > 0 {
+		opts = append(opts, balancer.WithRED(*redDepth))
 	}
 
-	audioEncoder, err := ffmpeg.NewAudioVideoEncoder(audio, audioCodec, videoCodec, mpcg, *cname)
+	destinations := strings.Split(*dest, ",")
+	mpcg, err := balancer.NewManagedPeerConnection(destinations, 1*time.Second, opts...)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create managed peer connection")
+	}
+	mpcgs := []*balancer.ManagedPeerConnectionGroup{mpcg}
+	if *activeActive {
+		if len(destinations) < 2 {
+			log.Fatal().Msg("active-active requires at least two destinations")
+		}
+		// the second group starts at the next destination and fails over
+		// through the rest in the same order.
+		rotated := append(append([]string{}, destinations[1:]...), destinations[0])
+		secondary, err := balancer.NewManagedPeerConnection(rotated, 1*time.Second, opts...)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to create managed peer connection")
+		}
+		mpcgs = append(mpcgs, secondary)
+	}
+
+	// close the group on shutdown so data usage is persisted.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		if monitor != nil {
+			monitor.Close()
+		}
+		for _, mpcg := range mpcgs {
+			if err := mpcg.Close(); err != nil {
+				log.Error().Err(err).Msg("failed to close managed peer connection")
+			}
+		}
+		os.Exit(0)
+	}()
+
+	audioEncoder, err := ffmpeg.NewAudioVideoEncoder(audio, audioConfigs, videoConfigs, mpcgs, *cname)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to create encoder")
 	}
 
-	videoEncoder, err := ffmpeg.NewAudioVideoEncoder(video, audioCodec, videoCodec, mpcg, *cname)
+	videoEncoder, err := ffmpeg.NewAudioVideoEncoder(video, audioConfigs, videoConfigs, mpcgs, *cname)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to create encoder")
 	}
 
+	if *ladder != "" {
+		l, err := ffmpeg.ParseLadder(*ladder)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to parse ladder")
+		}
+		if err := videoEncoder.SetLadder(l); err != nil {
+			log.Fatal().Err(err).Msg("failed to set ladder")
+		}
+	}
+
 	go audioEncoder.Run()
 	go videoEncoder.Run()
 
 	ticker := time.NewTicker(15 * time.Millisecond) // ~60 fps
 	for range ticker.C {
+		// with active-active the encoder has to fit the slower of the groups.
 		bitrate := int64(mpcg.GetEstimatedBitrate())
+		for _, mpcg := range mpcgs[1:] {
+			if b := int64(mpcg.GetEstimatedBitrate()); b < bitrate {
+				bitrate = b
+			}
+		}
 		if bitrate > audioBitrate {
 			bitrate -= audioBitrate // subtract off audio bitrate
 		}
@@ -96,3 +322,16 @@ func main() {
 		// audioSource.SetPacketLossPercentage(uint32(loss * 100))
 	}
 }
+
+// encoderConfigurations returns the encoders for comma separated codec names.
+func encoderConfigurations(names string, encoders map[string]*av.EncoderConfiguration) ([]*av.EncoderConfiguration, error) {
+	var configs []*av.EncoderConfiguration
+	for _, name := range strings.Split(names, ",") {
+		config, ok := encoders[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown codec %q", name)
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
@@ -0,0 +1,75 @@
+// Command routes installs source based policy routing for the interfaces the
+// muxer uses so that it can run with -binding=source without privileges. It
+// needs CAP_NET_ADMIN.
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/muxable/rtpmagic/pkg/muxer/balancer"
+	"github.com/muxable/rtpmagic/pkg/muxer/route"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	interval := flag.Duration("interval", 1*time.Second, "how often to check for interface changes")
+	flag.Parse()
+
+	installed := make(map[string]route.Route)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		devices, err := balancer.GetLocalAddresses()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get local addresses")
+		}
+		for device, laddr := range devices {
+			r, err := route.NewRoute(device, laddr.IP)
+			if err != nil {
+				log.Error().Err(err).Str("Interface", device).Msg("failed to look up route")
+				continue
+			}
+			if prev, ok := installed[device]; ok && prev.String() == r.String() {
+				continue
+			}
+			if err := route.Install(r); err != nil {
+				log.Error().Err(err).Msg("failed to install route")
+				continue
+			}
+			installed[device] = r
+			log.Info().Stringer("Route", r).Msg("installed route")
+		}
+		for device, r := range installed {
+			if _, ok := devices[device]; ok {
+				continue
+			}
+			if err := route.Remove(r); err != nil {
+				log.Error().Err(err).Msg("failed to remove route")
+			}
+			delete(installed, device)
+			log.Info().Stringer("Route", r).Msg("removed route")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-sigs:
+			for _, r := range installed {
+				if err := route.Remove(r); err != nil {
+					log.Error().Err(err).Msg("failed to remove route")
+				}
+			}
+			return
+		}
+	}
+}
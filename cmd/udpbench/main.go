@@ -0,0 +1,116 @@
+// Command udpbench measures the throughput, cpu cost and allocations of
+// sending RTP over UDP one datagram at a time against the batched
+// sendmmsg/GSO path.
+//
+// When sending to loopback the cpu time and allocations include the local
+// sink, which are the same for both paths.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/muxable/rtpmagic/pkg/muxer/balancer"
+	"github.com/pion/rtp"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	dest := flag.String("dest", "127.0.0.1:5004", "address to send to, a local sink is started if it's on this host")
+	packets := flag.Int("packets", 200000, "number of packets to send")
+	size := flag.Int("size", 1200, "rtp payload size")
+	frame := flag.Int("frame", 20, "packets per frame, the last one carries the marker bit")
+	flag.Parse()
+
+	addr, err := net.ResolveUDPAddr("udp4", *dest)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to resolve destination")
+	}
+	if addr.IP.IsLoopback() {
+		go sink(addr)
+	}
+
+	for _, batched := range []bool{false, true} {
+		conn, err := net.ListenUDP("udp4", nil)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to listen")
+		}
+		var pconn net.PacketConn = conn
+		if batched {
+			pconn = balancer.NewBatchConn(conn)
+		}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		elapsed, cpu, err := run(pconn, addr, *packets, *size, *frame)
+		runtime.ReadMemStats(&after)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to send")
+		}
+		pconn.Close()
+
+		bytes := float64(*packets) * float64(*size+12)
+		fmt.Printf("batched=%-5v %10.0f pkt/s %8.1f Mbit/s %8.0f ns cpu/pkt %6.2f allocs/pkt\n",
+			batched, float64(*packets)/elapsed.Seconds(), bytes*8/elapsed.Seconds()/1e6, float64(cpu.Nanoseconds())/float64(*packets),
+			float64(after.Mallocs-before.Mallocs)/float64(*packets))
+	}
+}
+
+// run sends the packets and returns the wall clock and cpu time taken.
+func run(conn net.PacketConn, addr net.Addr, packets, size, frame int) (time.Duration, time.Duration, error) {
+	payload := make([]byte, size)
+	buf := make([]byte, size+12)
+	cpu0 := cpuTime()
+	t0 := time.Now()
+	for i := 0; i < packets; i++ {
+		pkt := rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         i%frame == frame-1,
+				PayloadType:    96,
+				SequenceNumber: uint16(i),
+				Timestamp:      uint32(i / frame * 3000),
+				SSRC:           1,
+			},
+			Payload: payload,
+		}
+		n, err := pkt.MarshalTo(buf)
+		if err != nil {
+			return 0, 0, err
+		}
+		if _, err := conn.WriteTo(buf[:n], addr); err != nil {
+			return 0, 0, err
+		}
+	}
+	return time.Since(t0), cpuTime() - cpu0, nil
+}
+
+func cpuTime() time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// sink drains the destination so the sends don't fail with connection
+// refused.
+func sink(addr *net.UDPAddr) {
+	conn, err := net.ListenUDP("udp4", addr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to listen")
+	}
+	buf := make([]byte, 65536)
+	for {
+		if _, _, err := conn.ReadFrom(buf); err != nil {
+			return
+		}
+	}
+}
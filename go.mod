@@ -2,10 +2,6 @@ module github.com/muxable/rtpmagic
 
 go 1.17
 
-replace github.com/muxable/bluetooth => ../bttest
-
-replace github.com/muxable/sfu => ../sfu
-
 require (
 	github.com/google/uuid v1.3.0
 	github.com/muxable/sfu v0.0.0-20220519231102-5b3505cc2042
@@ -17,12 +13,11 @@ require (
 	github.com/pion/webrtc/v3 v3.1.23
 	github.com/rs/zerolog v1.26.1
 	go.uber.org/zap v1.21.0
+	golang.org/x/net v0.0.0-20220401154927-543a649e0bdd
 	google.golang.org/grpc v1.45.0
 	google.golang.org/protobuf v1.27.1
 )
 
-require golang.org/x/net v0.0.0-20220401154927-543a649e0bdd // indirect
-
 require (
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/mattn/go-pointer v0.0.1 // indirect
@@ -0,0 +1,218 @@
+package control
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// LinkQualitySource measures the radio quality of an interface as a score in
+// [0, 1]. ok is false if the source doesn't know about the interface.
+type LinkQualitySource func(iface string) (score float64, ok bool, err error)
+
+type linkQuality struct {
+	score, average float64
+	seen           bool
+}
+
+// LinkQualityMonitor polls link quality sources in the background so that
+// lookups from the scheduler never block on a subprocess.
+type LinkQualityMonitor struct {
+	sync.RWMutex
+
+	sources []LinkQualitySource
+	links   map[string]*linkQuality
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewLinkQualityMonitor polls the sources every interval for every interface
+// that has been looked up until it's closed. The first source that knows an
+// interface wins.
+func NewLinkQualityMonitor(interval time.Duration, sources ...LinkQualitySource) *LinkQualityMonitor {
+	m := &LinkQualityMonitor{sources: sources, links: make(map[string]*linkQuality), done: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.poll()
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// Close stops polling.
+func (m *LinkQualityMonitor) Close() {
+	m.closeOnce.Do(func() { close(m.done) })
+}
+
+func (m *LinkQualityMonitor) poll() {
+	m.RLock()
+	ifaces := make([]string, 0, len(m.links))
+	for iface := range m.links {
+		ifaces = append(ifaces, iface)
+	}
+	m.RUnlock()
+
+	for _, iface := range ifaces {
+		for _, source := range m.sources {
+			score, ok, err := source(iface)
+			if err != nil {
+				zap.L().Debug("failed to read link quality", zap.String("iface", iface), zap.Error(err))
+				continue
+			}
+			if !ok {
+				continue
+			}
+			m.Lock()
+			l := m.links[iface]
+			if !l.seen {
+				l.average = score
+				l.seen = true
+			}
+			l.score = score
+			l.average = 0.9*l.average + 0.1*score
+			m.Unlock()
+			break
+		}
+	}
+}
+
+// LinkQuality returns the latest score for iface and whether it is degrading,
+// that is noticeably below its recent average.
+func (m *LinkQualityMonitor) LinkQuality(iface string) (float64, bool, bool) {
+	m.RLock()
+	l, ok := m.links[iface]
+	m.RUnlock()
+	if !ok {
+		// start polling this interface.
+		m.Lock()
+		if _, ok := m.links[iface]; !ok {
+			m.links[iface] = &linkQuality{}
+		}
+		m.Unlock()
+		return 0, false, false
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+	if !l.seen {
+		return 0, false, false
+	}
+	return l.score, l.score < l.average-0.1, true
+}
+
+// clamp linearly maps v from [lo, hi] to [0, 1].
+func clamp(v, lo, hi float64) float64 {
+	switch {
+	case v <= lo:
+		return 0
+	case v >= hi:
+		return 1
+	}
+	return (v - lo) / (hi - lo)
+}
+
+// WifiLinkQuality reads the signal strength of the connected access point
+// from nmcli.
+func WifiLinkQuality(iface string) (float64, bool, error) {
+	aps, err := exec.Command("nmcli", "--terse", "-f", "in-use,signal", "dev", "wifi", "list", "ifname", iface, "--rescan", "no").Output()
+	if err != nil {
+		// nmcli fails for interfaces that aren't wifi.
+		return 0, false, nil
+	}
+	for _, line := range strings.Split(string(aps), "\n") {
+		tokens := SplitWithEscaping(line, ":", "\\")
+		if len(tokens) < 2 || tokens[0] != "*" {
+			continue
+		}
+		signal, err := strconv.ParseFloat(tokens[1], 64)
+		if err != nil {
+			return 0, false, err
+		}
+		return signal / 100, true, nil
+	}
+	return 0, false, nil
+}
+
+// CellularLinkQuality reads LTE RSRP, RSRQ and SINR from ModemManager for the
+// modem owning iface. Signal polling must be enabled on the modem, for example
+// with mmcli --signal-setup.
+func CellularLinkQuality(iface string) (float64, bool, error) {
+	modem, err := modemForInterface(iface)
+	if err != nil || modem == "" {
+		return 0, false, err
+	}
+	stdout, err := exec.Command("mmcli", "-m", modem, "--signal-get", "--output-keyvalue").Output()
+	if err != nil {
+		return 0, false, err
+	}
+	values := parseKeyValue(string(stdout))
+	rsrp, err1 := strconv.ParseFloat(values["modem.signal.lte.rsrp"], 64)
+	rsrq, err2 := strconv.ParseFloat(values["modem.signal.lte.rsrq"], 64)
+	sinr, err3 := strconv.ParseFloat(values["modem.signal.lte.snr"], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false, nil
+	}
+	// weight each metric across its usable range and take the worst, a link
+	// with strong signal but heavy interference is still a bad link.
+	score := clamp(rsrp, -120, -80)
+	if s := clamp(rsrq, -20, -8); s < score {
+		score = s
+	}
+	if s := clamp(sinr, -5, 20); s < score {
+		score = s
+	}
+	return score, true, nil
+}
+
+// modemForInterface returns the ModemManager path of the modem exposing the
+// given network interface, or an empty string if there is none.
+func modemForInterface(iface string) (string, error) {
+	stdout, err := exec.Command("mmcli", "-L", "--output-keyvalue").Output()
+	if err != nil {
+		return "", err
+	}
+	for key, modem := range parseKeyValue(string(stdout)) {
+		if !strings.HasPrefix(key, "modem-list.value") {
+			continue
+		}
+		details, err := exec.Command("mmcli", "-m", modem, "--output-keyvalue").Output()
+		if err != nil {
+			return "", err
+		}
+		for key, port := range parseKeyValue(string(details)) {
+			if strings.HasPrefix(key, "modem.generic.ports.value") && strings.HasPrefix(port, iface+" ") {
+				return modem, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// parseKeyValue parses mmcli --output-keyvalue output.
+func parseKeyValue(s string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(s, "\n") {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		value := strings.TrimSpace(line[i+1:])
+		if value == "--" {
+			continue
+		}
+		values[strings.TrimSpace(line[:i])] = value
+	}
+	return values
+}
@@ -0,0 +1,42 @@
+package abscapture
+
+import (
+	"sync"
+	"time"
+)
+
+// CaptureClock maps RTP timestamps to capture wall clock times. The RTP muxer
+// derives timestamps from the encoder pts, which carry the demuxer's pts, so
+// a timestamp is converted back to the pts and offset by the wall clock time
+// the demuxer captured its first frame at.
+type CaptureClock struct {
+	sync.Mutex
+
+	clockRate uint32
+	start     time.Time
+
+	last    uint32
+	elapsed int64
+}
+
+// NewCaptureClock creates a clock for a stream sent with the RTP timestamp base
+// at pts zero, whose frame with the given pts was captured at start.
+func NewCaptureClock(clockRate uint32, base uint32, pts time.Duration, start time.Time) *CaptureClock {
+	// the pts may be wall clock based so it's split to avoid overflowing.
+	sec, nsec := uint64(pts/time.Second), uint64(pts%time.Second)
+	ticks := sec*uint64(clockRate) + nsec*uint64(clockRate)/uint64(time.Second)
+	return &CaptureClock{clockRate: clockRate, start: start, last: base + uint32(ticks)}
+}
+
+// Time returns the capture time of the frame with the timestamp.
+func (c *CaptureClock) Time(timestamp uint32) time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	// unwrap the timestamp relative to the last one seen.
+	c.elapsed += int64(int32(timestamp - c.last))
+	c.last = timestamp
+
+	rate := int64(c.clockRate)
+	return c.start.Add(time.Duration(c.elapsed/rate)*time.Second + time.Duration(c.elapsed%rate)*time.Second/time.Duration(rate))
+}
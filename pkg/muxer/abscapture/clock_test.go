@@ -0,0 +1,36 @@
+package abscapture
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCaptureClock(t *testing.T) {
+	start := time.Unix(1650000000, 0)
+	// wall clock pts, as a v4l2 device reports them.
+	pts := time.Duration(start.UnixNano())
+	base := uint32(0xfffff000)
+	first := base + uint32(uint64(start.Unix())*90000)
+	c := NewCaptureClock(90000, base, pts, start)
+
+	for _, tc := range []struct {
+		timestamp uint32
+		want      time.Duration
+	}{
+		{first, 0},
+		{first + 3000, time.Second / 30},
+		// reordered frames step back.
+		{first + 1500, time.Second / 60},
+	} {
+		if got := c.Time(tc.timestamp).Sub(start); got != tc.want {
+			t.Errorf("Time(%d) = start + %v, want start + %v", tc.timestamp, got, tc.want)
+		}
+	}
+	// the timestamp wraps every 13 hours at 90 kHz.
+	for h := 1; h <= 14; h++ {
+		timestamp := first + uint32(h)*90000*3600
+		if got, want := c.Time(timestamp).Sub(start), time.Duration(h)*time.Hour; got != want {
+			t.Errorf("Time(%d) = start + %v, want start + %v", timestamp, got, want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Package abscapture implements the absolute capture time RTP header
+// extension, which carries the wall clock time a frame was captured so that
+// receivers can measure end to end latency.
+//
+// http://www.webrtc.org/experiments/rtp-hdrext/abs-capture-time
+package abscapture
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// URI identifies the absolute capture time header extension in SDP.
+const URI = "http://www.webrtc.org/experiments/rtp-hdrext/abs-capture-time"
+
+const (
+	extensionSize           = 8
+	extensionSizeWithOffset = 16
+)
+
+var errTooSmall = errors.New("buffer too small")
+
+// ntpEpochOffset is the number of seconds between 1900 and 1970.
+const ntpEpochOffset = 2208988800
+
+// Extension carries the NTP time of capture and optionally the estimated
+// offset of the capturer's clock from the sender's, both in 32.32 fixed point.
+//
+//	 0                   1                   2                   3
+//	 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|                  absolute capture timestamp                   |
+//	|                                                               |
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|           estimated capture clock offset (optional)           |
+//	|                                                               |
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type Extension struct {
+	Timestamp uint64
+	// EstimatedCaptureClockOffset is only sent if it is non-zero.
+	EstimatedCaptureClockOffset int64
+}
+
+// NewExtension returns the extension for a frame captured at t.
+func NewExtension(t time.Time) Extension {
+	return Extension{Timestamp: ntpTime(t)}
+}
+
+// CaptureTime returns the capture time on the sender's clock.
+func (e Extension) CaptureTime() time.Time {
+	return fromNTPTime(e.Timestamp).Add(fixedToDuration(e.EstimatedCaptureClockOffset))
+}
+
+// Marshal serializes the extension.
+func (e Extension) Marshal() ([]byte, error) {
+	if e.EstimatedCaptureClockOffset == 0 {
+		buf := make([]byte, extensionSize)
+		binary.BigEndian.PutUint64(buf, e.Timestamp)
+		return buf, nil
+	}
+	buf := make([]byte, extensionSizeWithOffset)
+	binary.BigEndian.PutUint64(buf, e.Timestamp)
+	binary.BigEndian.PutUint64(buf[8:], uint64(e.EstimatedCaptureClockOffset))
+	return buf, nil
+}
+
+// Unmarshal parses the extension.
+func (e *Extension) Unmarshal(buf []byte) error {
+	if len(buf) < extensionSize {
+		return errTooSmall
+	}
+	e.Timestamp = binary.BigEndian.Uint64(buf)
+	e.EstimatedCaptureClockOffset = 0
+	if len(buf) >= extensionSizeWithOffset {
+		e.EstimatedCaptureClockOffset = int64(binary.BigEndian.Uint64(buf[8:]))
+	}
+	return nil
+}
+
+func ntpTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+func fromNTPTime(ntp uint64) time.Time {
+	seconds := int64(ntp>>32) - ntpEpochOffset
+	nanoseconds := int64((ntp & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanoseconds)
+}
+
+func fixedToDuration(q int64) time.Duration {
+	return time.Duration(q>>32)*time.Second + time.Duration(uint64(q&0xffffffff)*uint64(time.Second)>>32)
+}
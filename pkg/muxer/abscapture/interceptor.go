@@ -0,0 +1,73 @@
+package abscapture
+
+import (
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// LocalID is the header extension id the encoder pipeline stamps capture
+// times with before the packet is assigned to a path. Each path moves the
+// extension to the id it negotiated.
+const LocalID = 14
+
+// HeaderExtensionInterceptorFactory is an interceptor.Factory for a
+// HeaderExtensionInterceptor.
+type HeaderExtensionInterceptorFactory struct{}
+
+// NewInterceptor constructs a new HeaderExtensionInterceptor.
+func (h *HeaderExtensionInterceptorFactory) NewInterceptor(id string) (interceptor.Interceptor, error) {
+	return &HeaderExtensionInterceptor{}, nil
+}
+
+// HeaderExtensionInterceptor moves the capture time stamped with LocalID to
+// the negotiated extension id, or drops it if the extension wasn't negotiated.
+type HeaderExtensionInterceptor struct {
+	interceptor.NoOp
+}
+
+// BindLocalStream returns a writer that rewrites the extension id.
+func (h *HeaderExtensionInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	var hdrExtID uint8
+	for _, e := range info.RTPHeaderExtensions {
+		if e.URI == URI {
+			hdrExtID = uint8(e.ID)
+			break
+		}
+	}
+	if hdrExtID == LocalID {
+		return writer
+	}
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		if ext := header.GetExtension(LocalID); ext != nil {
+			// the extensions may be shared with the send buffer, so they're
+			// copied rather than deleted in place.
+			header.Extensions = append([]rtp.Extension(nil), header.Extensions...)
+			if err := header.DelExtension(LocalID); err != nil {
+				return 0, err
+			}
+			if hdrExtID != 0 {
+				if err := header.SetExtension(hdrExtID, ext); err != nil {
+					return 0, err
+				}
+			}
+			header.Extension = len(header.Extensions) > 0
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// ConfigureHeaderExtension registers the absolute capture time extension for
+// audio and video and adds the interceptor that carries it onto the path. It
+// must be added after the other header extension interceptors so that it runs
+// before them.
+func ConfigureHeaderExtension(m *webrtc.MediaEngine, i *interceptor.Registry) error {
+	if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: URI}, webrtc.RTPCodecTypeVideo); err != nil {
+		return err
+	}
+	if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: URI}, webrtc.RTPCodecTypeAudio); err != nil {
+		return err
+	}
+	i.Add(&HeaderExtensionInterceptorFactory{})
+	return nil
+}
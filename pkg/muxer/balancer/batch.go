@@ -0,0 +1,275 @@
+package balancer
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// BatchSize is the most datagrams queued before a flush.
+	BatchSize = 64
+
+	// maxSegments and maxSegmentBytes are the kernel's UDP GSO limits.
+	maxSegments     = 64
+	maxSegmentBytes = 65000
+
+	solUDP     = 17
+	udpSegment = 103
+)
+
+var datagramPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, packetBufferSize)
+		return &b
+	},
+}
+
+// BatchFlushInterval bounds how long a datagram waits for the rest of its
+// frame before it's sent.
+var BatchFlushInterval = 2 * time.Millisecond
+
+// batchConn queues outgoing datagrams and sends them with one sendmmsg call
+// at the end of each frame. Runs of equally sized datagrams to the same
+// address are coalesced with UDP GSO where the kernel supports it.
+type batchConn struct {
+	*net.UDPConn
+
+	sync.Mutex
+	pc     *ipv4.PacketConn
+	marker *marker
+	// count records the size of each datagram sent or received, nil if they
+	// aren't counted.
+	count  func(n int)
+	queue  []datagram
+	msgs   []ipv4.Message
+	oob    []byte
+	timer  *time.Timer
+	gso    bool
+	closed bool
+}
+
+type datagram struct {
+	// buf is a copy of the written bytes from datagramPool.
+	buf  *[]byte
+	addr net.Addr
+	oob  []byte
+}
+
+// newBatchConn wraps conn, connections other than *net.UDPConn are returned
+// as-is.
+func newBatchConn(conn net.PacketConn, m *marker, count func(n int)) net.PacketConn {
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		return conn
+	}
+	c := &batchConn{
+		UDPConn: udpConn,
+		pc:      ipv4.NewPacketConn(udpConn),
+		marker:  m,
+		count:   count,
+		gso:     supportsGSO(udpConn),
+	}
+	c.timer = time.AfterFunc(BatchFlushInterval, c.flushTimer)
+	c.timer.Stop()
+	return c
+}
+
+// supportsGSO checks whether the kernel knows the UDP_SEGMENT option.
+func supportsGSO(conn *net.UDPConn) bool {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		_, sockErr = syscall.GetsockoptInt(int(fd), solUDP, udpSegment)
+	}); err != nil {
+		return false
+	}
+	return sockErr == nil
+}
+
+func (c *batchConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	buf := datagramPool.Get().(*[]byte)
+	if cap(*buf) < len(p) {
+		*buf = make([]byte, len(p))
+	}
+	*buf = (*buf)[:len(p)]
+	copy(*buf, p)
+	if c.count != nil {
+		c.count(len(p))
+	}
+	c.queue = append(c.queue, datagram{buf: buf, addr: addr, oob: c.marker.mark(p)})
+
+	// wait for the end of the frame, anything that isn't RTP, such as STUN
+	// and RTCP, is sent immediately along with the queue.
+	if isRTP(p) && p[1]&0x80 == 0 && len(c.queue) < BatchSize {
+		if len(c.queue) == 1 {
+			c.timer.Reset(BatchFlushInterval)
+		}
+		return len(p), nil
+	}
+	c.timer.Stop()
+	if err := c.flush(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *batchConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.UDPConn.ReadFrom(p)
+	if n > 0 && c.count != nil {
+		c.count(n)
+	}
+	return n, addr, err
+}
+
+func (c *batchConn) flushTimer() {
+	c.Lock()
+	defer c.Unlock()
+
+	if err := c.flush(); err != nil {
+		log.Warn().Err(err).Msg("failed to flush batch")
+	}
+}
+
+// flush sends the queue, it must be called with the lock held.
+func (c *batchConn) flush() error {
+	if len(c.queue) == 0 {
+		return nil
+	}
+	defer func() {
+		for i, d := range c.queue {
+			datagramPool.Put(d.buf)
+			c.queue[i] = datagram{}
+		}
+		c.queue = c.queue[:0]
+	}()
+
+	sent := 0
+	if c.gso {
+		n, err := c.send(c.messages(0, true))
+		if err == nil {
+			return nil
+		}
+		// some drivers reject segmentation offload at send time.
+		if !errors.Is(err, syscall.EIO) && !errors.Is(err, syscall.EINVAL) {
+			return err
+		}
+		log.Warn().Err(err).Msg("udp gso unavailable, falling back to sendmmsg")
+		c.gso = false
+		// only the datagrams that weren't sent are resent.
+		sent = n
+	}
+	_, err := c.send(c.messages(sent, false))
+	return err
+}
+
+// messages builds the batch from the queued datagrams starting at from,
+// coalescing runs of datagrams if gso is set.
+func (c *batchConn) messages(from int, gso bool) []ipv4.Message {
+	// the message slices and control messages are reused across flushes.
+	msgs := c.msgs[:0]
+	oob := c.oob[:0]
+	for i := from; i < len(c.queue); {
+		d := c.queue[i]
+		j, size := i+1, len(*d.buf)
+		// every segment but the last must be the same size.
+		for gso && j < len(c.queue) && j-i < maxSegments && size+len(*c.queue[j].buf) <= maxSegmentBytes &&
+			len(*c.queue[j-1].buf) == len(*d.buf) && len(*c.queue[j].buf) <= len(*d.buf) &&
+			sameAddr(c.queue[j].addr, d.addr) && bytes.Equal(c.queue[j].oob, d.oob) {
+			size += len(*c.queue[j].buf)
+			j++
+		}
+		msg := ipv4.Message{Addr: d.addr, OOB: d.oob}
+		if len(msgs) < cap(msgs) {
+			// reuse the buffers slice from an earlier flush.
+			msg.Buffers = msgs[:len(msgs)+1][len(msgs)].Buffers[:0]
+		}
+		for _, q := range c.queue[i:j] {
+			msg.Buffers = append(msg.Buffers, *q.buf)
+		}
+		if j-i > 1 {
+			start := len(oob)
+			oob = appendSegmentControlMessage(append(oob, d.oob...), len(*d.buf))
+			msg.OOB = oob[start:len(oob):len(oob)]
+		}
+		msgs = append(msgs, msg)
+		i = j
+	}
+	c.msgs = msgs
+	c.oob = oob
+	return msgs
+}
+
+// send writes the messages and returns the number of datagrams sent, which
+// differs from the number of messages when they're coalesced.
+func (c *batchConn) send(msgs []ipv4.Message) (int, error) {
+	sent := 0
+	for len(msgs) > 0 {
+		n, err := c.pc.WriteBatch(msgs, 0)
+		if n < 0 {
+			n = 0
+		}
+		for _, msg := range msgs[:n] {
+			sent += len(msg.Buffers)
+		}
+		if err != nil {
+			return sent, err
+		}
+		msgs = msgs[n:]
+	}
+	return sent, nil
+}
+
+func (c *batchConn) Close() error {
+	c.Lock()
+	c.timer.Stop()
+	if err := c.flush(); err != nil {
+		log.Warn().Err(err).Msg("failed to flush batch")
+	}
+	c.closed = true
+	c.Unlock()
+	return c.UDPConn.Close()
+}
+
+func sameAddr(a, b net.Addr) bool {
+	if a, ok := a.(*net.UDPAddr); ok {
+		if b, ok := b.(*net.UDPAddr); ok {
+			return a.Port == b.Port && a.IP.Equal(b.IP)
+		}
+	}
+	return a.String() == b.String()
+}
+
+// appendSegmentControlMessage appends a UDP_SEGMENT ancillary message to dst.
+func appendSegmentControlMessage(dst []byte, size int) []byte {
+	n := len(dst)
+	dst = append(dst, make([]byte, syscall.CmsgSpace(2))...)
+	b := dst[n:]
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = solUDP
+	h.Type = udpSegment
+	h.SetLen(syscall.CmsgLen(2))
+	*(*uint16)(unsafe.Pointer(&b[syscall.CmsgLen(0)])) = uint16(size)
+	return dst
+}
+
+// NewBatchConn wraps conn so that writes are batched at frame boundaries.
+func NewBatchConn(conn net.PacketConn) net.PacketConn {
+	return newBatchConn(conn, nil, nil)
+}
@@ -0,0 +1,93 @@
+package balancer
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func BenchmarkBatchConn(b *testing.B) {
+	for _, gso := range []bool{false, true} {
+		name := "sendmmsg"
+		if gso {
+			name = "gso"
+		}
+		b.Run(name, func(b *testing.B) {
+			sink, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer sink.Close()
+			go func() {
+				buf := make([]byte, 1500)
+				for {
+					if _, _, err := sink.ReadFrom(buf); err != nil {
+						return
+					}
+				}
+			}()
+
+			conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				b.Fatal(err)
+			}
+			c := newBatchConn(conn, nil, nil).(*batchConn)
+			defer c.Close()
+			if gso && !c.gso {
+				b.Skip("udp gso is not supported")
+			}
+			c.gso = gso
+
+			// frames of ten full size packets, the last one has the marker set.
+			pkt := make([]byte, 1200)
+			pkt[0] = 0x80
+			pkt[1] = 96
+			b.SetBytes(int64(len(pkt)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				pkt[1] = 96
+				if i%10 == 9 {
+					pkt[1] |= 0x80
+				}
+				binary.BigEndian.PutUint16(pkt[2:4], uint16(i))
+				if _, err := c.WriteTo(pkt, sink.LocalAddr()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchConnCount(t *testing.T) {
+	peer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var counted []int
+	c := newBatchConn(conn, nil, func(n int) { counted = append(counted, n) })
+	defer c.Close()
+
+	// a stun-like datagram isn't batched, so it's sent immediately.
+	if _, err := c.WriteTo(make([]byte, 100), peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	if _, _, err := peer.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer.WriteTo(make([]byte, 50), c.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if len(counted) != 2 || counted[0] != 100 || counted[1] != 50 {
+		t.Errorf("counted %v, want [100 50]", counted)
+	}
+}
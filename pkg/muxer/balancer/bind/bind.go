@@ -0,0 +1,52 @@
+// Package bind ties sockets to a network interface without relying on Go
+// runtime internals.
+package bind
+
+import (
+	"syscall"
+)
+
+// BindToDevice binds an open socket, such as a *net.UDPConn or *net.TCPConn,
+// to the device. Sockets that are already bound to an address keep it, so
+// prefer Control where the socket is created by the caller.
+func BindToDevice(conn syscall.Conn, device string) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return bindRawConn(raw, device)
+}
+
+// Control returns a net.ListenConfig or net.Dialer control function that binds
+// the socket to the device before it is bound or connected.
+func Control(device string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return bindRawConn(c, device)
+	}
+}
+
+// Chain combines control functions, running them in order until one fails.
+// Nil functions are skipped.
+func Chain(fns ...func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		for _, fn := range fns {
+			if fn == nil {
+				continue
+			}
+			if err := fn(network, address, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func bindRawConn(c syscall.RawConn, device string) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = bindToDevice(int(fd), device)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
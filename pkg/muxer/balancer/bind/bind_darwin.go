@@ -2,15 +2,13 @@ package bind
 
 import (
 	"net"
-	"reflect"
 	"syscall"
 )
 
-func BindToDevice(conn net.UDPConn, device string) error {
-	ptrVal := reflect.ValueOf(conn)
-	fdmember := reflect.Indirect(ptrVal).FieldByName("fd")
-	pfdmember := reflect.Indirect(fdmember).FieldByName("pfd")
-	netfdmember := reflect.Indirect(pfdmember).FieldByName("Sysfd")
-	fd := int(netfdmember.Int())
-	return syscall.SetsockoptString(fd, syscall.SOL_SOCKET, syscall.IP_BOUND_IF, device)
+func bindToDevice(fd int, device string) error {
+	iface, err := net.InterfaceByName(device)
+	if err != nil {
+		return err
+	}
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_BOUND_IF, iface.Index)
 }
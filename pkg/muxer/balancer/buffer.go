@@ -0,0 +1,118 @@
+package balancer
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/rtp"
+)
+
+// packetBufferSize fits any packet that has been fragmented to the mtu.
+const packetBufferSize = 1500
+
+var packetBufferPool = sync.Pool{
+	New: func() interface{} {
+		return &packetBuffer{buf: make([]byte, packetBufferSize)}
+	},
+}
+
+// wirePacketPool holds the per-write copies of packet headers handed to the
+// tracks.
+var wirePacketPool = sync.Pool{
+	New: func() interface{} {
+		return &rtp.Packet{}
+	},
+}
+
+// packetBuffer is a marshalled RTP packet shared by the send buffer, the
+// pacer and retransmissions. The packet is marshalled once and its parsed
+// form aliases the same bytes. The buffer returns to the pool when the last
+// reference is released.
+type packetBuffer struct {
+	refs int32
+	buf  []byte
+	n    int
+	pkt  rtp.Packet
+	// rtx is set on retransmissions and repair on FEC repair packets, they're
+	// written with their own ssrc and payload type.
+	rtx    bool
+	repair bool
+}
+
+// newPacketBuffer marshals pkt into a pooled buffer with one reference.
+func newPacketBuffer(pkt *rtp.Packet) (*packetBuffer, error) {
+	b := getPacketBuffer(pkt.MarshalSize())
+	n, err := pkt.MarshalTo(b.buf)
+	if err != nil {
+		b.Release()
+		return nil, err
+	}
+	return b, b.parse(n)
+}
+
+// newRTXPacketBuffer builds an RFC 4588 retransmission of pkt with the given
+// header, the original sequence number is prepended to the payload.
+func newRTXPacketBuffer(header *rtp.Header, pkt *rtp.Packet) (*packetBuffer, error) {
+	b := getPacketBuffer(header.MarshalSize() + 2 + len(pkt.Payload))
+	n, err := header.MarshalTo(b.buf)
+	if err != nil {
+		b.Release()
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(b.buf[n:], pkt.SequenceNumber)
+	n += 2
+	n += copy(b.buf[n:], pkt.Payload)
+	b.rtx = true
+	return b, b.parse(n)
+}
+
+func getPacketBuffer(size int) *packetBuffer {
+	b := packetBufferPool.Get().(*packetBuffer)
+	if cap(b.buf) < size {
+		b.buf = make([]byte, size)
+	}
+	b.buf = b.buf[:cap(b.buf)]
+	b.refs = 1
+	b.rtx = false
+	b.repair = false
+	return b
+}
+
+func (b *packetBuffer) parse(n int) error {
+	b.n = n
+	if err := b.pkt.Unmarshal(b.buf[:n]); err != nil {
+		b.Release()
+		return err
+	}
+	return nil
+}
+
+// Retain adds a reference and returns the buffer.
+func (b *packetBuffer) Retain() *packetBuffer {
+	atomic.AddInt32(&b.refs, 1)
+	return b
+}
+
+// Release drops a reference, the buffer must not be used afterwards.
+func (b *packetBuffer) Release() {
+	if atomic.AddInt32(&b.refs, -1) == 0 {
+		packetBufferPool.Put(b)
+	}
+}
+
+// Packet returns the parsed packet, it must not be modified.
+func (b *packetBuffer) Packet() *rtp.Packet {
+	return &b.pkt
+}
+
+// Len returns the marshalled size.
+func (b *packetBuffer) Len() int {
+	return b.n
+}
+
+// SetSequenceNumber rewrites the sequence number in both forms.
+func (b *packetBuffer) SetSequenceNumber(seq uint16) {
+	binary.BigEndian.PutUint16(b.buf[2:4], seq)
+	b.pkt.SequenceNumber = seq
+}
@@ -0,0 +1,65 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// clockReanchorInterval is how often a mediaClock moves its anchor to the
+// best packet of the last interval, bounding the drift between the media and
+// wall clocks.
+const clockReanchorInterval = 10 * time.Second
+
+// mediaClock maps the RTP timestamps of a source to wall clock times. The
+// timestamps are unwrapped incrementally and the mapping is anchored to the
+// packet written soonest relative to its timestamp, so later packets that
+// spent longer in the pipeline don't shift it.
+type mediaClock struct {
+	sync.Mutex
+
+	clockRate uint32
+
+	anchor    time.Time
+	candidate time.Time
+	window    time.Time
+	last      uint32
+	elapsed   int64
+	started   bool
+}
+
+func newMediaClock(clockRate uint32) *mediaClock {
+	return &mediaClock{clockRate: clockRate}
+}
+
+// Time returns the wall clock time of the media time timestamp, given that a
+// packet with that timestamp is seen at now.
+func (c *mediaClock) Time(timestamp uint32, now time.Time) time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.started {
+		c.anchor, c.candidate, c.window = now, now, now
+		c.last = timestamp
+		c.started = true
+		return now
+	}
+	// unwrap the timestamp relative to the last one seen, this also handles
+	// older timestamps such as retransmissions.
+	c.elapsed += int64(int32(timestamp - c.last))
+	c.last = timestamp
+
+	media := time.Duration(c.elapsed) * time.Second / time.Duration(c.clockRate)
+	anchor := now.Add(-media)
+	if anchor.Before(c.candidate) {
+		c.candidate = anchor
+	}
+	if anchor.Before(c.anchor) {
+		c.anchor = anchor
+	}
+	if now.Sub(c.window) >= clockReanchorInterval {
+		c.anchor = c.candidate
+		c.candidate = anchor
+		c.window = now
+	}
+	return c.anchor.Add(media)
+}
@@ -0,0 +1,51 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMediaClockWrap(t *testing.T) {
+	c := newMediaClock(90000)
+	now := time.Unix(0, 0)
+	ts := uint32(1<<32 - 90000*10)
+	// run for nine hours, well past 2^31 ticks at 90 kHz.
+	for i := 0; i < 9*3600; i++ {
+		got := c.Time(ts, now)
+		if d := now.Sub(got); d < 0 || d > time.Millisecond {
+			t.Fatalf("after %v got %v, want %v", now.Sub(time.Unix(0, 0)), got, now)
+		}
+		ts += 90000
+		now = now.Add(time.Second)
+	}
+}
+
+func TestMediaClockOldTimestamp(t *testing.T) {
+	c := newMediaClock(90000)
+	now := time.Unix(0, 0)
+	c.Time(0, now)
+	c.Time(90000, now.Add(time.Second))
+	// a retransmission looks up an older timestamp.
+	if got := c.Time(45000, now.Add(2*time.Second)); !got.Equal(now.Add(500 * time.Millisecond)) {
+		t.Errorf("got %v, want %v", got, now.Add(500*time.Millisecond))
+	}
+	if got := c.Time(180000, now.Add(2*time.Second)); !got.Equal(now.Add(2 * time.Second)) {
+		t.Errorf("got %v, want %v", got, now.Add(2*time.Second))
+	}
+}
+
+func TestMediaClockDrift(t *testing.T) {
+	c := newMediaClock(90000)
+	now := time.Unix(0, 0)
+	ts := uint32(0)
+	// the media clock runs 0.1% slow, so packets arrive later and later
+	// relative to their timestamps.
+	for i := 0; i < 3600*10; i++ {
+		got := c.Time(ts, now)
+		if lag := now.Sub(got); lag > 2*clockReanchorInterval/1000+time.Millisecond {
+			t.Fatalf("after %v the clock lags by %v", now.Sub(time.Unix(0, 0)), lag)
+		}
+		ts += 89910 / 10
+		now = now.Add(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,239 @@
+package balancer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/muxable/rtpmagic/pkg/muxer/fec"
+	"github.com/muxable/rtpmagic/pkg/muxer/red"
+	"github.com/pion/webrtc/v3"
+)
+
+// Codec is a codec the balancer can negotiate. Payload types are fixed so that
+// every path, and the retransmission and redundancy streams, agree on them.
+type Codec struct {
+	webrtc.RTPCodecParameters
+	Kind webrtc.RTPCodecType
+	// RTXPayloadType is the payload type of RFC 4588 retransmissions of a
+	// video codec.
+	RTXPayloadType webrtc.PayloadType
+}
+
+var videoFeedback = []webrtc.RTCPFeedback{{Type: "goog-remb"}, {Type: "ccm", Parameter: "fir"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"}}
+
+// Payload types of the opus codec and of the redundancy and repair streams,
+// which receivers register to decode them.
+const (
+	OpusPayloadType    = 97
+	REDPayloadType     = 63
+	FlexFECPayloadType = 118
+)
+
+// FlexFECCodec is the codec of the FlexFEC repair stream.
+var FlexFECCodec = webrtc.RTPCodecParameters{
+	RTPCodecCapability: webrtc.RTPCodecCapability{
+		MimeType:    fec.MimeTypeFlexFEC,
+		ClockRate:   90000,
+		SDPFmtpLine: "repair-window=10000000",
+	},
+	PayloadType: FlexFECPayloadType,
+}
+
+// REDCodec returns the RED codec carrying depth redundant opus frames.
+func REDCodec(depth int) webrtc.RTPCodecParameters {
+	return webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    red.MimeTypeRED,
+			ClockRate:   48000,
+			Channels:    2,
+			SDPFmtpLine: red.FmtpLine(OpusPayloadType, depth),
+		},
+		PayloadType: REDPayloadType,
+	}
+}
+
+// Codecs are the supported codecs in the default order of preference.
+var Codecs = []Codec{
+	{
+		RTPCodecParameters: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH265, ClockRate: 90000, RTCPFeedback: videoFeedback},
+			PayloadType:        96,
+		},
+		Kind:           webrtc.RTPCodecTypeVideo,
+		RTXPayloadType: 99,
+	},
+	{
+		RTPCodecParameters: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeAV1, ClockRate: 90000, RTCPFeedback: videoFeedback},
+			PayloadType:        45,
+		},
+		Kind:           webrtc.RTPCodecTypeVideo,
+		RTXPayloadType: 46,
+	},
+	{
+		RTPCodecParameters: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000, SDPFmtpLine: "profile-id=0", RTCPFeedback: videoFeedback},
+			PayloadType:        100,
+		},
+		Kind:           webrtc.RTPCodecTypeVideo,
+		RTXPayloadType: 101,
+	},
+	{
+		RTPCodecParameters: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f", RTCPFeedback: videoFeedback},
+			PayloadType:        102,
+		},
+		Kind:           webrtc.RTPCodecTypeVideo,
+		RTXPayloadType: 103,
+	},
+	{
+		RTPCodecParameters: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000, RTCPFeedback: videoFeedback},
+			PayloadType:        104,
+		},
+		Kind:           webrtc.RTPCodecTypeVideo,
+		RTXPayloadType: 105,
+	},
+	{
+		RTPCodecParameters: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+			PayloadType:        OpusPayloadType,
+		},
+		Kind: webrtc.RTPCodecTypeAudio,
+	},
+	{
+		RTPCodecParameters: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000},
+			PayloadType:        0,
+		},
+		Kind: webrtc.RTPCodecTypeAudio,
+	},
+	{
+		RTPCodecParameters: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000},
+			PayloadType:        8,
+		},
+		Kind: webrtc.RTPCodecTypeAudio,
+	},
+}
+
+// LookupCodec returns the supported codec with the mime type.
+func LookupCodec(mimeType string) (Codec, bool) {
+	for _, c := range Codecs {
+		if strings.EqualFold(c.MimeType, mimeType) {
+			return c, true
+		}
+	}
+	return Codec{}, false
+}
+
+// RTXCodec returns the retransmission codec of a video codec.
+func (c Codec) RTXCodec() webrtc.RTPCodecCapability {
+	return webrtc.RTPCodecCapability{
+		MimeType:    "video/rtx",
+		ClockRate:   c.ClockRate,
+		SDPFmtpLine: fmt.Sprintf("apt=%d", c.PayloadType),
+	}
+}
+
+// registerCodecs registers the group's codecs along with their retransmission
+// and redundancy codecs.
+func (mpcg *ManagedPeerConnectionGroup) registerCodecs(m *webrtc.MediaEngine) error {
+	for _, c := range mpcg.codecs {
+		if err := m.RegisterCodec(c.RTPCodecParameters, c.Kind); err != nil {
+			return err
+		}
+		if mpcg.rtx && c.RTXPayloadType != 0 {
+			if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+				RTPCodecCapability: c.RTXCodec(),
+				PayloadType:        c.RTXPayloadType,
+			}, webrtc.RTPCodecTypeVideo); err != nil {
+				return err
+			}
+		}
+		if mpcg.redDepth > 0 && strings.EqualFold(c.MimeType, webrtc.MimeTypeOpus) {
+			if err := m.RegisterCodec(REDCodec(mpcg.redDepth), webrtc.RTPCodecTypeAudio); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// audioPayloadTypes returns the payload types that carry audio.
+func (mpcg *ManagedPeerConnectionGroup) audioPayloadTypes() map[uint8]bool {
+	pts := make(map[uint8]bool)
+	for _, c := range mpcg.codecs {
+		if c.Kind != webrtc.RTPCodecTypeAudio {
+			continue
+		}
+		pts[uint8(c.PayloadType)] = true
+		if mpcg.redDepth > 0 && strings.EqualFold(c.MimeType, webrtc.MimeTypeOpus) {
+			pts[REDPayloadType] = true
+		}
+	}
+	return pts
+}
+
+// rtxPayloadTypes returns the payload types of the registered retransmission
+// codecs.
+func (mpcg *ManagedPeerConnectionGroup) rtxPayloadTypes() []uint8 {
+	if !mpcg.rtx {
+		return nil
+	}
+	var pts []uint8
+	for _, c := range mpcg.codecs {
+		if c.RTXPayloadType != 0 {
+			pts = append(pts, uint8(c.RTXPayloadType))
+		}
+	}
+	return pts
+}
+
+// updateRemoteCodecs records the codecs the remote answered with, in its order
+// of preference. Only the first negotiated path is used since the encoders
+// can't change codec afterwards.
+func (mpcg *ManagedPeerConnectionGroup) updateRemoteCodecs(pc *webrtc.PeerConnection) {
+	if pc.RemoteDescription() == nil || pc.RemoteDescription().Type != webrtc.SDPTypeAnswer {
+		return
+	}
+	codecs := make(map[webrtc.RTPCodecType][]webrtc.RTPCodecParameters)
+	for _, t := range pc.GetTransceivers() {
+		if r := t.Receiver(); r != nil && codecs[t.Kind()] == nil {
+			codecs[t.Kind()] = r.GetParameters().Codecs
+		}
+	}
+	mpcg.negotiatedOnce.Do(func() {
+		mpcg.remoteCodecs = codecs
+		close(mpcg.negotiated)
+	})
+}
+
+// RemoteCodecs returns the codecs of the kind the remote accepted in its order
+// of preference, waiting up to timeout for a path to negotiate. It returns nil
+// if none did.
+func (mpcg *ManagedPeerConnectionGroup) RemoteCodecs(kind webrtc.RTPCodecType, timeout time.Duration) []webrtc.RTPCodecParameters {
+	select {
+	case <-mpcg.negotiated:
+		return mpcg.remoteCodecs[kind]
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+// remoteAccepts reports whether the remote answered with the codec on the
+// first negotiated path, it's false until a path has negotiated.
+func (mpcg *ManagedPeerConnectionGroup) remoteAccepts(kind webrtc.RTPCodecType, mimeType string) bool {
+	select {
+	case <-mpcg.negotiated:
+	default:
+		return false
+	}
+	for _, c := range mpcg.remoteCodecs[kind] {
+		if strings.EqualFold(c.MimeType, mimeType) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package balancer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/muxable/rtpmagic/pkg/muxer/scream"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+)
+
+// CongestionController selects the bandwidth estimator used on an interface.
+type CongestionController int
+
+const (
+	// GCC is Google congestion control, which reacts to delay gradients.
+	GCC CongestionController = iota
+	// SCReAM is the RFC 8298 self-clocked controller, which steers the
+	// queuing delay and copes better with the deep buffers of cellular links.
+	SCReAM
+)
+
+func (c CongestionController) String() string {
+	switch c {
+	case GCC:
+		return "gcc"
+	case SCReAM:
+		return "scream"
+	}
+	return fmt.Sprintf("CongestionController(%d)", int(c))
+}
+
+// newEstimator creates a bandwidth estimator starting at the initial bitrate.
+func (c CongestionController) newEstimator(initialBitrate int) (cc.BandwidthEstimator, error) {
+	switch c {
+	case GCC:
+		return gcc.NewSendSideBWE(gcc.SendSideBWEInitialBitrate(initialBitrate))
+	case SCReAM:
+		return scream.NewEstimator(scream.InitialBitrate(initialBitrate))
+	}
+	return nil, fmt.Errorf("unknown congestion controller %v", c)
+}
+
+// ParseCongestionControllers parses comma separated per-interface congestion
+// controllers of the form IFACE=NAME, for example "usb0=scream,*=gcc". The "*"
+// interface sets the default for interfaces that aren't listed.
+func ParseCongestionControllers(s string) (map[string]CongestionController, error) {
+	controllers := make(map[string]CongestionController)
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid congestion controller %q", kv)
+		}
+		switch name := kv[i+1:]; name {
+		case "gcc":
+			controllers[kv[:i]] = GCC
+		case "scream":
+			controllers[kv[:i]] = SCReAM
+		default:
+			return nil, fmt.Errorf("unknown congestion controller %q", name)
+		}
+	}
+	return controllers, nil
+}
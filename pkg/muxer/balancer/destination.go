@@ -0,0 +1,78 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// resolveDestinations expands the configured destinations into an ordered list
+// of host:port addresses. Destinations starting with an underscore are DNS SRV
+// names, for example "_sfu._tcp.example.com", and expand to their targets in
+// priority order.
+func resolveDestinations(destinations []string) ([]string, error) {
+	var addrs []string
+	for _, dest := range destinations {
+		if !strings.HasPrefix(dest, "_") {
+			addrs = append(addrs, dest)
+			continue
+		}
+		_, records, err := net.LookupSRV("", "", dest)
+		if err != nil {
+			log.Warn().Err(err).Str("Destination", dest).Msg("failed to resolve srv record")
+			continue
+		}
+		for _, record := range records {
+			addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), fmt.Sprint(record.Port)))
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no destinations resolved from %v", destinations)
+	}
+	return addrs, nil
+}
+
+// signalFailed reports whether the signalling channel of the connection has
+// failed.
+func (pc *ManagedPeerConnection) signalFailed() bool {
+	return atomic.LoadInt32(&pc.signalState) == 1
+}
+
+func (pc *ManagedPeerConnection) setSignalFailed() {
+	atomic.StoreInt32(&pc.signalState, 1)
+}
+
+// failover moves the group to the next destination if signalling has failed
+// on every path. The connections are torn down and republished with all
+// sources by the next device poll. The caller must hold the group lock.
+func (mpcg *ManagedPeerConnectionGroup) failover() {
+	if len(mpcg.conns) == 0 {
+		return
+	}
+	for _, conn := range mpcg.conns {
+		if !conn.signalFailed() {
+			return
+		}
+	}
+
+	mpcg.addrIndex++
+	if mpcg.addrIndex >= len(mpcg.addrs) {
+		// start over, re-resolving in case the srv records changed.
+		if addrs, err := resolveDestinations(mpcg.destinations); err == nil {
+			mpcg.addrs = addrs
+		}
+		mpcg.addrIndex = 0
+	}
+	next := mpcg.addrs[mpcg.addrIndex]
+	log.Warn().Str("From", mpcg.addr).Str("To", next).Msg("signalling failed on all paths, failing over")
+
+	for device := range mpcg.conns {
+		if err := mpcg.removeDevice(device); err != nil {
+			log.Error().Msgf("failed to remove device %s: %v", device, err)
+		}
+	}
+	mpcg.addr = next
+}
@@ -0,0 +1,359 @@
+package balancer
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// maxUnitHeader is the longest payload header repeated in each unit, a VP9
+// payload descriptor without its scalability structure.
+const maxUnitHeader = 8
+
+// fragmentUnit is one packet of a fragmented payload: a payload header
+// followed by a slice of the original payload.
+type fragmentUnit struct {
+	header [maxUnitHeader]byte
+	n      int
+	data   []byte
+}
+
+// fragment splits a video payload that exceeds max into units that fit, using
+// each codec's fragmentation scheme, and appends them to units. H.264 and
+// H.265 aggregation packets are split into their units first. It returns
+// units unchanged for other codecs and payloads that already fit. The units
+// alias payload so they're marshalled straight into the packet buffers.
+func fragment(codec webrtc.RTPCodecCapability, payload []byte, max int, units []fragmentUnit) []fragmentUnit {
+	if len(payload) <= max {
+		return units
+	}
+	switch {
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeH265):
+		return fragmentH265(payload, max, units)
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeH264):
+		return fragmentH264(payload, max, units)
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP8):
+		return fragmentVP8(payload, max, units)
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP9):
+		return fragmentVP9(payload, max, units)
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeAV1):
+		return fragmentAV1(payload, max, units)
+	}
+	return units
+}
+
+// newFragmentBuffer marshals a packet with the given header and unit into a
+// pooled buffer with one reference.
+func newFragmentBuffer(header *rtp.Header, u fragmentUnit) (*packetBuffer, error) {
+	b := getPacketBuffer(header.MarshalSize() + u.n + len(u.data))
+	n, err := header.MarshalTo(b.buf)
+	if err != nil {
+		b.Release()
+		return nil, err
+	}
+	n += copy(b.buf[n:], u.header[:u.n])
+	n += copy(b.buf[n:], u.data)
+	return b, b.parse(n)
+}
+
+// nextAggregated returns the next unit of an aggregation packet, which are
+// each prefixed with a 16 bit size, starting at offset and the offset after
+// it. ok is false once there are no more units.
+func nextAggregated(payload []byte, offset int) (unit []byte, next int, ok bool) {
+	if offset+2 > len(payload) {
+		return nil, offset, false
+	}
+	size := int(binary.BigEndian.Uint16(payload[offset:]))
+	offset += 2
+	if offset+size > len(payload) {
+		return nil, offset, false
+	}
+	return payload[offset : offset+size], offset + size, true
+}
+
+// appendFragments splits data into chunks of at most size bytes, each behind
+// a copy of header whose byte at flags gets the start and end bits.
+func appendFragments(units []fragmentUnit, header [maxUnitHeader]byte, n, flags int, data []byte, size int, start, end byte) []fragmentUnit {
+	for first := true; ; first = false {
+		u := fragmentUnit{header: header, n: n}
+		if first {
+			u.header[flags] |= start
+		}
+		if len(data) <= size {
+			u.header[flags] |= end
+			u.data = data
+			return append(units, u)
+		}
+		u.data = data[:size]
+		data = data[size:]
+		units = append(units, u)
+	}
+}
+
+// fragmentH265 fragments according to RFC 7798 section 4.4.3.
+func fragmentH265(payload []byte, max int, units []fragmentUnit) []fragmentUnit {
+	if len(payload) <= max || len(payload) < 3 || max <= 3 {
+		return append(units, fragmentUnit{n: 0, data: payload})
+	}
+	naluType := (payload[0] >> 1) & 0x3f
+	switch naluType {
+	case 48: // aggregation packet
+		for unit, offset, ok := nextAggregated(payload, 2); ok; unit, offset, ok = nextAggregated(payload, offset) {
+			units = fragmentH265(unit, max, units)
+		}
+		return units
+	case 49: // fragmentation unit
+		header := [maxUnitHeader]byte{payload[0], payload[1], payload[2] & 0x3f}
+		return appendFragments(units, header, 3, 2, payload[3:], max-3, payload[2]&0x80, payload[2]&0x40)
+	default:
+		header := [maxUnitHeader]byte{payload[0]&0x81 | 49<<1, payload[1], naluType}
+		return appendFragments(units, header, 3, 2, payload[2:], max-3, 0x80, 0x40)
+	}
+}
+
+// fragmentH264 fragments according to RFC 6184 section 5.8.
+func fragmentH264(payload []byte, max int, units []fragmentUnit) []fragmentUnit {
+	if len(payload) <= max || len(payload) < 2 || max <= 2 {
+		return append(units, fragmentUnit{n: 0, data: payload})
+	}
+	naluType := payload[0] & 0x1f
+	switch naluType {
+	case 24: // STAP-A
+		for unit, offset, ok := nextAggregated(payload, 1); ok; unit, offset, ok = nextAggregated(payload, offset) {
+			units = fragmentH264(unit, max, units)
+		}
+		return units
+	case 28: // FU-A
+		header := [maxUnitHeader]byte{payload[0], payload[1] & 0x1f}
+		return appendFragments(units, header, 2, 1, payload[2:], max-2, payload[1]&0x80, payload[1]&0x40)
+	default:
+		header := [maxUnitHeader]byte{payload[0]&0xe0 | 28, naluType}
+		return appendFragments(units, header, 2, 1, payload[1:], max-2, 0x80, 0x40)
+	}
+}
+
+// vp8DescriptorSize returns the size of the RFC 7741 section 4.2 payload
+// descriptor, zero if it's malformed.
+func vp8DescriptorSize(payload []byte) int {
+	if len(payload) < 1 {
+		return 0
+	}
+	n := 1
+	if payload[0]&0x80 != 0 {
+		if len(payload) < 2 {
+			return 0
+		}
+		x := payload[1]
+		n = 2
+		if x&0x80 != 0 {
+			// the picture id is 15 bits if M is set.
+			if len(payload) <= n {
+				return 0
+			}
+			if payload[n]&0x80 != 0 {
+				n += 2
+			} else {
+				n++
+			}
+		}
+		if x&0x40 != 0 {
+			n++
+		}
+		if x&0x30 != 0 {
+			n++
+		}
+	}
+	if n > len(payload) {
+		return 0
+	}
+	return n
+}
+
+// fragmentVP8 fragments according to RFC 7741 section 4.4, the descriptor is
+// repeated with the start of partition bit only set on the first unit.
+func fragmentVP8(payload []byte, max int, units []fragmentUnit) []fragmentUnit {
+	n := vp8DescriptorSize(payload)
+	if n == 0 || n > maxUnitHeader || max <= n {
+		return append(units, fragmentUnit{n: 0, data: payload})
+	}
+	var header [maxUnitHeader]byte
+	copy(header[:], payload[:n])
+	header[0] &^= 0x10
+	return appendFragments(units, header, n, 0, payload[n:], max-n, payload[0]&0x10, 0)
+}
+
+// vp9DescriptorSize returns the size of the payload descriptor of
+// draft-ietf-payload-vp9 section 4.2 without and with its scalability
+// structure, zero if it's malformed.
+func vp9DescriptorSize(payload []byte) (short, full int) {
+	if len(payload) < 1 {
+		return 0, 0
+	}
+	b := payload[0]
+	n := 1
+	if b&0x80 != 0 { // I
+		if len(payload) <= n {
+			return 0, 0
+		}
+		if payload[n]&0x80 != 0 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	if b&0x20 != 0 { // L, with TL0PICIDX in non-flexible mode.
+		n++
+		if b&0x10 == 0 {
+			n++
+		}
+	}
+	if b&0x50 == 0x50 { // F and P, up to three reference indices.
+		for i := 0; i < 3; i++ {
+			if len(payload) <= n {
+				return 0, 0
+			}
+			n++
+			if payload[n-1]&0x01 == 0 {
+				break
+			}
+		}
+	}
+	if n > len(payload) {
+		return 0, 0
+	}
+	short = n
+	if b&0x02 != 0 { // V
+		if len(payload) <= n {
+			return 0, 0
+		}
+		ss := payload[n]
+		n++
+		if ss&0x10 != 0 { // Y, the resolution of each spatial layer.
+			n += 4 * int(ss>>5+1)
+		}
+		if ss&0x08 != 0 { // G, the picture group description.
+			if len(payload) <= n {
+				return 0, 0
+			}
+			pictures := int(payload[n])
+			n++
+			for i := 0; i < pictures; i++ {
+				if len(payload) <= n {
+					return 0, 0
+				}
+				n += 1 + int(payload[n]>>2&0x03)
+			}
+		}
+	}
+	if n > len(payload) {
+		return 0, 0
+	}
+	return short, n
+}
+
+// fragmentVP9 fragments a VP9 frame across packets. The first unit keeps the
+// whole descriptor, the others repeat it without the scalability structure.
+// The start of frame bit is only set on the first unit and the end of frame
+// bit on the last.
+func fragmentVP9(payload []byte, max int, units []fragmentUnit) []fragmentUnit {
+	short, full := vp9DescriptorSize(payload)
+	if short == 0 || short > maxUnitHeader || max <= full || max <= short {
+		return append(units, fragmentUnit{n: 0, data: payload})
+	}
+	first := fragmentUnit{n: 1, data: payload[1:max]}
+	first.header[0] = payload[0] &^ 0x04
+	units = append(units, first)
+
+	var header [maxUnitHeader]byte
+	copy(header[:], payload[:short])
+	header[0] &^= 0x08 | 0x04 | 0x02
+	return appendFragments(units, header, short, 0, payload[max:], max-short, 0, payload[0]&0x04)
+}
+
+// readLEB128 decodes an unsigned LEB128 value and returns it and its size,
+// the size is zero if it's malformed.
+func readLEB128(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 8 && i < len(b); i++ {
+		v |= uint64(b[i]&0x7f) << (7 * i)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// nextOBUElement returns the next OBU element of an AV1 payload starting at
+// offset and the offset after it. The last of w elements has no length field,
+// with w zero they all do. ok is false once there are no more elements and
+// malformed is set if the lengths overrun the payload.
+func nextOBUElement(payload []byte, offset, index, w int) (element []byte, next int, ok, malformed bool) {
+	if offset >= len(payload) {
+		return nil, offset, false, false
+	}
+	if w != 0 && index == w-1 {
+		return payload[offset:], len(payload), true, false
+	}
+	size, n := readLEB128(payload[offset:])
+	if n == 0 || uint64(len(payload)-offset-n) < size {
+		return nil, offset, false, true
+	}
+	next = offset + n + int(size)
+	return payload[offset+n : next], next, true, false
+}
+
+// fragmentAV1 fragments according to the AV1 RTP specification section 4.
+// Each unit carries part of a single OBU element, so the aggregation header
+// has W set to one, with Z and Y marking the elements that continue from the
+// previous unit and into the next one.
+func fragmentAV1(payload []byte, max int, units []fragmentUnit) []fragmentUnit {
+	if len(payload) < 2 || max <= 1 {
+		return append(units, fragmentUnit{n: 0, data: payload})
+	}
+	aggregation := payload[0]
+	w := int(aggregation >> 4 & 0x03)
+
+	// check the lengths first so a malformed payload is sent as is.
+	count := 0
+	for _, offset, ok, malformed := nextOBUElement(payload, 1, 0, w); ; _, offset, ok, malformed = nextOBUElement(payload, offset, count, w) {
+		if malformed {
+			return append(units, fragmentUnit{n: 0, data: payload})
+		}
+		if !ok {
+			break
+		}
+		count++
+	}
+
+	size := max - 1
+	index := 0
+	for element, offset, ok, _ := nextOBUElement(payload, 1, 0, w); ok; element, offset, ok, _ = nextOBUElement(payload, offset, index, w) {
+		for first := true; ; first = false {
+			u := fragmentUnit{n: 1}
+			u.header[0] = 1 << 4
+			if index == 0 && first {
+				// the new coded video sequence bit belongs on the first unit.
+				u.header[0] |= aggregation & 0x08
+			}
+			if !first || index == 0 && aggregation&0x80 != 0 {
+				u.header[0] |= 0x80
+			}
+			last := len(element) <= size
+			if !last || index == count-1 && aggregation&0x40 != 0 {
+				u.header[0] |= 0x40
+			}
+			if last {
+				u.data = element
+				units = append(units, u)
+				break
+			}
+			u.data = element[:size]
+			element = element[size:]
+			units = append(units, u)
+		}
+		index++
+	}
+	return units
+}
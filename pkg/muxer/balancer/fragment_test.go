@@ -0,0 +1,292 @@
+package balancer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+// payloadOf returns the payload of the packet carrying u.
+func payloadOf(u fragmentUnit) []byte {
+	return append(append([]byte(nil), u.header[:u.n]...), u.data...)
+}
+
+// sequentialBytes returns n bytes that differ from their neighbours so
+// misordered fragments are noticed.
+func sequentialBytes(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+func checkSizes(t *testing.T, units []fragmentUnit, max int) {
+	t.Helper()
+	for i, u := range units {
+		if size := u.n + len(u.data); size > max {
+			t.Errorf("unit %d is %d bytes, more than %d", i, size, max)
+		}
+	}
+}
+
+// reassembleH264 returns the NAL units carried by single NAL unit and FU-A
+// packets.
+func reassembleH264(t *testing.T, units []fragmentUnit) [][]byte {
+	var nalus [][]byte
+	var fu []byte
+	for i, u := range units {
+		p := payloadOf(u)
+		if p[0]&0x1f != 28 {
+			nalus = append(nalus, p)
+			continue
+		}
+		indicator, header := p[0], p[1]
+		if header&0x80 != 0 {
+			fu = []byte{indicator&0xe0 | header&0x1f}
+		} else if fu == nil {
+			t.Fatalf("unit %d continues a fragment that wasn't started", i)
+		}
+		fu = append(fu, p[2:]...)
+		if header&0x40 != 0 {
+			nalus = append(nalus, fu)
+			fu = nil
+		}
+	}
+	if fu != nil {
+		t.Fatal("last fragment isn't marked as the end")
+	}
+	return nalus
+}
+
+// reassembleH265 returns the NAL units carried by single NAL unit and
+// fragmentation unit packets.
+func reassembleH265(t *testing.T, units []fragmentUnit) [][]byte {
+	var nalus [][]byte
+	var fu []byte
+	for i, u := range units {
+		p := payloadOf(u)
+		if p[0]>>1&0x3f != 49 {
+			nalus = append(nalus, p)
+			continue
+		}
+		header := p[2]
+		if header&0x80 != 0 {
+			fu = []byte{p[0]&0x81 | (header&0x3f)<<1, p[1]}
+		} else if fu == nil {
+			t.Fatalf("unit %d continues a fragment that wasn't started", i)
+		}
+		fu = append(fu, p[3:]...)
+		if header&0x40 != 0 {
+			nalus = append(nalus, fu)
+			fu = nil
+		}
+	}
+	if fu != nil {
+		t.Fatal("last fragment isn't marked as the end")
+	}
+	return nalus
+}
+
+func TestFragmentH264(t *testing.T) {
+	const max = 1000
+	codec := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}
+	idr := append([]byte{0x65}, sequentialBytes(5000)...)
+	sps := []byte{0x67, 0x42, 0xc0, 0x1f}
+
+	t.Run("Single", func(t *testing.T) {
+		units := fragment(codec, idr, max, nil)
+		checkSizes(t, units, max)
+		nalus := reassembleH264(t, units)
+		if len(nalus) != 1 || !bytes.Equal(nalus[0], idr) {
+			t.Errorf("reassembled %d nal units that don't match", len(nalus))
+		}
+	})
+
+	t.Run("STAP-A", func(t *testing.T) {
+		stap := []byte{24, 0, byte(len(sps))}
+		stap = append(stap, sps...)
+		stap = append(stap, byte(len(idr)>>8), byte(len(idr)))
+		stap = append(stap, idr...)
+		units := fragment(codec, stap, max, nil)
+		checkSizes(t, units, max)
+		nalus := reassembleH264(t, units)
+		if len(nalus) != 2 || !bytes.Equal(nalus[0], sps) || !bytes.Equal(nalus[1], idr) {
+			t.Errorf("reassembled %d nal units that don't match", len(nalus))
+		}
+	})
+
+	t.Run("FU-A", func(t *testing.T) {
+		// a fragment from an encoder with a larger mtu is fragmented again.
+		fua := append([]byte{0x60 | 28, 0x80 | 5}, idr[1:]...)
+		units := fragment(codec, fua, max, nil)
+		checkSizes(t, units, max)
+		var data []byte
+		for i, u := range units {
+			p := payloadOf(u)
+			if p[0] != fua[0] || p[1]&0x1f != 5 || (p[1]&0x80 != 0) != (i == 0) || p[1]&0x40 != 0 {
+				t.Errorf("unit %d has fu indicator %x and header %x", i, p[0], p[1])
+			}
+			data = append(data, p[2:]...)
+		}
+		if !bytes.Equal(data, fua[2:]) {
+			t.Error("reassembled fragment doesn't match")
+		}
+	})
+
+	t.Run("Fits", func(t *testing.T) {
+		if units := fragment(codec, sps, max, nil); len(units) != 0 {
+			t.Errorf("a payload that fits was split into %d units", len(units))
+		}
+	})
+}
+
+func TestFragmentH265(t *testing.T) {
+	const max = 1000
+	codec := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH265}
+	idr := append([]byte{19 << 1, 0x01}, sequentialBytes(5000)...)
+	vps := []byte{32 << 1, 0x01, 0x0c, 0x01}
+
+	t.Run("Single", func(t *testing.T) {
+		units := fragment(codec, idr, max, nil)
+		checkSizes(t, units, max)
+		nalus := reassembleH265(t, units)
+		if len(nalus) != 1 || !bytes.Equal(nalus[0], idr) {
+			t.Errorf("reassembled %d nal units that don't match", len(nalus))
+		}
+	})
+
+	t.Run("AggregationPacket", func(t *testing.T) {
+		ap := []byte{48 << 1, 0x01, 0, byte(len(vps))}
+		ap = append(ap, vps...)
+		ap = append(ap, byte(len(idr)>>8), byte(len(idr)))
+		ap = append(ap, idr...)
+		units := fragment(codec, ap, max, nil)
+		checkSizes(t, units, max)
+		nalus := reassembleH265(t, units)
+		if len(nalus) != 2 || !bytes.Equal(nalus[0], vps) || !bytes.Equal(nalus[1], idr) {
+			t.Errorf("reassembled %d nal units that don't match", len(nalus))
+		}
+	})
+}
+
+func TestFragmentVP8(t *testing.T) {
+	const max = 1000
+	// a 15 bit picture id and the start of partition zero.
+	descriptor := []byte{0x90, 0x80, 0x80 | 0x12, 0x34}
+	frame := sequentialBytes(3000)
+	units := fragment(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, append(descriptor, frame...), max, nil)
+	checkSizes(t, units, max)
+
+	var reassembled []byte
+	for i, u := range units {
+		p := &codecs.VP8Packet{}
+		payload, err := p.Unmarshal(payloadOf(u))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (p.S == 1) != (i == 0) {
+			t.Errorf("unit %d has start of partition %d", i, p.S)
+		}
+		if p.PictureID != 0x1234 {
+			t.Errorf("unit %d has picture id %x", i, p.PictureID)
+		}
+		reassembled = append(reassembled, payload...)
+	}
+	if !bytes.Equal(reassembled, frame) {
+		t.Error("reassembled frame doesn't match")
+	}
+}
+
+func TestFragmentVP9(t *testing.T) {
+	const max = 1000
+	// a 15 bit picture id and a scalability structure with one 640x480 layer
+	// on a packet that starts and ends the frame.
+	descriptor := []byte{0x8e, 0x81, 0x23, 0x10, 0x02, 0x80, 0x01, 0xe0}
+	frame := sequentialBytes(3000)
+	units := fragment(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9}, append(descriptor, frame...), max, nil)
+	checkSizes(t, units, max)
+
+	var reassembled []byte
+	for i, u := range units {
+		p := &codecs.VP9Packet{}
+		payload, err := p.Unmarshal(payloadOf(u))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.B != (i == 0) || p.E != (i == len(units)-1) || p.V != (i == 0) {
+			t.Errorf("unit %d has B %v E %v V %v", i, p.B, p.E, p.V)
+		}
+		if p.PictureID != 0x0123 {
+			t.Errorf("unit %d has picture id %x", i, p.PictureID)
+		}
+		if i == 0 && (len(p.Width) != 1 || p.Width[0] != 640 || p.Height[0] != 480) {
+			t.Errorf("scalability structure %vx%v", p.Width, p.Height)
+		}
+		reassembled = append(reassembled, payload...)
+	}
+	if !bytes.Equal(reassembled, frame) {
+		t.Error("reassembled frame doesn't match")
+	}
+}
+
+func TestFragmentAV1(t *testing.T) {
+	const max = 1000
+	sequenceHeader := []byte{0x0a, 0x0b, 0x00, 0x00, 0x00, 0x24}
+	frame := sequentialBytes(2500)
+
+	tests := []struct {
+		name        string
+		aggregation byte
+	}{
+		{"NewSequence", 0x20 | 0x08},
+		{"Continuations", 0x20 | 0x80 | 0x40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// two elements, only the first has a length field.
+			payload := append([]byte{tt.aggregation, byte(len(sequenceHeader))}, sequenceHeader...)
+			payload = append(payload, frame...)
+			units := fragment(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeAV1}, payload, max, nil)
+			checkSizes(t, units, max)
+
+			var elements [][]byte
+			for i, u := range units {
+				p := payloadOf(u)
+				header := p[0]
+				if header>>4&0x03 != 1 {
+					t.Fatalf("unit %d has %d elements", i, header>>4&0x03)
+				}
+				if n := header&0x08 != 0; n != (i == 0 && tt.aggregation&0x08 != 0) {
+					t.Errorf("unit %d has the new sequence bit %v", i, n)
+				}
+				z, y := header&0x80 != 0, header&0x40 != 0
+				if i == 0 && z != (tt.aggregation&0x80 != 0) || i == len(units)-1 && y != (tt.aggregation&0x40 != 0) {
+					t.Errorf("unit %d has Z %v Y %v", i, z, y)
+				}
+				if i > 0 && z != (payloadOf(units[i-1])[0]&0x40 != 0) {
+					t.Errorf("unit %d Z %v doesn't match the previous unit's Y", i, z)
+				}
+				if z && len(elements) > 0 {
+					elements[len(elements)-1] = append(elements[len(elements)-1], p[1:]...)
+				} else {
+					elements = append(elements, append([]byte(nil), p[1:]...))
+				}
+			}
+			if len(elements) != 2 || !bytes.Equal(elements[0], sequenceHeader) || !bytes.Equal(elements[1], frame) {
+				t.Errorf("reassembled %d elements that don't match", len(elements))
+			}
+		})
+	}
+}
+
+func TestFragmentAV1Malformed(t *testing.T) {
+	// the length field overruns the payload.
+	payload := append([]byte{0x00, 0x80 | 0x7f, 0x7f}, sequentialBytes(2000)...)
+	units := fragment(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeAV1}, payload, 1000, nil)
+	if len(units) != 1 || !bytes.Equal(units[0].data, payload) {
+		t.Errorf("a malformed payload was split into %d units", len(units))
+	}
+}
@@ -1,11 +1,24 @@
 package balancer
 
 import (
+	"context"
 	"fmt"
 	"net"
-	"os"
 	"strings"
-	"syscall"
+
+	"github.com/muxable/rtpmagic/pkg/muxer/balancer/bind"
+)
+
+// Binding selects how sockets are tied to an interface.
+type Binding int
+
+const (
+	// BindToDevice uses SO_BINDTODEVICE, which requires CAP_NET_RAW.
+	BindToDevice Binding = iota
+	// BindToSource binds to the interface's address and relies on source
+	// based policy routing installed by the route package, so the media
+	// process can run unprivileged.
+	BindToSource
 )
 
 func GetLocalAddresses() (map[string]*net.UDPAddr, error) {
@@ -39,47 +52,37 @@ func GetLocalAddresses() (map[string]*net.UDPAddr, error) {
 	return names, nil
 }
 
-func DialVia(to *net.UDPAddr, via string) (net.PacketConn, error) {
-	sfd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
-	if err != nil {
-		return nil, err
-	}
-	if err := syscall.BindToDevice(sfd, via); err != nil {
-		return nil, err
-	}
-	sa := &syscall.SockaddrInet4{Port: to.Port}
-	copy(sa.Addr[:], to.IP.To4())
-	if err := syscall.Connect(sfd, sa); err != nil {
-		return nil, err
-	}
-	if err := syscall.SetsockoptInt(sfd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, 65536); err != nil {
-		return nil, err
-	}
-	file := os.NewFile(uintptr(sfd), via)
-	conn, err := net.FilePacketConn(file)
+func DialVia(to *net.UDPAddr, via string, opts SocketOptions) (net.PacketConn, error) {
+	d := net.Dialer{Control: bind.Chain(bind.Control(via), opts.control(opts.tos(opts.VideoDSCP)))}
+	conn, err := d.Dial("udp4", to.String())
 	if err != nil {
-		file.Close()
 		return nil, err
 	}
-	return conn, nil
+	return conn.(*net.UDPConn), nil
 }
 
-func ListenVia(via string) (net.PacketConn, error) {
-	sfd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
+func ListenVia(via string, opts SocketOptions) (net.PacketConn, error) {
+	lc := net.ListenConfig{Control: bind.Chain(bind.Control(via), opts.control(opts.tos(opts.VideoDSCP)))}
+	conn, err := lc.ListenPacket(context.Background(), "udp4", ":0")
 	if err != nil {
-		return nil, err
-	}
-	if err := syscall.BindToDevice(sfd, via); err != nil {
-		return nil, err
-	}
-	if err := syscall.Bind(sfd, &syscall.SockaddrInet4{}); err != nil {
 		return nil, fmt.Errorf("failed to listen: %w", err)
 	}
-	file := os.NewFile(uintptr(sfd), via)
-	conn, err := net.FilePacketConn(file)
+	return conn, nil
+}
+
+// ListenFrom listens on the given local address. Packets only leave through
+// the address's interface if a matching source route is installed.
+func ListenFrom(laddr *net.UDPAddr, opts SocketOptions) (net.PacketConn, error) {
+	lc := net.ListenConfig{Control: opts.control(opts.tos(opts.VideoDSCP))}
+	return lc.ListenPacket(context.Background(), "udp4", (&net.UDPAddr{IP: laddr.IP}).String())
+}
+
+// DialFrom connects to the given address from the local address.
+func DialFrom(to, laddr *net.UDPAddr, opts SocketOptions) (net.PacketConn, error) {
+	d := net.Dialer{LocalAddr: &net.UDPAddr{IP: laddr.IP}, Control: opts.control(opts.tos(opts.VideoDSCP))}
+	conn, err := d.Dial("udp4", to.String())
 	if err != nil {
-		file.Close()
 		return nil, err
 	}
-	return conn, nil
+	return conn.(*net.UDPConn), nil
 }
@@ -0,0 +1,94 @@
+package balancer
+
+import (
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// isKeyframe reports whether pkt carries part of a keyframe or the parameter
+// sets needed to decode one.
+func isKeyframe(codec webrtc.RTPCodecCapability, pkt *rtp.Packet) bool {
+	payload := pkt.Payload
+	switch {
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeH265):
+		if len(payload) < 3 {
+			return false
+		}
+		naluType := (payload[0] >> 1) & 0x3f
+		switch naluType {
+		case 48: // aggregation packet, check the first unit.
+			if len(payload) < 5 {
+				return false
+			}
+			naluType = (payload[4] >> 1) & 0x3f
+		case 49: // fragmentation unit.
+			naluType = payload[2] & 0x3f
+		}
+		// IRAP pictures and VPS/SPS/PPS.
+		return (naluType >= 16 && naluType <= 21) || (naluType >= 32 && naluType <= 34)
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeH264):
+		if len(payload) < 2 {
+			return false
+		}
+		naluType := payload[0] & 0x1f
+		switch naluType {
+		case 24: // STAP-A, check the first unit.
+			if len(payload) < 4 {
+				return false
+			}
+			naluType = payload[3] & 0x1f
+		case 28: // FU-A
+			naluType = payload[1] & 0x1f
+		}
+		return naluType == 5 || naluType == 7 || naluType == 8
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP8):
+		return isVP8Keyframe(payload)
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP9):
+		// RFC 9628 section 4.2, not inter-picture predicted.
+		return len(payload) > 0 && payload[0]&0x40 == 0
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeAV1):
+		// the aggregation header's N bit starts a new coded video sequence.
+		return len(payload) > 0 && payload[0]&0x08 != 0
+	}
+	return false
+}
+
+// isVP8Keyframe parses the RFC 7741 payload descriptor. Only the first packet
+// of a partition carries the frame header, so continuation packets of a
+// keyframe aren't detected.
+func isVP8Keyframe(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+	// start of partition 0.
+	if payload[0]&0x10 == 0 || payload[0]&0x0f != 0 {
+		return false
+	}
+	i := 1
+	if payload[0]&0x80 != 0 {
+		if len(payload) < 2 {
+			return false
+		}
+		x := payload[1]
+		i++
+		if x&0x80 != 0 { // picture id, 15 bits if M is set.
+			if len(payload) <= i {
+				return false
+			}
+			if payload[i]&0x80 != 0 {
+				i++
+			}
+			i++
+		}
+		if x&0x40 != 0 { // TL0PICIDX
+			i++
+		}
+		if x&0x30 != 0 { // TID and KEYIDX
+			i++
+		}
+	}
+	// the P bit of the frame header is clear on keyframes.
+	return len(payload) > i && payload[i]&0x01 == 0
+}
@@ -0,0 +1,8 @@
+package balancer
+
+// LinkQualityProvider reports the radio quality of an interface.
+type LinkQualityProvider interface {
+	// LinkQuality returns a score in [0, 1] for the device and whether it is
+	// degrading. ok is false if the quality is unknown.
+	LinkQuality(device string) (score float64, degrading bool, ok bool)
+}
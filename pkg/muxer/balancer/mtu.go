@@ -0,0 +1,198 @@
+package balancer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// MTUProbeInterval is how often the path MTU of each connection is probed.
+var MTUProbeInterval = 1 * time.Minute
+
+const (
+	// defaultMTU is assumed until a path has been probed.
+	defaultMTU = 1500
+	// minimumMTU is the IPv4 minimum reassembly size, paths are never assumed
+	// to be smaller than this.
+	minimumMTU = 576
+	// rtpHeaderSize is the fixed RTP header plus room for the header
+	// extensions added by the interceptors.
+	rtpHeaderSize = 12 + 28
+)
+
+// GetMTU returns the most recently probed path MTU of the connection.
+func (pc *ManagedPeerConnection) GetMTU() int {
+	if mtu := atomic.LoadInt32(&pc.mtu); mtu > 0 {
+		return int(mtu)
+	}
+	return defaultMTU
+}
+
+// GetMTU returns the smallest MTU of the paths carrying media as of the last
+// poll.
+func (mpcg *ManagedPeerConnectionGroup) GetMTU() int {
+	if mtu := atomic.LoadInt32(&mpcg.mtu); mtu > 0 {
+		return int(mtu)
+	}
+	return defaultMTU
+}
+
+// updateMTU recomputes the group MTU. The caller must hold the group lock.
+func (mpcg *ManagedPeerConnectionGroup) updateMTU() {
+	mtu := 0
+	for _, pc := range mpcg.conns {
+		if pc.IsStandby() {
+			continue
+		}
+		if m := pc.GetMTU(); mtu == 0 || m < mtu {
+			mtu = m
+		}
+	}
+	atomic.StoreInt32(&mpcg.mtu, int32(mtu))
+}
+
+// maxPayloadSize returns the largest RTP payload that fits in an mtu sized
+// datagram.
+func maxPayloadSize(mtu int) int {
+	return mtu - packetOverhead - rtpHeaderSize
+}
+
+// remoteAddr returns the remote address of the selected ICE candidate pair.
+func (pc *ManagedPeerConnection) remoteAddr() *net.UDPAddr {
+	for _, sender := range pc.GetSenders() {
+		transport := sender.Transport()
+		if transport == nil {
+			continue
+		}
+		pair, err := transport.ICETransport().GetSelectedCandidatePair()
+		if err != nil || pair == nil || pair.Remote == nil {
+			continue
+		}
+		return &net.UDPAddr{IP: net.ParseIP(pair.Remote.Address), Port: int(pair.Remote.Port)}
+	}
+	return nil
+}
+
+// probeMTU periodically probes the path MTU until done is closed.
+func (pc *ManagedPeerConnection) probeMTU(done <-chan struct{}) {
+	ticker := time.NewTicker(MTUProbeInterval)
+	defer ticker.Stop()
+	for {
+		if pc.isConnected() {
+			if to := pc.remoteAddr(); to != nil {
+				mtu, err := pc.probe(to)
+				if err != nil {
+					log.Warn().Err(err).Str("Interface", pc.device).Msg("failed to probe mtu")
+				} else if prev := atomic.SwapInt32(&pc.mtu, int32(mtu)); int(prev) != mtu {
+					log.Info().Str("Interface", pc.device).Int("MTU", mtu).Msg("path mtu changed")
+				}
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// probe dials the remote address the same way the media socket is bound and
+// probes the path MTU.
+func (pc *ManagedPeerConnection) probe(to *net.UDPAddr) (int, error) {
+	var pconn net.PacketConn
+	var err error
+	if pc.binding == BindToSource {
+		pconn, err = DialFrom(to, pc.laddr, pc.socketOptions)
+	} else {
+		pconn, err = DialVia(to, pc.device, pc.socketOptions)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer pconn.Close()
+	return ProbeMTU(pc.device, pconn)
+}
+
+// probePacket returns a padding-only RTP packet of the given size, which must
+// be at least 20 bytes. RTP padding is limited to 255 bytes so the rest is a
+// one-byte header extension block of padding bytes, leaving receivers
+// nothing to play out.
+func probePacket(size int) []byte {
+	buf := make([]byte, size)
+	buf[0] = 0x80 | 0x20 | 0x10 // version 2 with padding and an extension.
+	buf[1] = probePayloadType
+	// keep the extension block a whole number of words.
+	padding := 4 + (size-16)%4
+	binary.BigEndian.PutUint16(buf[12:], 0xbede)
+	binary.BigEndian.PutUint16(buf[14:], uint16((size-16-padding)/4))
+	buf[size-1] = byte(padding)
+	return buf
+}
+
+// probePayloadType is an unassigned dynamic payload type, so receivers drop
+// probes even if they parse them.
+const probePayloadType = 127
+
+// ProbeMTU discovers the path MTU of a connected socket on the device. It
+// sends don't-fragment padding-only RTP packets sized to the interface MTU so
+// that any ICMP fragmentation needed responses lower the kernel's cached path
+// MTU, then reads the result back.
+func ProbeMTU(device string, pconn net.PacketConn) (int, error) {
+	iface, err := net.InterfaceByName(device)
+	if err != nil {
+		return 0, err
+	}
+	conn, ok := pconn.(*net.UDPConn)
+	if !ok {
+		return 0, fmt.Errorf("unexpected connection type %T", pconn)
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+	}); err != nil {
+		return 0, err
+	}
+	if sockErr != nil {
+		return 0, sockErr
+	}
+	getMTU := func() (int, error) {
+		var mtu int
+		if err := raw.Control(func(fd uintptr) {
+			mtu, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU)
+		}); err != nil {
+			return 0, err
+		}
+		return mtu, sockErr
+	}
+
+	mtu := iface.MTU
+	for i := 0; i < 3; i++ {
+		if _, err := conn.Write(probePacket(mtu - 20 - 8)); err != nil && !errors.Is(err, syscall.EMSGSIZE) {
+			return 0, err
+		}
+		time.Sleep(100 * time.Millisecond)
+		probed, err := getMTU()
+		if err != nil {
+			return 0, err
+		}
+		if probed >= mtu {
+			break
+		}
+		mtu = probed
+	}
+	if mtu < minimumMTU {
+		mtu = minimumMTU
+	}
+	return mtu, nil
+}
@@ -0,0 +1,23 @@
+package balancer
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+func TestProbePacket(t *testing.T) {
+	for _, size := range []int{548, 1472, 1473, 1474, 1475, 8972} {
+		buf := probePacket(size)
+		if len(buf) != size {
+			t.Fatalf("probe is %d bytes, want %d", len(buf), size)
+		}
+		pkt := &rtp.Packet{}
+		if err := pkt.Unmarshal(buf); err != nil {
+			t.Fatalf("%d byte probe: %v", size, err)
+		}
+		if !pkt.Padding || len(pkt.Payload) != 0 || len(pkt.Extensions) != 0 {
+			t.Errorf("%d byte probe has padding %v, %d payload bytes and %d extensions", size, pkt.Padding, len(pkt.Payload), len(pkt.Extensions))
+		}
+	}
+}
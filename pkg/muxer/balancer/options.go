@@ -0,0 +1,149 @@
+package balancer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/muxable/rtpmagic/pkg/muxer/quota"
+	"github.com/pion/webrtc/v3"
+)
+
+// Option configures a ManagedPeerConnectionGroup.
+type Option func(*ManagedPeerConnectionGroup) error
+
+// WithFEC enables FlexFEC protection of video sources.
+func WithFEC() Option {
+	return func(mpcg *ManagedPeerConnectionGroup) error {
+		mpcg.fec = true
+		return nil
+	}
+}
+
+// WithRED wraps Opus sources in RED with depth redundant copies of previous
+// packets.
+func WithRED(depth int) Option {
+	return func(mpcg *ManagedPeerConnectionGroup) error {
+		if depth < 0 {
+			return fmt.Errorf("invalid red depth %d", depth)
+		}
+		mpcg.redDepth = depth
+		return nil
+	}
+}
+
+// WithCCFB negotiates RFC 8888 congestion control feedback and feeds it to
+// the per-path estimator, for receivers that don't implement transport wide
+// congestion control.
+func WithCCFB() Option {
+	return func(mpcg *ManagedPeerConnectionGroup) error {
+		mpcg.ccfb = true
+		return nil
+	}
+}
+
+// WithRTX sends retransmissions of video on a separate RTX stream instead of
+// resending the original packets.
+func WithRTX() Option {
+	return func(mpcg *ManagedPeerConnectionGroup) error {
+		mpcg.rtx = true
+		return nil
+	}
+}
+
+// WithRetransmissionBudget limits retransmissions to fraction of the
+// estimated bitrate.
+func WithRetransmissionBudget(fraction float64) Option {
+	return func(mpcg *ManagedPeerConnectionGroup) error {
+		if fraction <= 0 || fraction > 1 {
+			return fmt.Errorf("invalid retransmission budget %f", fraction)
+		}
+		mpcg.retransmissionBudget = fraction
+		return nil
+	}
+}
+
+// WithLatencyTarget drops media that can no longer be played out within
+// latency of its capture, including declining retransmissions of it. Media is
+// never dropped without it or with a zero latency.
+func WithLatencyTarget(latency time.Duration) Option {
+	return func(mpcg *ManagedPeerConnectionGroup) error {
+		mpcg.latency = latency
+		return nil
+	}
+}
+
+// WithStandby keeps the given interfaces connected without carrying media.
+// They are promoted when an active path fails or the active paths' combined
+// estimate falls below threshold bits per second.
+func WithStandby(threshold int, devices ...string) Option {
+	return func(mpcg *ManagedPeerConnectionGroup) error {
+		for _, device := range devices {
+			mpcg.standbyDevices[device] = true
+		}
+		mpcg.standbyThreshold = threshold
+		return nil
+	}
+}
+
+// WithQuotas accounts the bytes sent and received on each interface and
+// deprioritises or disables interfaces that have reached their quota.
+func WithQuotas(accountant *quota.Accountant) Option {
+	return func(mpcg *ManagedPeerConnectionGroup) error {
+		mpcg.quotas = accountant
+		return nil
+	}
+}
+
+// WithLinkQuality scales back the share of traffic of paths whose radio quality
+// is degrading, so that they shed traffic before congestion control notices
+// loss.
+func WithLinkQuality(provider LinkQualityProvider) Option {
+	return func(mpcg *ManagedPeerConnectionGroup) error {
+		mpcg.linkQuality = provider
+		return nil
+	}
+}
+
+// WithSocketOptions sets per-interface socket options, the "*" entry applies
+// to interfaces without their own.
+func WithSocketOptions(options map[string]SocketOptions) Option {
+	return func(mpcg *ManagedPeerConnectionGroup) error {
+		mpcg.socketOptions = options
+		return nil
+	}
+}
+
+// WithBinding selects how sockets are bound to interfaces, the default is
+// BindToDevice.
+func WithBinding(binding Binding) Option {
+	return func(mpcg *ManagedPeerConnectionGroup) error {
+		mpcg.binding = binding
+		return nil
+	}
+}
+
+// WithCongestionControllers sets per-interface congestion controllers, the "*"
+// entry applies to interfaces without their own. The default is GCC.
+func WithCongestionControllers(controllers map[string]CongestionController) Option {
+	return func(mpcg *ManagedPeerConnectionGroup) error {
+		mpcg.congestionControllers = controllers
+		return nil
+	}
+}
+
+// WithCodecs sets the codecs negotiated on every path in order of preference,
+// these should be the codecs the encoders can produce. The default is all of
+// Codecs.
+func WithCodecs(codecs ...webrtc.RTPCodecCapability) Option {
+	return func(mpcg *ManagedPeerConnectionGroup) error {
+		mpcg.codecs = nil
+		for _, codec := range codecs {
+			c, ok := LookupCodec(codec.MimeType)
+			if !ok {
+				return fmt.Errorf("unsupported codec %s", codec.MimeType)
+			}
+			mpcg.codecs = append(mpcg.codecs, c)
+		}
+		return nil
+	}
+}
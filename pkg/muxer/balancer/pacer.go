@@ -0,0 +1,177 @@
+package balancer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxPacerQueue bounds the packets queued on a path, the oldest are dropped
+// once a stalled path has built up this many.
+const maxPacerQueue = 2048
+
+type pacedPacket struct {
+	track    *ManagedTrack
+	buf      *packetBuffer
+	deadline time.Time
+	enqueued time.Time
+}
+
+// pacer smooths bursts, such as keyframes, on a single path by releasing
+// packets at a multiple of the path's estimated bitrate with a small burst
+// allowance. Packets are also held while a window based congestion controller
+// reports its window is full.
+type pacer struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	queue   []pacedPacket
+	bitrate func() int
+	canSend func(size int) bool
+	factor  float64
+	burst   time.Duration
+
+	tokens float64
+	last   time.Time
+
+	// queueDelay is the time the most recently sent packet spent queued, in
+	// nanoseconds.
+	queueDelay int64
+	// dropped counts the packets dropped because the queue was full.
+	dropped uint64
+
+	closed bool
+}
+
+func newPacer(bitrate func() int, canSend func(size int) bool) *pacer {
+	p := &pacer{
+		bitrate: bitrate,
+		canSend: canSend,
+		factor:  1.5,
+		burst:   20 * time.Millisecond,
+		last:    time.Now(),
+	}
+	p.cond = sync.NewCond(&p.Mutex)
+	go p.run()
+	return p
+}
+
+// Push queues a packet to be written to the track, taking over the reference
+// to buf.
+func (p *pacer) Push(track *ManagedTrack, buf *packetBuffer, deadline time.Time) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.closed {
+		buf.Release()
+		return
+	}
+	if len(p.queue) >= maxPacerQueue {
+		p.queue[0].buf.Release()
+		p.queue[0] = pacedPacket{}
+		p.queue = p.queue[1:]
+		atomic.AddUint64(&p.dropped, 1)
+	}
+	p.queue = append(p.queue, pacedPacket{track: track, buf: buf, deadline: deadline, enqueued: time.Now()})
+	p.cond.Signal()
+}
+
+// QueueDelay returns how long packets are currently waiting in the pacer.
+func (p *pacer) QueueDelay() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+
+	if len(p.queue) > 0 {
+		return time.Since(p.queue[0].enqueued)
+	}
+	return time.Duration(atomic.LoadInt64(&p.queueDelay))
+}
+
+// Dropped returns the number of packets dropped because the queue was full.
+func (p *pacer) Dropped() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+func (p *pacer) Close() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.closed = true
+	for _, next := range p.queue {
+		next.buf.Release()
+	}
+	p.queue = nil
+	p.cond.Broadcast()
+}
+
+func (p *pacer) run() {
+	for {
+		p.Lock()
+		for len(p.queue) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if p.closed {
+			p.Unlock()
+			return
+		}
+		next := p.queue[0]
+
+		now := time.Now()
+		if !next.deadline.IsZero() && now.After(next.deadline) {
+			// expired packets don't use any of the budget.
+			p.queue[0] = pacedPacket{}
+			p.queue = p.queue[1:]
+			p.Unlock()
+			atomic.AddUint64(&next.track.source.mpcg.expired, 1)
+			next.buf.Release()
+			continue
+		}
+
+		rate := p.factor * float64(p.bitrate())
+		size := float64(next.buf.Len() * 8)
+		p.tokens += rate * now.Sub(p.last).Seconds()
+		// at low rates the burst allowance is smaller than a packet, which
+		// would then never be released.
+		capacity := rate * p.burst.Seconds()
+		if capacity < size {
+			capacity = size
+		}
+		if p.tokens > capacity {
+			p.tokens = capacity
+		}
+		p.last = now
+
+		if p.tokens < size && rate > 0 {
+			wait := time.Duration((size - p.tokens) / rate * float64(time.Second))
+			p.Unlock()
+			if wait < time.Millisecond {
+				wait = time.Millisecond
+			}
+			time.Sleep(wait)
+			continue
+		}
+		if !p.canSend(next.buf.Len()) {
+			// wait for feedback to open the window.
+			p.Unlock()
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		// without an estimate packets aren't held back, but the debt is
+		// capped so they aren't stalled once there is one.
+		p.tokens -= size
+		if floor := -rate * p.burst.Seconds(); p.tokens < floor {
+			p.tokens = floor
+		}
+		p.queue[0] = pacedPacket{}
+		p.queue = p.queue[1:]
+		p.Unlock()
+
+		atomic.StoreInt64(&p.queueDelay, int64(now.Sub(next.enqueued)))
+		if err := next.track.write(next.buf); err != nil {
+			log.Warn().Err(err).Msg("failed to write paced packet")
+		}
+		next.buf.Release()
+	}
+}
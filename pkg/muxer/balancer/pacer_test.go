@@ -0,0 +1,37 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestPacerLowRate(t *testing.T) {
+	// at 100kbps the 20ms burst allowance is 3000 bits, less than a packet.
+	const bitrate, count = 100_000, 5
+
+	mpcg := newBenchmarkGroup(t)
+	addBenchmarkSource(t, mpcg, webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000})
+	track := mpcg.tracks[0]
+
+	p := newPacer(func() int { return bitrate }, func(int) bool { return true })
+	defer p.Close()
+
+	start := time.Now()
+	for i := 0; i < count; i++ {
+		b, err := newPacketBuffer(&rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: uint16(i)}, Payload: make([]byte, 1188)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Push(track, b, time.Time{})
+	}
+	transferred(t, map[*ManagedPeerConnection]uint64{track.pc: count * 1200 * 8})
+
+	// the packets are still paced at the rate rather than sent at once.
+	want := time.Duration(float64((count-1)*1200*8) / (p.factor * bitrate) * float64(time.Second))
+	if elapsed := time.Since(start); elapsed < want {
+		t.Errorf("sent %d packets in %v, want at least %v", count, elapsed, want)
+	}
+}
@@ -2,17 +2,29 @@ package balancer
 
 import (
 	"context"
+	"errors"
 	"io"
 	"math/rand"
+	"net"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/muxable/rtpmagic/api"
+	"github.com/muxable/rtpmagic/pkg/muxer/abscapture"
+	"github.com/muxable/rtpmagic/pkg/muxer/balancer/bind"
+	"github.com/muxable/rtpmagic/pkg/muxer/ccfb"
+	"github.com/muxable/rtpmagic/pkg/muxer/ecn"
+	"github.com/muxable/rtpmagic/pkg/muxer/fec"
+	"github.com/muxable/rtpmagic/pkg/muxer/multipath"
+	"github.com/muxable/rtpmagic/pkg/muxer/nack"
+	"github.com/muxable/rtpmagic/pkg/muxer/quota"
+	"github.com/muxable/rtpmagic/pkg/muxer/red"
 	"github.com/muxable/signal/pkg/signal"
 	"github.com/pion/interceptor"
 	"github.com/pion/interceptor/pkg/cc"
-	"github.com/pion/interceptor/pkg/gcc"
 	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
@@ -24,36 +36,135 @@ import (
 type ManagedPeerConnection struct {
 	*webrtc.PeerConnection
 
+	device        string
+	laddr         *net.UDPAddr
+	binding       Binding
+	socketOptions SocketOptions
+
+	// pathID identifies this connection in the multipath header extension.
+	pathID uint8
+
+	// standby is set while the connection is kept up without media.
+	standby int32
+	done    chan struct{}
+
+	signal      *grpc.ClientConn
+	signalState int32
+
 	connectionState     webrtc.PeerConnectionState
 	connectionStateCond *sync.Cond
+	// wasConnected is set once the connection has connected, guarded by the
+	// connection state lock.
+	wasConnected bool
+	created      time.Time
+
+	bitsTransferred    uint64
+	rtxBitsTransferred uint64
+	lastUpdate         time.Time
 
-	bitsTransferred uint64
-	lastUpdate      time.Time
+	// rtt is the smoothed round trip time in nanoseconds.
+	rtt int64
+
+	pacer *pacer
+
+	// mtu is the probed path mtu.
+	mtu int32
 
 	ccs map[string]cc.BandwidthEstimator
+	// ecn scales the estimate by the CE marks reported by the receiver, nil
+	// if ECN isn't enabled for the path. ecnCapable is set while the remote
+	// negotiates it and the media is marked ECT(1).
+	ecn        *ecn.Controller
+	ecnCapable int32
+
+	// conn is the path's media socket and marker sets the audio codepoint.
+	conn   net.PacketConn
+	marker *marker
+
+	// fec generates the repair packets of the path's media, which are sent on
+	// the other paths. It's nil if fec is disabled.
+	fec *fec.InterceptorFactory
+
+	ssrcGroups     []ssrcGroup
+	ssrcGroupsLock sync.Mutex
 }
 
 type ManagedTrack struct {
-	tl        *webrtc.TrackLocalStaticRTP
+	tl        *localTrack
 	pc        *ManagedPeerConnection
 	source    *ManagedSource
 	rtpSender *webrtc.RTPSender
+	ssrc      uint32
+
+	// fec generates the track's repair packets, nil if it isn't protected.
+	fec *fec.Encoder
+
+	// rtxSSRC is the ssrc of the track's retransmission stream, zero if
+	// retransmissions are sent on the track itself.
+	rtxSSRC     uint32
+	rtxSequence uint32
 }
 
 type ManagedPeerConnectionGroup struct {
 	sync.RWMutex
 
-	addr string
+	// destinations are the configured sfu addresses or srv names, addrs is
+	// their resolved form and addr the one currently in use.
+	destinations []string
+	addrs        []string
+	addrIndex    int
+	addr         string
 
 	conns   map[string]*ManagedPeerConnection
 	sources map[*ManagedSource]bool
 
 	tracks []*ManagedTrack
 
+	standbyDevices   map[string]bool
+	standbyThreshold int
+
+	quotas    *quota.Accountant
+	lastSaved time.Time
+
+	linkQuality LinkQualityProvider
+
+	socketOptions map[string]SocketOptions
+	binding       Binding
+
+	congestionControllers map[string]CongestionController
+
+	// mtu is the smallest mtu of the active paths, packets are repacketized to
+	// fit it.
+	mtu int32
+
+	// codecs are registered on every path in order of preference.
+	codecs []Codec
+
+	// remoteCodecs are the codecs the remote answered with on the first
+	// negotiated path, negotiated is closed once they're known.
+	remoteCodecs   map[webrtc.RTPCodecType][]webrtc.RTPCodecParameters
+	negotiated     chan struct{}
+	negotiatedOnce sync.Once
+
+	fec      bool
+	redDepth int
+	rtx      bool
+	ccfb     bool
+
+	retransmissionBudget float64
+	budget               *nack.Budget
+
+	// latency is the playout latency target, packets older than this relative
+	// to their capture time are dropped.
+	latency time.Duration
+	expired uint64
+
 	cancel context.CancelFunc
 }
 
 type ManagedSource struct {
+	sync.Mutex
+
 	codec        webrtc.RTPCodecCapability
 	id, streamID string
 
@@ -61,20 +172,59 @@ type ManagedSource struct {
 
 	readRTCPCh chan []rtcp.Packet
 
-	sendBuffer [1 << 16]*rtp.Packet
-
-	t0 time.Time
+	// sendBuffer holds a reference to each sent packet for retransmission,
+	// guarded by bufferLock.
+	sendBuffer [1 << 16]*packetBuffer
+	bufferLock sync.Mutex
+	// resent holds the time in nanoseconds each buffered packet was last
+	// retransmitted, used to ignore repeated nacks within a round trip.
+	resent [1 << 16]int64
+
+	red       *red.Encoder
+	redPacket rtp.Packet
+	// units is scratch space for fragmentation.
+	units []fragmentUnit
+
+	// rtxPayloadType is the payload type of the source's retransmissions,
+	// zero if they're sent as is.
+	rtxPayloadType uint8
+
+	// seqOffset is added to incoming sequence numbers to make room for the
+	// packets created by repacketization.
+	seqOffset uint16
+
+	// clock derives capture times from the packet timestamps.
+	clock *mediaClock
 }
 
-func NewManagedPeerConnection(addr string, pollingInterval time.Duration) (*ManagedPeerConnectionGroup, error) {
+// NewManagedPeerConnection publishes to the first of the given destinations,
+// failing over to the next one when signalling fails on all paths.
+func NewManagedPeerConnection(destinations []string, pollingInterval time.Duration, opts ...Option) (*ManagedPeerConnectionGroup, error) {
+	addrs, err := resolveDestinations(destinations)
+	if err != nil {
+		return nil, err
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	n := &ManagedPeerConnectionGroup{
-		addr:    addr,
-		conns:   make(map[string]*ManagedPeerConnection),
-		sources: make(map[*ManagedSource]bool),
-		cancel:  cancel,
+		destinations:         destinations,
+		addrs:                addrs,
+		addr:                 addrs[0],
+		conns:                make(map[string]*ManagedPeerConnection),
+		sources:              make(map[*ManagedSource]bool),
+		standbyDevices:       make(map[string]bool),
+		codecs:               Codecs,
+		negotiated:           make(chan struct{}),
+		retransmissionBudget: 0.25,
+		cancel:               cancel,
 	}
-	if err := n.bindLocalAddresses(addr); err != nil {
+	for _, opt := range opts {
+		if err := opt(n); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	n.budget = nack.NewBudget(n.retransmissionBudget, 250*time.Millisecond, n.GetEstimatedBitrate)
+	if err := n.bindLocalAddresses(); err != nil {
 		return nil, err
 	}
 	go func() {
@@ -83,7 +233,7 @@ func NewManagedPeerConnection(addr string, pollingInterval time.Duration) (*Mana
 		for {
 			select {
 			case <-ticker.C:
-				if err := n.bindLocalAddresses(addr); err != nil {
+				if err := n.bindLocalAddresses(); err != nil {
 					log.Warn().Msgf("failed to get local addresses: %v", err)
 				}
 			case <-ctx.Done():
@@ -95,7 +245,7 @@ func NewManagedPeerConnection(addr string, pollingInterval time.Duration) (*Mana
 }
 
 // bindLocalAddresses binds the local addresses to the UDPConn.
-func (n *ManagedPeerConnectionGroup) bindLocalAddresses(addr string) error {
+func (n *ManagedPeerConnectionGroup) bindLocalAddresses() error {
 	// get the network interfaces.
 	devices, err := GetLocalAddresses()
 	if err != nil {
@@ -103,10 +253,20 @@ func (n *ManagedPeerConnectionGroup) bindLocalAddresses(addr string) error {
 	}
 	n.Lock()
 	log.Printf("devices: %v", devices)
+	n.failover()
+	addr := n.addr
 	// add any interfaces that are not already active.
-	for device := range devices {
+	for device, laddr := range devices {
+		if conn, ok := n.conns[device]; ok && n.binding == BindToSource && !conn.laddr.IP.Equal(laddr.IP) {
+			// the socket is bound to the old address, reconnect.
+			if err := n.removeDevice(device); err != nil {
+				log.Error().Msgf("failed to remove device %s: %v", device, err)
+				continue
+			}
+			log.Info().Str("Interface", device).Stringer("Address", laddr).Msg("interface address changed")
+		}
 		if _, ok := n.conns[device]; !ok {
-			if err := n.addDevice(device); err != nil {
+			if err := n.addDevice(device, laddr); err != nil {
 				log.Error().Msgf("failed to add device %s: %v", device, err)
 				continue
 			}
@@ -125,10 +285,19 @@ func (n *ManagedPeerConnectionGroup) bindLocalAddresses(addr string) error {
 			log.Info().Msgf("disconnected from %s via %s", addr, device)
 		}
 	}
+	n.updateStandby()
+	n.updateMTU()
 	n.Unlock()
+	if n.quotas != nil && time.Since(n.lastSaved) > QuotaSaveInterval {
+		if err := n.quotas.Save(); err != nil {
+			log.Warn().Err(err).Msg("failed to save data usage")
+		}
+		n.lastSaved = time.Now()
+	}
 	// print some debugging information
 	bitrate := n.GetEstimatedBitrate()
-	log.Debug().Int("Connections", len(n.conns)).Int("TotalBitrate", bitrate).Msg("active connections")
+	n.RLock()
+	log.Debug().Int("Connections", len(n.conns)).Int("TotalBitrate", bitrate).Int("MTU", n.GetMTU()).Msg("active connections")
 	keys := make([]string, 0, len(n.conns))
 	for key := range n.conns {
 		keys = append(keys, key)
@@ -137,49 +306,89 @@ func (n *ManagedPeerConnectionGroup) bindLocalAddresses(addr string) error {
 	for _, key := range keys {
 		conn := n.conns[key]
 		bitrate := conn.GetEstimatedBitrate()
-		actual := conn.GetTransferredBitrate()
-		log.Debug().Str("Interface", key).Int("TargetBitrate", bitrate).Int("ActualBitrate", actual).Msg("active connection")
+		actual, retransmitted := conn.GetTransferredBitrate()
+		log.Debug().Str("Interface", key).Bool("Standby", conn.IsStandby()).Uint64("Usage", n.usage(key)).Int("TargetBitrate", bitrate).Int("ActualBitrate", actual).Int("RetransmittedBitrate", retransmitted).Dur("RTT", conn.RTT()).Dur("QueueDelay", conn.GetQueueDelay()).Uint64("PacerDropped", conn.pacer.Dropped()).Int("MTU", conn.GetMTU()).Msg("active connection")
 	}
+	n.RUnlock()
+	sent, dropped, duplicates := n.budget.Counters()
+	log.Debug().Uint64("Sent", sent).Uint64("Dropped", dropped).Uint64("Duplicates", duplicates).Msg("retransmissions")
+	log.Debug().Uint64("Expired", atomic.LoadUint64(&n.expired)).Msg("deadline")
 	return nil
 }
 
-func (mpcg *ManagedPeerConnectionGroup) addDevice(device string) error {
-	conn, err := ListenVia(device)
+func (mpcg *ManagedPeerConnectionGroup) addDevice(device string, laddr *net.UDPAddr) error {
+	socketOptions := mpcg.getSocketOptions(device)
+	var conn net.PacketConn
+	var err error
+	if mpcg.binding == BindToSource {
+		conn, err = ListenFrom(laddr, socketOptions)
+	} else {
+		conn, err = ListenVia(device, socketOptions)
+	}
 	if err != nil {
 		return err
 	}
+	// ECT(1) is only set once the remote negotiates ECN.
+	marker := newMarker(socketOptions, mpcg.audioPayloadTypes())
+	if socketOptions.ECN {
+		notECT := socketOptions
+		notECT.ECN = false
+		marker.setTOS(notECT.tos(notECT.AudioDSCP))
+		if err := setTOS(conn, notECT.tos(notECT.VideoDSCP)); err != nil {
+			return err
+		}
+	}
 
 	settingEngine := webrtc.SettingEngine{}
-
-	settingEngine.SetICEUDPMux(webrtc.NewICEUDPMux(nil, conn))
+	settingEngine.SetICEUDPMux(webrtc.NewICEUDPMux(nil, newBatchConn(conn, marker, mpcg.countUsage(device, udpOverhead))))
 
 	m := &webrtc.MediaEngine{}
-	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
-		RTPCodecCapability: webrtc.RTPCodecCapability{
-			MimeType:     webrtc.MimeTypeH265,
-			ClockRate:    90000,
-			RTCPFeedback: []webrtc.RTCPFeedback{{"goog-remb", ""}, {"ccm", "fir"}, {"nack", ""}, {"nack", "pli"}},
-		},
-		PayloadType: 96,
-	}, webrtc.RTPCodecTypeVideo); err != nil {
-		panic(err)
-	}
-
-	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
-		RTPCodecCapability: webrtc.RTPCodecCapability{
-			MimeType:  webrtc.MimeTypeOpus,
-			ClockRate: 48000,
-			Channels:  2,
-		},
-		PayloadType: 97,
-	}, webrtc.RTPCodecTypeAudio); err != nil {
-		panic(err)
+	if err := mpcg.registerCodecs(m); err != nil {
+		return err
+	}
+
+	if mpcg.fec {
+		if err := m.RegisterCodec(FlexFECCodec, webrtc.RTPCodecTypeVideo); err != nil {
+			panic(err)
+		}
+	}
+
+	if socketOptions.ECN {
+		// RFC 6679 section 6.1.
+		m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack", Parameter: "ecn"}, webrtc.RTPCodecTypeVideo)
+		m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack", Parameter: "ecn"}, webrtc.RTPCodecTypeAudio)
 	}
 
 	i := &interceptor.Registry{}
 
+	var mpc *ManagedPeerConnection
+	var fecInterceptor *fec.InterceptorFactory
+	if mpcg.fec {
+		// this must be first so the repair packets cover the packets as sent.
+		fecInterceptor = fec.NewInterceptorFactory(func(ssrc uint32, repair *rtp.Packet) error {
+			return mpcg.sendRepair(mpc, ssrc, repair)
+		})
+		i.Add(fecInterceptor)
+	}
+
+	if mpcg.ccfb {
+		// this must be before the congestion controller.
+		if err := ccfb.ConfigureFeedback(m, i, func(twcc []rtcp.Packet, ce, ect int) {
+			for _, estimator := range mpc.ccs {
+				if err := estimator.WriteRTCP(twcc, nil); err != nil {
+					log.Warn().Err(err).Msg("failed to apply congestion control feedback")
+				}
+			}
+			if mpc.ecn != nil {
+				mpc.ecn.Observe(ce, ect)
+			}
+		}); err != nil {
+			return err
+		}
+	}
+
 	congestionController, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
-		return gcc.NewSendSideBWE(gcc.SendSideBWEInitialBitrate(5_000_000))
+		return mpcg.getCongestionController(device).newEstimator(5_000_000)
 	})
 	if err != nil {
 		return err
@@ -191,7 +400,7 @@ func (mpcg *ManagedPeerConnectionGroup) addDevice(device string) error {
 	})
 
 	i.Add(congestionController)
-	if err := webrtc.ConfigureRTCPReports(i); err != nil {
+	if err := configureRTCPReports(i); err != nil {
 		return err
 	}
 
@@ -216,12 +425,39 @@ func (mpcg *ManagedPeerConnectionGroup) addDevice(device string) error {
 		return err
 	}
 
-	mpc := &ManagedPeerConnection{
+	pathID, err := mpcg.allocatePathID()
+	if err != nil {
+		return err
+	}
+	if err := multipath.ConfigureHeaderExtensionSender(m, i, pathID, mpcg.rtxPayloadTypes()...); err != nil {
+		return err
+	}
+
+	if err := abscapture.ConfigureHeaderExtension(m, i); err != nil {
+		return err
+	}
+
+	mpc = &ManagedPeerConnection{
+		device:              device,
+		laddr:               laddr,
+		binding:             mpcg.binding,
+		socketOptions:       socketOptions,
+		pathID:              pathID,
 		ccs:                 make(map[string]cc.BandwidthEstimator),
 		connectionState:     webrtc.PeerConnectionStateDisconnected,
 		connectionStateCond: sync.NewCond(&sync.Mutex{}),
 		lastUpdate:          time.Now(),
+		created:             time.Now(),
+		done:                make(chan struct{}),
+		fec:                 fecInterceptor,
+		conn:                conn,
+		marker:              marker,
 	}
+	if socketOptions.ECN {
+		mpc.ecn = ecn.NewController()
+	}
+	mpc.pacer = newPacer(mpc.GetEstimatedBitrate, mpc.canSend)
+	mpc.setStandby(mpcg.standbyDevices[device])
 
 	// it's ok if an error is returned since we only dangle a pointer.
 	mpcg.conns[device] = mpc
@@ -235,14 +471,33 @@ func (mpcg *ManagedPeerConnectionGroup) addDevice(device string) error {
 
 	mpc.PeerConnection = pc
 
+	go mpc.keepalive(mpc.done)
+	go mpc.probeMTU(mpc.done)
+
 	// create a new signalling channel.
-	grpcconn, err := grpc.Dial(mpcg.addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	dialer := &net.Dialer{Control: socketOptions.control(0)}
+	if mpcg.binding == BindToSource {
+		dialer.LocalAddr = &net.TCPAddr{IP: laddr.IP}
+	} else {
+		dialer.Control = bind.Chain(bind.Control(device), dialer.Control)
+	}
+	count := mpcg.countUsage(device, tcpOverhead)
+	grpcconn, err := grpc.Dial(mpcg.addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil || count == nil {
+			return conn, err
+		}
+		return &countingConn{Conn: conn, count: count}, nil
+	}))
 	if err != nil {
+		mpc.setSignalFailed()
 		return err
 	}
+	mpc.signal = grpcconn
 
 	client, err := api.NewSFUClient(grpcconn).Publish(context.Background())
 	if err != nil {
+		mpc.setSignalFailed()
 		return err
 	}
 
@@ -250,9 +505,26 @@ func (mpcg *ManagedPeerConnectionGroup) addDevice(device string) error {
 
 	pc.OnNegotiationNeeded(signaller.Renegotiate)
 
+	// offer to receive so that the first answer tells us which codecs the
+	// remote supports before any track is added, tracks reuse these
+	// transceivers.
+	pc.OnSignalingStateChange(func(state webrtc.SignalingState) {
+		if state == webrtc.SignalingStateStable {
+			mpcg.updateRemoteCodecs(pc)
+		}
+	})
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		if _, err := pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+			return err
+		}
+	}
+
 	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		mpc.connectionStateCond.L.Lock()
 		mpc.connectionState = state
+		if state == webrtc.PeerConnectionStateConnected {
+			mpc.wasConnected = true
+		}
 		mpc.connectionStateCond.Broadcast()
 		mpc.connectionStateCond.L.Unlock()
 	})
@@ -263,7 +535,12 @@ func (mpcg *ManagedPeerConnectionGroup) addDevice(device string) error {
 			if err != nil {
 				break
 			}
+			if pb, err = mpc.signalDescription(pb); err != nil {
+				log.Warn().Err(err).Str("Interface", device).Msg("failed to edit session description")
+				break
+			}
 			if err := client.Send(pb); err != nil {
+				mpc.setSignalFailed()
 				break
 			}
 		}
@@ -273,8 +550,13 @@ func (mpcg *ManagedPeerConnectionGroup) addDevice(device string) error {
 		for {
 			pb, err := client.Recv()
 			if err != nil {
+				log.Warn().Err(err).Str("Interface", device).Msg("signalling failed")
+				mpc.setSignalFailed()
 				break
 			}
+			if err := mpc.receiveDescription(pb); err != nil {
+				log.Warn().Err(err).Str("Interface", device).Msg("failed to negotiate ecn")
+			}
 			if err := signaller.WriteSignal(pb); err != nil {
 				break
 			}
@@ -283,51 +565,134 @@ func (mpcg *ManagedPeerConnectionGroup) addDevice(device string) error {
 
 	// add all existing sources.
 	for source := range mpcg.sources {
-		tl, err := webrtc.NewTrackLocalStaticRTP(source.codec, source.id, source.streamID)
-		if err != nil {
+		if err := mpcg.addTrack(mpc, source); err != nil {
 			return err
 		}
-		rtpSender, err := mpc.AddTrack(tl)
-		if err != nil {
-			return err
+	}
+
+	return nil
+}
+
+// allocatePathID returns the smallest path id not used by a connection so ids
+// of removed paths are reused. The caller must hold the group lock.
+func (mpcg *ManagedPeerConnectionGroup) allocatePathID() (uint8, error) {
+	var used [256]bool
+	for _, conn := range mpcg.conns {
+		used[conn.pathID] = true
+	}
+	for id := range used {
+		if !used[id] {
+			return uint8(id), nil
 		}
-		go func() {
-			for {
-				pkt, _, err := rtpSender.ReadRTCP()
-				if err != nil {
-					return
+	}
+	return 0, errors.New("no free path id")
+}
+
+// addTrack adds a track for the source to the given peer connection. The
+// caller must hold the group lock.
+func (mpcg *ManagedPeerConnectionGroup) addTrack(conn *ManagedPeerConnection, m *ManagedSource) error {
+	tl, err := newLocalTrack(m.codec, m.id, m.streamID)
+	if err != nil {
+		return err
+	}
+	rtpSender, err := conn.AddTrack(tl)
+	if err != nil {
+		return err
+	}
+	track := &ManagedTrack{
+		tl:        tl,
+		pc:        conn,
+		source:    m,
+		rtpSender: rtpSender,
+		ssrc:      uint32(rtpSender.GetParameters().Encodings[0].SSRC),
+	}
+	if conn.fec != nil && strings.HasPrefix(m.codec.MimeType, "video/") {
+		// RFC 8627 section 5.1.3: the repair stream is grouped with the stream
+		// it protects, its packets are sent on the other paths.
+		track.fec = conn.fec.Protect(track.ssrc, rand.Uint32(), FlexFECPayloadType)
+		conn.addSSRCGroup("FEC-FR", track.ssrc, track.fec.SSRC())
+	}
+	if m.rtxPayloadType != 0 {
+		// RFC 4588 section 8.3: each path's retransmission stream is associated
+		// with the media stream on the same path.
+		track.rtxSSRC = rand.Uint32()
+		conn.addSSRCGroup("FID", track.ssrc, track.rtxSSRC)
+	}
+	go func() {
+		for {
+			pkt, _, err := rtpSender.ReadRTCP()
+			if err != nil {
+				return
+			}
+			for _, p := range pkt {
+				switch p := p.(type) {
+				case *rtcp.TransportLayerNack:
+					if p.SenderSSRC == 0 {
+						continue // this is a cc nack.
+					}
+					m.handleNack(conn, p)
+				case *rtcp.RawPacket:
+					if f, ok := ecn.ParseFeedback(p); ok && conn.ecn != nil {
+						conn.ecn.Update(f)
+					}
+				case *rtcp.ReceiverReport:
+					for _, report := range p.Reports {
+						if rtt, ok := nack.RTT(report, time.Now()); ok {
+							conn.updateRTT(rtt)
+						}
+						if track.fec != nil && report.SSRC == track.ssrc {
+							track.fec.SetLoss(float64(report.FractionLost) / 256)
+						}
+					}
 				}
-				source.readRTCPCh <- pkt
 			}
-		}()
-		mpcg.tracks = append(mpcg.tracks, &ManagedTrack{
-			tl:        tl,
-			pc:        mpc,
-			source:    source,
-			rtpSender: rtpSender,
-		})
+			m.readRTCPCh <- pkt
+		}
+	}()
+	mpcg.tracks = append(mpcg.tracks, track)
+	return nil
+}
+
+// getSocketOptions returns the socket options for the device.
+func (mpcg *ManagedPeerConnectionGroup) getSocketOptions(device string) SocketOptions {
+	if o, ok := mpcg.socketOptions[device]; ok {
+		return o
+	}
+	if o, ok := mpcg.socketOptions["*"]; ok {
+		return o
 	}
+	return DefaultSocketOptions
+}
 
-	return nil
+// getCongestionController returns the congestion controller for the device.
+func (mpcg *ManagedPeerConnectionGroup) getCongestionController(device string) CongestionController {
+	if c, ok := mpcg.congestionControllers[device]; ok {
+		return c
+	}
+	return mpcg.congestionControllers["*"]
 }
 
+// removeDevice closes the connection on the device. The caller must hold the
+// group lock.
 func (mpcg *ManagedPeerConnectionGroup) removeDevice(device string) error {
-	mpcg.Lock()
-	defer mpcg.Unlock()
-
 	conn := mpcg.conns[device]
 
 	// remove this interface.
-	go conn.Close() // this can block so ignore.
+	close(conn.done)
+	conn.pacer.Close()
+	if conn.PeerConnection != nil {
+		go conn.Close() // this can block so ignore.
+	}
+	if conn.signal != nil {
+		if err := conn.signal.Close(); err != nil {
+			log.Warn().Err(err).Str("Interface", device).Msg("failed to close signalling channel")
+		}
+	}
 	delete(mpcg.conns, device)
 
 	cleaned := make([]*ManagedTrack, 0, len(mpcg.tracks))
 	for _, track := range mpcg.tracks {
-		if track.pc == conn {
-			if err := track.pc.Close(); err != nil {
-				return err
-			}
-		} else {
+		if track.pc != conn {
 			cleaned = append(cleaned, track)
 		}
 	}
@@ -341,55 +706,24 @@ func (mpcg *ManagedPeerConnectionGroup) AddSource(codec webrtc.RTPCodecCapabilit
 	mpcg.Lock()
 	defer mpcg.Unlock()
 
-	m := &ManagedSource{readRTCPCh: make(chan []rtcp.Packet), codec: codec, id: id, streamID: streamID, mpcg: mpcg, sendBuffer: [1 << 16]*rtp.Packet{}, t0: time.Now()}
+	m := &ManagedSource{readRTCPCh: make(chan []rtcp.Packet), codec: codec, id: id, streamID: streamID, mpcg: mpcg, clock: newMediaClock(codec.ClockRate)}
+
+	if mpcg.redDepth > 0 && strings.EqualFold(codec.MimeType, webrtc.MimeTypeOpus) && mpcg.remoteAccepts(webrtc.RTPCodecTypeAudio, red.MimeTypeRED) {
+		// publish the redundant encoding instead, the primary payload type is
+		// the opus payload type registered in addDevice. remotes that didn't
+		// answer with red get plain opus.
+		m.codec = REDCodec(mpcg.redDepth).RTPCodecCapability
+		m.red = red.NewEncoder(OpusPayloadType, mpcg.redDepth)
+	}
+	if c, ok := LookupCodec(codec.MimeType); mpcg.rtx && ok && c.RTXPayloadType != 0 {
+		m.rtxPayloadType = uint8(c.RTXPayloadType)
+	}
 
 	// add one track for each peer connection in the managed peer connection.
 	for _, conn := range mpcg.conns {
-		tl, err := webrtc.NewTrackLocalStaticRTP(codec, id, streamID)
-		if err != nil {
+		if err := mpcg.addTrack(conn, m); err != nil {
 			return nil, err
 		}
-		rtpSender, err := conn.AddTrack(tl)
-		if err != nil {
-			return nil, err
-		}
-		go func() {
-			for {
-				pkt, _, err := rtpSender.ReadRTCP()
-				if err != nil {
-					return
-				}
-				for _, p := range pkt {
-					nack, ok := p.(*rtcp.TransportLayerNack)
-					if !ok || nack.SenderSSRC == 0 {
-						continue // this is a cc nack.
-					}
-
-					for i := range nack.Nacks {
-						nack.Nacks[i].Range(func(seq uint16) bool {
-							if p := m.sendBuffer[seq]; p != nil {
-								log.Printf("resending packet %d", seq)
-								if err := m.WriteRTP(p); err != nil {
-									log.Error().Err(err).Msg("error sending nack packet")
-									return false
-								}
-							} else {
-								log.Warn().Msgf("nack packet not found: %d", seq)
-							}
-							return true
-						})
-					}
-
-				}
-				m.readRTCPCh <- pkt
-			}
-		}()
-		mpcg.tracks = append(mpcg.tracks, &ManagedTrack{
-			tl:        tl,
-			pc:        conn,
-			source:    m,
-			rtpSender: rtpSender,
-		})
 	}
 
 	mpcg.sources[m] = true
@@ -401,6 +735,10 @@ func (pc *ManagedPeerConnectionGroup) RemoveSource(source *ManagedSource) error
 	pc.Lock()
 	defer pc.Unlock()
 
+	return pc.removeSource(source)
+}
+
+func (pc *ManagedPeerConnectionGroup) removeSource(source *ManagedSource) error {
 	// for each track in the managed track, remove it from the peer connection.
 	cleaned := make([]*ManagedTrack, 0, len(pc.tracks))
 	for _, track := range pc.tracks {
@@ -408,6 +746,10 @@ func (pc *ManagedPeerConnectionGroup) RemoveSource(source *ManagedSource) error
 			if err := track.pc.RemoveTrack(track.rtpSender); err != nil {
 				return err
 			}
+			if track.fec != nil {
+				track.pc.fec.Unprotect(track.ssrc)
+			}
+			track.pc.removeSSRCGroups(track.ssrc)
 		} else {
 			cleaned = append(cleaned, track)
 		}
@@ -430,50 +772,431 @@ func (m *ManagedSource) ReadRTCP() ([]rtcp.Packet, error) {
 	return pkt, nil
 }
 
-// WriteRTP writes an RTP packet to a random track.
+// WriteRTP writes an RTP packet to a random track. Video packets that don't
+// fit the group's mtu are fragmented first.
 func (m *ManagedSource) WriteRTP(pkt *rtp.Packet) error {
-	m.sendBuffer[pkt.SequenceNumber] = pkt.Clone()
-	if track := m.randomConn(); track != nil {
-		return track.WriteRTP(pkt)
+	max := maxPayloadSize(m.mpcg.GetMTU())
+	m.units = fragment(m.codec, pkt.Payload, max, m.units[:0])
+	if len(m.units) == 0 {
+		if m.red != nil {
+			// the red payload is marshalled from the reused scratch buffer.
+			m.redPacket.Header = pkt.Header
+			m.redPacket.Payload = m.red.AppendPayload(m.redPacket.Payload[:0], pkt)
+			pkt = &m.redPacket
+		}
+		b, err := newPacketBuffer(pkt)
+		if err != nil {
+			return err
+		}
+		return m.writeRTP(b)
+	}
+	header := pkt.Header
+	header.Padding = false
+	for i, u := range m.units {
+		header.Marker = pkt.Marker && i == len(m.units)-1
+		header.SequenceNumber = pkt.SequenceNumber + uint16(i)
+		b, err := newFragmentBuffer(&header, u)
+		if err != nil {
+			return err
+		}
+		if err := m.writeRTP(b); err != nil {
+			return err
+		}
+	}
+	m.seqOffset += uint16(len(m.units) - 1)
+	return nil
+}
+
+// writeRTP stores and sends a marshalled packet after offsetting its sequence
+// number, the buffer is shared by the send buffer and the path it's sent on.
+// It takes over the reference to b.
+func (m *ManagedSource) writeRTP(b *packetBuffer) error {
+	defer b.Release()
+	b.SetSequenceNumber(b.Packet().SequenceNumber + m.seqOffset)
+	m.store(b)
+	return m.send(b, m.deadline(b.Packet()))
+}
+
+// store keeps a reference to b for retransmission, replacing the packet
+// previously sent with the same sequence number.
+func (m *ManagedSource) store(b *packetBuffer) {
+	seq := b.Packet().SequenceNumber
+	m.bufferLock.Lock()
+	prev := m.sendBuffer[seq]
+	m.sendBuffer[seq] = b.Retain()
+	m.bufferLock.Unlock()
+	atomic.StoreInt64(&m.resent[seq], 0)
+	if prev != nil {
+		prev.Release()
+	}
+}
+
+// load returns a reference to a buffered packet, or nil if there is none.
+func (m *ManagedSource) load(seq uint16) *packetBuffer {
+	m.bufferLock.Lock()
+	defer m.bufferLock.Unlock()
+
+	if b := m.sendBuffer[seq]; b != nil {
+		return b.Retain()
+	}
+	return nil
+}
+
+// deadline returns the time after which pkt can no longer be played out, or
+// the zero time if there is no latency target.
+func (m *ManagedSource) deadline(pkt *rtp.Packet) time.Time {
+	if m.mpcg.latency == 0 || m.codec.ClockRate == 0 {
+		return time.Time{}
+	}
+
+	return m.clock.Time(pkt.Timestamp, time.Now()).Add(m.mpcg.latency)
+}
+
+// handleNack resends the packets requested by a nack within the group's
+// retransmission budget. Keyframe and audio packets are resent first and
+// requests repeated within a round trip are ignored.
+func (m *ManagedSource) handleNack(conn *ManagedPeerConnection, p *rtcp.TransportLayerNack) {
+	var requested []*packetBuffer
+	for i := range p.Nacks {
+		p.Nacks[i].Range(func(seq uint16) bool {
+			if b := m.load(seq); b != nil {
+				requested = append(requested, b)
+			} else {
+				log.Warn().Msgf("nack packet not found: %d", seq)
+			}
+			return true
+		})
+	}
+	defer func() {
+		for _, b := range requested {
+			b.Release()
+		}
+	}()
+
+	audio := strings.HasPrefix(m.codec.MimeType, "audio/")
+	priority := make(map[*packetBuffer]bool, len(requested))
+	for _, b := range requested {
+		priority[b] = audio || isKeyframe(m.codec, b.Packet())
+	}
+	sort.SliceStable(requested, func(i, j int) bool {
+		return priority[requested[i]] && !priority[requested[j]]
+	})
+
+	track := m.track(conn, p.MediaSSRC)
+	budget := m.mpcg.budget
+	rtt := conn.RTT()
+	for _, b := range requested {
+		p := b.Packet()
+		now := time.Now()
+		deadline := m.deadline(p)
+		if !deadline.IsZero() && now.Add(rtt/2).After(deadline) {
+			// the retransmission would arrive after the playout point.
+			atomic.AddUint64(&m.mpcg.expired, 1)
+			continue
+		}
+		last := atomic.LoadInt64(&m.resent[p.SequenceNumber])
+		if last != 0 && now.Sub(time.Unix(0, last)) < rtt {
+			budget.Duplicate()
+			continue
+		}
+		if !budget.Allow(b.Len(), priority[b]) {
+			continue
+		}
+		atomic.StoreInt64(&m.resent[p.SequenceNumber], now.UnixNano())
+		log.Printf("resending packet %d", p.SequenceNumber)
+		if err := m.retransmit(track, b, deadline); err != nil {
+			log.Error().Err(err).Msg("error sending nack packet")
+			return
+		}
+	}
+}
+
+// track returns the source's track on conn with the given ssrc, or nil if
+// there is none.
+func (m *ManagedSource) track(conn *ManagedPeerConnection, ssrc uint32) *ManagedTrack {
+	m.mpcg.RLock()
+	defer m.mpcg.RUnlock()
+
+	for _, track := range m.mpcg.tracks {
+		if track.source == m && track.pc == conn && track.ssrc == ssrc {
+			return track
+		}
+	}
+	return nil
+}
+
+// retransmit resends a buffered packet on the rtx stream of the track that
+// was nacked. Without one the packet is resent as is on any path.
+func (m *ManagedSource) retransmit(track *ManagedTrack, b *packetBuffer, deadline time.Time) error {
+	if track == nil || track.rtxSSRC == 0 {
+		return m.send(b, deadline)
+	}
+	// RFC 4588 section 4: the original sequence number is prepended to the
+	// original payload and the packet is sent with the rtx sequence.
+	pkt := b.Packet()
+	header := pkt.Header
+	header.SSRC = track.rtxSSRC
+	header.PayloadType = m.rtxPayloadType
+	header.SequenceNumber = uint16(atomic.AddUint32(&track.rtxSequence, 1))
+	header.Padding = false
+
+	rb, err := newRTXPacketBuffer(&header, pkt)
+	if err != nil {
+		return err
+	}
+	defer rb.Release()
+	return track.WriteRTP(rb, deadline)
+}
+
+// sendRepair schedules a repair packet protecting the stream ssrc on conn onto
+// another connected path, so that losing the path doesn't lose the repair with
+// the media. It's only sent on the same path if there's no other. The repair
+// packet is copied since it's only valid during the call.
+func (mpcg *ManagedPeerConnectionGroup) sendRepair(conn *ManagedPeerConnection, ssrc uint32, repair *rtp.Packet) error {
+	mpcg.RLock()
+	var protected *ManagedTrack
+	for _, track := range mpcg.tracks {
+		if track.pc == conn && track.ssrc == ssrc {
+			protected = track
+			break
+		}
+	}
+	if protected == nil {
+		mpcg.RUnlock()
+		return nil
+	}
+	// waiting for a path to connect would stall the media path's pacer.
+	exclude := map[*ManagedPeerConnection]bool{conn: true}
+	for _, pc := range mpcg.conns {
+		if !pc.isConnected() {
+			exclude[pc] = true
+		}
+	}
+	track := protected.source.randomConn(exclude)
+	mpcg.RUnlock()
+	if track == nil {
+		track = protected
+	}
+
+	deadline := track.source.deadline(repair)
+	if !deadline.IsZero() && time.Now().After(deadline) {
+		atomic.AddUint64(&mpcg.expired, 1)
+		return nil
+	}
+	b, err := newPacketBuffer(repair)
+	if err != nil {
+		return err
+	}
+	defer b.Release()
+	b.repair = true
+	return track.WriteRTP(b, deadline)
+}
+
+// send writes a packet to a random track without buffering it.
+func (m *ManagedSource) send(b *packetBuffer, deadline time.Time) error {
+	if !deadline.IsZero() && time.Now().After(deadline) {
+		atomic.AddUint64(&m.mpcg.expired, 1)
+		return nil
+	}
+	if track := m.randomConn(nil); track != nil {
+		return track.WriteRTP(b, deadline)
 	} else {
 		log.Warn().Msg("no track to write to")
 	}
 	return nil
 }
 
-func (t *ManagedTrack) WriteRTP(pkt *rtp.Packet) error {
+// WriteRTP writes the packet once the connection is up. If the deadline
+// passes while waiting the packet is dropped instead. The pacer takes its own
+// reference to b.
+func (t *ManagedTrack) WriteRTP(b *packetBuffer, deadline time.Time) error {
+	if !t.pc.isConnected() && !t.waitConnected(deadline) {
+		atomic.AddUint64(&t.source.mpcg.expired, 1)
+		return nil
+	}
+	if strings.HasPrefix(t.source.codec.MimeType, "audio/") {
+		// audio is small and latency sensitive so it skips the pacer.
+		return t.write(b)
+	}
+	t.pc.pacer.Push(t, b.Retain(), deadline)
+	return nil
+}
+
+// waitConnected waits for the connection, it returns false if the deadline
+// passes first.
+func (t *ManagedTrack) waitConnected(deadline time.Time) bool {
 	t.pc.connectionStateCond.L.Lock()
-	for t.pc.ConnectionState() != webrtc.PeerConnectionStateConnected {
+	if !deadline.IsZero() {
+		timer := time.AfterFunc(time.Until(deadline), func() {
+			t.pc.connectionStateCond.L.Lock()
+			t.pc.connectionStateCond.Broadcast()
+			t.pc.connectionStateCond.L.Unlock()
+		})
+		defer timer.Stop()
+	}
+	expired := func() bool { return !deadline.IsZero() && time.Now().After(deadline) }
+	for t.pc.connectionState != webrtc.PeerConnectionStateConnected && !expired() {
 		t.pc.connectionStateCond.Wait()
 	}
 	t.pc.connectionStateCond.L.Unlock()
-	t.pc.bitsTransferred += uint64(pkt.MarshalSize() * 8)
+	return !expired()
+}
+
+func (t *ManagedTrack) write(b *packetBuffer) error {
+	size := b.Len()
+	if b.rtx {
+		atomic.AddUint64(&t.pc.rtxBitsTransferred, uint64(size*8))
+	}
+	atomic.AddUint64(&t.pc.bitsTransferred, uint64(size*8))
+	// header extension interceptors append to the track's copy of the header,
+	// capping the slice keeps them from writing into the shared buffer.
+	pkt := wirePacketPool.Get().(*rtp.Packet)
+	defer func() {
+		*pkt = rtp.Packet{}
+		wirePacketPool.Put(pkt)
+	}()
+	*pkt = *b.Packet()
+	pkt.Extensions = pkt.Extensions[:len(pkt.Extensions):len(pkt.Extensions)]
+	if b.rtx || b.repair {
+		return t.tl.writeAssociated(pkt)
+	}
 	return t.tl.WriteRTP(pkt)
 }
 
-func (s *ManagedSource) randomConn() *ManagedTrack {
-	bitrates := make(map[*ManagedTrack]int)
+// packetOverhead approximates the IPv4, UDP and SRTP bytes added to each RTP
+// packet.
+const packetOverhead = 20 + 8 + 10
+
+// QuotaSaveInterval is how often data usage is persisted.
+var QuotaSaveInterval = 30 * time.Second
+
+// usage returns the bytes transferred on device in the current quota period.
+func (mpcg *ManagedPeerConnectionGroup) usage(device string) uint64 {
+	if mpcg.quotas == nil {
+		return 0
+	}
+	return mpcg.quotas.Usage(device)
+}
+
+// udpOverhead and tcpOverhead approximate the IPv4 and transport header bytes
+// of each datagram or segment, so quotas are accounted conservatively.
+const (
+	udpOverhead = 20 + 8
+	tcpOverhead = 20 + 20
+)
+
+// countUsage returns a function that counts n bytes and the header overhead
+// against device's quota, or nil if there are no quotas. Usage is counted on
+// the sockets so everything sent and received, not only the media, counts.
+func (mpcg *ManagedPeerConnectionGroup) countUsage(device string, overhead int) func(n int) {
+	quotas := mpcg.quotas
+	if quotas == nil {
+		return nil
+	}
+	return func(n int) {
+		quotas.Add(device, uint64(n+overhead))
+	}
+}
+
+// countingConn counts the bytes read and written on a stream connection.
+type countingConn struct {
+	net.Conn
+	count func(n int)
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.count(n)
+	}
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.count(n)
+	}
+	return n, err
+}
+
+// weight returns the scheduling weight of a path, zero if it should not carry
+// media.
+func (mpcg *ManagedPeerConnectionGroup) weight(pc *ManagedPeerConnection) int {
+	if pc.IsStandby() {
+		return 0
+	}
+	bitrate := pc.GetEstimatedBitrate()
+	if mpcg.quotas != nil && mpcg.quotas.State(pc.device) == quota.Exhausted {
+		if q, _ := mpcg.quotas.Quota(pc.device); q.Disable {
+			return 0
+		}
+		bitrate /= 10
+	}
+	if mpcg.linkQuality != nil {
+		// scores aren't comparable across radios, so a path is only scaled
+		// back while its own signal is degrading.
+		if score, degrading, ok := mpcg.linkQuality.LinkQuality(pc.device); ok && degrading {
+			// never starve a path completely, its estimate still needs probing.
+			if score < 0.05 {
+				score = 0.05
+			}
+			bitrate = int(float64(bitrate) * score)
+		}
+	}
+	return bitrate
+}
+
+// randomConn picks a track weighted by the scheduling weight of its path,
+// skipping paths in exclude.
+func (s *ManagedSource) randomConn(exclude map[*ManagedPeerConnection]bool) *ManagedTrack {
+	// candidates stays on the stack for the usual handful of paths.
+	type candidate struct {
+		track  *ManagedTrack
+		weight int
+	}
+	var buf [8]candidate
+	candidates := buf[:0]
 	total := 0
 	for _, track := range s.mpcg.tracks {
-		if track.source != s {
+		if track.source != s || exclude[track.pc] {
 			continue
 		}
-		bitrates[track] = track.pc.GetEstimatedBitrate()
-		total += bitrates[track]
+		weight := s.mpcg.weight(track.pc)
+		candidates = append(candidates, candidate{track, weight})
+		total += weight
 	}
 	if total == 0 {
 		return nil
 	}
-	index := rand.Intn(int(total))
-	for track, bitrate := range bitrates {
-		if index < int(bitrate) {
-			return track
+	index := rand.Intn(total)
+	for _, c := range candidates {
+		if index < c.weight {
+			return c.track
 		}
-		index -= int(bitrate)
+		index -= c.weight
 	}
 	return nil
 }
 
+// RTT returns the smoothed round trip time of the connection, or a default of
+// 100ms if no receiver report has been received yet.
+func (pc *ManagedPeerConnection) RTT() time.Duration {
+	if rtt := atomic.LoadInt64(&pc.rtt); rtt > 0 {
+		return time.Duration(rtt)
+	}
+	return 100 * time.Millisecond
+}
+
+func (pc *ManagedPeerConnection) updateRTT(rtt time.Duration) {
+	prev := atomic.LoadInt64(&pc.rtt)
+	if prev == 0 {
+		atomic.StoreInt64(&pc.rtt, int64(rtt))
+		return
+	}
+	atomic.StoreInt64(&pc.rtt, prev*7/8+int64(rtt)/8)
+}
+
 func (pc *ManagedPeerConnection) GetEstimatedBitrate() int {
 	if len(pc.ccs) == 0 {
 		return 0
@@ -482,19 +1205,55 @@ func (pc *ManagedPeerConnection) GetEstimatedBitrate() int {
 	for _, cc := range pc.ccs {
 		totalBitrate += cc.GetTargetBitrate()
 	}
+	if pc.ecn != nil {
+		return int(float64(totalBitrate/len(pc.ccs)) * pc.ecn.Scale())
+	}
 	return totalBitrate / len(pc.ccs)
 }
 
-func (pc *ManagedPeerConnection) GetTransferredBitrate() int {
-	bitrate := int(float64(pc.bitsTransferred) / time.Since(pc.lastUpdate).Seconds())
-	pc.bitsTransferred = 0
+// windowed is implemented by window based estimators such as SCReAM, which
+// limit the bytes in flight.
+type windowed interface {
+	CanSend(size int) bool
+}
+
+// canSend checks that a packet fits in the congestion windows of the path.
+func (pc *ManagedPeerConnection) canSend(size int) bool {
+	for _, cc := range pc.ccs {
+		if w, ok := cc.(windowed); ok && !w.CanSend(size) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetQueueDelay returns how long packets are waiting in the path's pacer.
+func (pc *ManagedPeerConnection) GetQueueDelay() time.Duration {
+	return pc.pacer.QueueDelay()
+}
+
+// GetTransferredBitrate returns the total bitrate and the retransmission
+// bitrate since the last call.
+func (pc *ManagedPeerConnection) GetTransferredBitrate() (int, int) {
+	elapsed := time.Since(pc.lastUpdate).Seconds()
+	bitrate := int(float64(atomic.SwapUint64(&pc.bitsTransferred, 0)) / elapsed)
+	rtxBitrate := int(float64(atomic.SwapUint64(&pc.rtxBitsTransferred, 0)) / elapsed)
 	pc.lastUpdate = time.Now()
-	return bitrate
+	return bitrate, rtxBitrate
 }
 
+// GetEstimatedBitrate returns the combined estimate of the paths carrying
+// media, standby paths are excluded. It takes the group lock so it's safe to
+// call from the retransmission budget and the encoders.
 func (pcg *ManagedPeerConnectionGroup) GetEstimatedBitrate() int {
+	pcg.RLock()
+	defer pcg.RUnlock()
+
 	totalBitrate := 0
 	for _, pc := range pcg.conns {
+		if pc.IsStandby() {
+			continue
+		}
 		totalBitrate += pc.GetEstimatedBitrate()
 	}
 	return totalBitrate
@@ -506,7 +1265,15 @@ func (n *ManagedPeerConnectionGroup) Close() error {
 	defer n.Unlock()
 
 	n.cancel()
+	if n.quotas != nil {
+		n.quotas.Close()
+		if err := n.quotas.Save(); err != nil {
+			return err
+		}
+	}
 	for _, conn := range n.conns {
+		close(conn.done)
+		conn.pacer.Close()
 		if err := conn.Close(); err != nil {
 			return err
 		}
@@ -0,0 +1,61 @@
+package balancer
+
+import "testing"
+
+func TestAllocatePathID(t *testing.T) {
+	mpcg := &ManagedPeerConnectionGroup{conns: make(map[string]*ManagedPeerConnection)}
+	for i := 0; i < 256; i++ {
+		id, err := mpcg.allocatePathID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(id) != i {
+			t.Fatalf("got id %d, want %d", id, i)
+		}
+		mpcg.conns[string(rune(i))] = &ManagedPeerConnection{pathID: id}
+	}
+	if _, err := mpcg.allocatePathID(); err == nil {
+		t.Fatal("expected an error once every id is in use")
+	}
+	// a removed path's id is reused.
+	delete(mpcg.conns, string(rune(42)))
+	if id, err := mpcg.allocatePathID(); err != nil || id != 42 {
+		t.Errorf("got id %d, %v, want 42", id, err)
+	}
+}
+
+// fixedLinkQuality is a LinkQualityProvider with a fixed quality per device.
+type fixedLinkQuality map[string]struct {
+	score     float64
+	degrading bool
+}
+
+func (q fixedLinkQuality) LinkQuality(device string) (float64, bool, bool) {
+	l, ok := q[device]
+	return l.score, l.degrading, ok
+}
+
+func TestWeightLinkQuality(t *testing.T) {
+	mpcg := newBenchmarkGroup(t)
+	pc := mpcg.conns["bench0"]
+	full := mpcg.weight(pc)
+
+	tests := []struct {
+		name      string
+		score     float64
+		degrading bool
+		want      int
+	}{
+		{"Healthy", 0.6, false, full},
+		{"Degrading", 0.6, true, int(float64(full) * 0.6)},
+		{"Floor", 0, true, int(float64(full) * 0.05)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mpcg.linkQuality = fixedLinkQuality{"bench0": {tt.score, tt.degrading}}
+			if got := mpcg.weight(pc); got != tt.want {
+				t.Errorf("weight %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package balancer
+
+import (
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/report"
+	"github.com/pion/rtp"
+)
+
+// configureRTCPReports is webrtc.ConfigureRTCPReports with sender reports that
+// only count the media stream's own packets. Retransmissions and repair
+// packets are written through the media stream with their own ssrc and would
+// otherwise be reported as media.
+func configureRTCPReports(i *interceptor.Registry) error {
+	receiver, err := report.NewReceiverInterceptor()
+	if err != nil {
+		return err
+	}
+	sender, err := report.NewSenderInterceptor()
+	if err != nil {
+		return err
+	}
+	i.Add(receiver)
+	i.Add(&senderReportFactory{sender})
+	return nil
+}
+
+type senderReportFactory struct {
+	*report.SenderInterceptorFactory
+}
+
+func (f *senderReportFactory) NewInterceptor(id string) (interceptor.Interceptor, error) {
+	i, err := f.SenderInterceptorFactory.NewInterceptor(id)
+	if err != nil {
+		return nil, err
+	}
+	return &senderReportInterceptor{Interceptor: i}, nil
+}
+
+// senderReportInterceptor passes packets of associated streams around the
+// wrapped sender report interceptor.
+type senderReportInterceptor struct {
+	interceptor.Interceptor
+}
+
+func (s *senderReportInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	counted := s.Interceptor.BindLocalStream(info, writer)
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		if header.SSRC != info.SSRC {
+			return writer.Write(header, payload, attributes)
+		}
+		return counted.Write(header, payload, attributes)
+	})
+}
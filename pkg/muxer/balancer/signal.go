@@ -0,0 +1,102 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/muxable/rtpmagic/pkg/muxer/ecn"
+	signalapi "github.com/muxable/signal/api"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// description returns the session description carried by a signal, if any.
+func description(pb *anypb.Any) (string, bool, error) {
+	signal := &signalapi.Signal{}
+	if err := pb.UnmarshalTo(signal); err != nil {
+		return "", false, err
+	}
+	switch payload := signal.Payload.(type) {
+	case *signalapi.Signal_OfferSdp:
+		return payload.OfferSdp, true, nil
+	case *signalapi.Signal_AnswerSdp:
+		return payload.AnswerSdp, true, nil
+	}
+	return "", false, nil
+}
+
+// signalDescription adds what pion doesn't signal to an outgoing offer or
+// answer: the ssrc groups and, if the path marks ECT, the ECN capability.
+// pion rejects modified local descriptions so this is done on the way out.
+func (pc *ManagedPeerConnection) signalDescription(pb *anypb.Any) (*anypb.Any, error) {
+	signal := &signalapi.Signal{}
+	if err := pb.UnmarshalTo(signal); err != nil {
+		return nil, err
+	}
+	edit := func(sdp string) string {
+		sdp = addSSRCGroups(sdp, pc.ssrcGroupsOf())
+		if pc.ecn != nil {
+			sdp = ecn.AddCapability(sdp)
+		}
+		return sdp
+	}
+	switch payload := signal.Payload.(type) {
+	case *signalapi.Signal_OfferSdp:
+		payload.OfferSdp = edit(payload.OfferSdp)
+	case *signalapi.Signal_AnswerSdp:
+		payload.AnswerSdp = edit(payload.AnswerSdp)
+	default:
+		return pb, nil
+	}
+	return anypb.New(signal)
+}
+
+// receiveDescription applies an incoming offer or answer. Media is only
+// marked ECT once the remote declares that it reports CE marks.
+func (pc *ManagedPeerConnection) receiveDescription(pb *anypb.Any) error {
+	if pc.ecn == nil {
+		return nil
+	}
+	sdp, ok, err := description(pb)
+	if err != nil || !ok {
+		return err
+	}
+	return pc.setECNCapable(ecn.Capable(sdp))
+}
+
+// setECNCapable switches the ECT(1) marking of the path's socket.
+func (pc *ManagedPeerConnection) setECNCapable(capable bool) error {
+	var v int32
+	if capable {
+		v = 1
+	}
+	if atomic.SwapInt32(&pc.ecnCapable, v) == v {
+		return nil
+	}
+	log.Info().Str("Interface", pc.device).Bool("Capable", capable).Msg("ecn negotiated")
+	opts := pc.socketOptions
+	opts.ECN = capable
+	pc.marker.setTOS(opts.tos(opts.AudioDSCP))
+	return setTOS(pc.conn, opts.tos(opts.VideoDSCP))
+}
+
+// setTOS sets the default type of service byte of a socket.
+func setTOS(conn net.PacketConn, tos int) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("unsupported connection %T", conn)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
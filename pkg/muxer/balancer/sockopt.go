@@ -0,0 +1,192 @@
+package balancer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/muxable/rtpmagic/pkg/muxer/ecn"
+)
+
+const (
+	// DSCPExpeditedForwarding is the DSCP class for audio, RFC 4594.
+	DSCPExpeditedForwarding = 46
+	// DSCPAssuredForwarding41 is the DSCP class for interactive video.
+	DSCPAssuredForwarding41 = 34
+)
+
+// SocketOptions configures the sockets created for an interface. Zero values
+// leave the kernel defaults in place.
+type SocketOptions struct {
+	SendBuffer    int
+	ReceiveBuffer int
+	// AudioDSCP marks audio RTP packets, VideoDSCP marks everything else sent
+	// on the media socket.
+	AudioDSCP int
+	VideoDSCP int
+	// Priority sets SO_PRIORITY for local queueing disciplines.
+	Priority int
+	// Mark sets SO_MARK for policy routing and firewalling, this requires
+	// CAP_NET_ADMIN.
+	Mark int
+	// ECN marks media ECT(1) so that L4S queues signal congestion with CE
+	// marks instead of drops.
+	ECN bool
+}
+
+// DefaultSocketOptions are used for interfaces without explicit options.
+var DefaultSocketOptions = SocketOptions{
+	SendBuffer: 65536,
+	AudioDSCP:  DSCPExpeditedForwarding,
+	VideoDSCP:  DSCPAssuredForwarding41,
+}
+
+// tos returns the type of service byte for the DSCP.
+func (o SocketOptions) tos(dscp int) int {
+	tos := dscp << 2
+	if o.ECN {
+		tos |= int(ecn.ECT1)
+	}
+	return tos
+}
+
+// apply sets the options on the socket, tos is the default type of service
+// byte for packets sent on it.
+func (o SocketOptions) apply(fd int, tos int) error {
+	opts := []struct {
+		level, name, value int
+	}{
+		{syscall.SOL_SOCKET, syscall.SO_SNDBUF, o.SendBuffer},
+		{syscall.SOL_SOCKET, syscall.SO_RCVBUF, o.ReceiveBuffer},
+		{syscall.SOL_SOCKET, syscall.SO_PRIORITY, o.Priority},
+		{syscall.SOL_SOCKET, syscall.SO_MARK, o.Mark},
+		{syscall.IPPROTO_IP, syscall.IP_TOS, tos},
+	}
+	for _, opt := range opts {
+		if opt.value == 0 {
+			continue
+		}
+		if err := syscall.SetsockoptInt(fd, opt.level, opt.name, opt.value); err != nil {
+			return fmt.Errorf("failed to set socket option %d: %w", opt.name, err)
+		}
+	}
+	return nil
+}
+
+// control returns a net.Dialer or net.ListenConfig control function applying
+// the options.
+func (o SocketOptions) control(tos int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = o.apply(int(fd), tos)
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
+
+// ParseSocketOptions parses semicolon separated per-interface options of the
+// form IFACE:KEY=VALUE,..., for example
+// "usb0:sndbuf=1048576,priority=6;*:audio-dscp=46,video-dscp=34,ecn=1". The "*"
+// interface sets the defaults for interfaces that aren't listed.
+func ParseSocketOptions(s string) (map[string]SocketOptions, error) {
+	options := make(map[string]SocketOptions)
+	for _, section := range strings.Split(s, ";") {
+		section = strings.TrimSpace(section)
+		if section == "" {
+			continue
+		}
+		i := strings.Index(section, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid socket options %q", section)
+		}
+		o := DefaultSocketOptions
+		for _, kv := range strings.Split(section[i+1:], ",") {
+			j := strings.Index(kv, "=")
+			if j < 0 {
+				return nil, fmt.Errorf("invalid socket option %q", kv)
+			}
+			value, err := strconv.Atoi(kv[j+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid socket option %q: %w", kv, err)
+			}
+			switch kv[:j] {
+			case "sndbuf":
+				o.SendBuffer = value
+			case "rcvbuf":
+				o.ReceiveBuffer = value
+			case "audio-dscp":
+				o.AudioDSCP = value
+			case "video-dscp":
+				o.VideoDSCP = value
+			case "priority":
+				o.Priority = value
+			case "mark":
+				o.Mark = value
+			case "ecn":
+				o.ECN = value != 0
+			default:
+				return nil, fmt.Errorf("unknown socket option %q", kv[:j])
+			}
+		}
+		options[section[:i]] = o
+	}
+	return options, nil
+}
+
+// marker marks outgoing audio RTP packets with the audio DSCP. All other
+// packets keep the socket's default, which is set to the video DSCP.
+type marker struct {
+	audioPayloadTypes map[uint8]bool
+	// oob holds the []byte control message, it changes when ECN is negotiated.
+	oob atomic.Value
+}
+
+// newMarker returns nil if audio and video share the DSCP.
+func newMarker(opts SocketOptions, audioPayloadTypes map[uint8]bool) *marker {
+	if opts.AudioDSCP == opts.VideoDSCP {
+		return nil
+	}
+	m := &marker{audioPayloadTypes: audioPayloadTypes}
+	m.setTOS(opts.tos(opts.AudioDSCP))
+	return m
+}
+
+// setTOS changes the type of service byte of audio packets.
+func (m *marker) setTOS(tos int) {
+	if m == nil {
+		return
+	}
+	m.oob.Store(tosControlMessage(tos))
+}
+
+// mark returns the ancillary data to send p with, nil for the default.
+func (m *marker) mark(p []byte) []byte {
+	if m == nil || !isRTP(p) || !m.audioPayloadTypes[p[1]&0x7f] {
+		return nil
+	}
+	return m.oob.Load().([]byte)
+}
+
+// isRTP checks for an RTP or SRTP packet. RTP and RTCP have version 2 in the
+// top bits of the first byte, RTCP packet types overlap payload types 72-76
+// with the marker bit set.
+func isRTP(p []byte) bool {
+	return len(p) >= 12 && p[0]&0xc0 == 0x80 && (p[1] < 192 || p[1] > 223)
+}
+
+// tosControlMessage builds an IP_TOS ancillary message.
+func tosControlMessage(tos int) []byte {
+	b := make([]byte, syscall.CmsgSpace(4))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = syscall.IPPROTO_IP
+	h.Type = syscall.IP_TOS
+	h.SetLen(syscall.CmsgLen(4))
+	*(*int32)(unsafe.Pointer(&b[syscall.CmsgLen(0)])) = int32(tos)
+	return b
+}
@@ -0,0 +1,283 @@
+package balancer
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/muxable/rtpmagic/pkg/muxer/multipath"
+	"github.com/muxable/rtpmagic/pkg/muxer/nack"
+	"github.com/muxable/rtpmagic/pkg/muxer/red"
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// fixedEstimator is a cc.BandwidthEstimator with a constant estimate.
+type fixedEstimator int
+
+func (e fixedEstimator) AddStream(_ *interceptor.StreamInfo, w interceptor.RTPWriter) interceptor.RTPWriter {
+	return w
+}
+func (e fixedEstimator) WriteRTCP([]rtcp.Packet, interceptor.Attributes) error { return nil }
+func (e fixedEstimator) GetTargetBitrate() int                                 { return int(e) }
+func (e fixedEstimator) OnTargetBitrateChange(func(int))                       {}
+func (e fixedEstimator) GetStats() map[string]interface{}                      { return nil }
+func (e fixedEstimator) Close() error                                          { return nil }
+
+// newBenchmarkGroup returns a group with a single connected path whose track
+// isn't bound, so packets go through the whole send path but aren't written to
+// a socket.
+func newBenchmarkGroup(tb testing.TB, opts ...Option) *ManagedPeerConnectionGroup {
+	mpcg := &ManagedPeerConnectionGroup{
+		conns:   make(map[string]*ManagedPeerConnection),
+		sources: make(map[*ManagedSource]bool),
+		latency: time.Second,
+	}
+	for _, opt := range opts {
+		if err := opt(mpcg); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	addBenchmarkPath(tb, mpcg, "bench0")
+	return mpcg
+}
+
+// addBenchmarkPath adds a connected path with a fixed estimate.
+func addBenchmarkPath(tb testing.TB, mpcg *ManagedPeerConnectionGroup, device string) *ManagedPeerConnection {
+	pc := &ManagedPeerConnection{
+		device:              device,
+		connectionState:     webrtc.PeerConnectionStateConnected,
+		connectionStateCond: sync.NewCond(&sync.Mutex{}),
+		ccs:                 map[string]cc.BandwidthEstimator{"": fixedEstimator(1e12)},
+		lastUpdate:          time.Now(),
+		done:                make(chan struct{}),
+	}
+	pc.pacer = newPacer(pc.GetEstimatedBitrate, pc.canSend)
+	tb.Cleanup(pc.pacer.Close)
+	mpcg.conns[pc.device] = pc
+	return pc
+}
+
+// addBenchmarkSource adds a source with a track on every path.
+func addBenchmarkSource(tb testing.TB, mpcg *ManagedPeerConnectionGroup, codec webrtc.RTPCodecCapability) *ManagedSource {
+	m := &ManagedSource{codec: codec, mpcg: mpcg, clock: newMediaClock(codec.ClockRate)}
+	if mpcg.redDepth > 0 {
+		m.codec = REDCodec(mpcg.redDepth).RTPCodecCapability
+		m.red = red.NewEncoder(OpusPayloadType, mpcg.redDepth)
+	}
+	for _, pc := range mpcg.conns {
+		tl, err := newLocalTrack(m.codec, "bench", "bench")
+		if err != nil {
+			tb.Fatal(err)
+		}
+		ssrc := uint32(len(mpcg.tracks) + 1)
+		mpcg.tracks = append(mpcg.tracks, &ManagedTrack{tl: tl, pc: pc, source: m, ssrc: ssrc})
+	}
+	return m
+}
+
+// transferred waits for the paths to have sent the given numbers of bits.
+func transferred(t *testing.T, want map[*ManagedPeerConnection]uint64) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		done := true
+		for pc, bits := range want {
+			if got := atomic.LoadUint64(&pc.bitsTransferred); got != bits {
+				if time.Now().After(deadline) {
+					t.Fatalf("%s sent %d bits, want %d", pc.device, got, bits)
+				}
+				done = false
+			}
+		}
+		if done {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSendRepair(t *testing.T) {
+	h264 := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}
+	repair := &rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: FlexFECPayloadType, SSRC: 0xfec},
+		Payload: make([]byte, 100),
+	}
+	size := uint64(repair.MarshalSize() * 8)
+
+	t.Run("OtherPath", func(t *testing.T) {
+		mpcg := newBenchmarkGroup(t)
+		media := mpcg.conns["bench0"]
+		other := addBenchmarkPath(t, mpcg, "bench1")
+		addBenchmarkSource(t, mpcg, h264)
+		var ssrc uint32
+		for _, track := range mpcg.tracks {
+			if track.pc == media {
+				ssrc = track.ssrc
+			}
+		}
+		for i := 0; i < 10; i++ {
+			if err := mpcg.sendRepair(media, ssrc, repair); err != nil {
+				t.Fatal(err)
+			}
+		}
+		transferred(t, map[*ManagedPeerConnection]uint64{media: 0, other: 10 * size})
+	})
+
+	t.Run("SinglePath", func(t *testing.T) {
+		mpcg := newBenchmarkGroup(t)
+		media := mpcg.conns["bench0"]
+		addBenchmarkSource(t, mpcg, h264)
+		if err := mpcg.sendRepair(media, mpcg.tracks[0].ssrc, repair); err != nil {
+			t.Fatal(err)
+		}
+		transferred(t, map[*ManagedPeerConnection]uint64{media: size})
+	})
+}
+
+func TestAddSourceRED(t *testing.T) {
+	opus := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}
+	tests := []struct {
+		name   string
+		remote []webrtc.RTPCodecParameters
+		want   string
+	}{
+		{"Unnegotiated", nil, webrtc.MimeTypeOpus},
+		{"Opus", []webrtc.RTPCodecParameters{{RTPCodecCapability: opus, PayloadType: OpusPayloadType}}, webrtc.MimeTypeOpus},
+		{"RED", []webrtc.RTPCodecParameters{REDCodec(1), {RTPCodecCapability: opus, PayloadType: OpusPayloadType}}, red.MimeTypeRED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mpcg := &ManagedPeerConnectionGroup{
+				conns:      make(map[string]*ManagedPeerConnection),
+				sources:    make(map[*ManagedSource]bool),
+				negotiated: make(chan struct{}),
+				redDepth:   1,
+			}
+			if tt.remote != nil {
+				mpcg.remoteCodecs = map[webrtc.RTPCodecType][]webrtc.RTPCodecParameters{webrtc.RTPCodecTypeAudio: tt.remote}
+				close(mpcg.negotiated)
+			}
+			m, err := mpcg.AddSource(opus, "audio", "stream")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.codec.MimeType != tt.want {
+				t.Errorf("published %s, want %s", m.codec.MimeType, tt.want)
+			}
+			if (m.red != nil) != (tt.want == red.MimeTypeRED) {
+				t.Errorf("red encoder %v for %s", m.red, m.codec.MimeType)
+			}
+		})
+	}
+}
+
+// streamWriter is a webrtc.TrackLocalWriter that writes to an interceptor.
+type streamWriter struct {
+	writer interceptor.RTPWriter
+}
+
+func (w streamWriter) WriteRTP(header *rtp.Header, payload []byte) (int, error) {
+	return w.writer.Write(header, payload, nil)
+}
+
+func (w streamWriter) Write(b []byte) (int, error) {
+	pkt := &rtp.Packet{}
+	if err := pkt.Unmarshal(b); err != nil {
+		return 0, err
+	}
+	return w.WriteRTP(&pkt.Header, pkt.Payload)
+}
+
+func TestNackRTX(t *testing.T) {
+	const rtxSSRC, rtxPayloadType, extID = 0x1234, 99, 5
+
+	mpcg := newBenchmarkGroup(t)
+	mpcg.budget = nack.NewBudget(1, 250*time.Millisecond, mpcg.GetEstimatedBitrate)
+	m := addBenchmarkSource(t, mpcg, webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000})
+	m.rtxPayloadType = rtxPayloadType
+	track := mpcg.tracks[0]
+	track.rtxSSRC = rtxSSRC
+
+	// retransmissions go through the path's multipath interceptor.
+	f, err := multipath.NewHeaderExtensionInterceptor(3, rtxPayloadType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := f.NewInterceptor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := make(chan *rtp.Packet, 1)
+	info := &interceptor.StreamInfo{SSRC: track.ssrc, RTPHeaderExtensions: []interceptor.RTPHeaderExtension{{URI: multipath.URI, ID: extID}}}
+	track.tl.writeStream = streamWriter{i.BindLocalStream(info, interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+		sent <- &rtp.Packet{Header: header.Clone(), Payload: append([]byte(nil), payload...)}
+		return len(payload), nil
+	}))}
+
+	if err := m.WriteRTP(&rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 1000, SSRC: 1},
+		Payload: []byte{0x65, 1, 2, 3},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	m.handleNack(track.pc, &rtcp.TransportLayerNack{MediaSSRC: track.ssrc, Nacks: rtcp.NackPairsFromSequenceNumbers([]uint16{1000})})
+
+	select {
+	case pkt := <-sent:
+		if pkt.SSRC != rtxSSRC || pkt.PayloadType != rtxPayloadType {
+			t.Fatalf("retransmitted on ssrc %x payload type %d", pkt.SSRC, pkt.PayloadType)
+		}
+		if osn := binary.BigEndian.Uint16(pkt.Payload); osn != 1000 {
+			t.Errorf("original sequence number %d, want 1000", osn)
+		}
+		var ext multipath.Extension
+		if err := ext.Unmarshal(pkt.GetExtension(extID)); err != nil {
+			t.Fatal(err)
+		}
+		if ext.StreamSequence != 1000 {
+			t.Errorf("stream sequence %d, want the original 1000 rather than the rtx %d", ext.StreamSequence, pkt.SequenceNumber)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nack wasn't retransmitted")
+	}
+}
+
+func BenchmarkManagedSourceWriteRTP(b *testing.B) {
+	tests := []struct {
+		name    string
+		codec   webrtc.RTPCodecCapability
+		opts    []Option
+		payload int
+	}{
+		{"H264", webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}, nil, 1000},
+		{"H264Fragmented", webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}, nil, 5000},
+		{"OpusRED", webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, []Option{WithRED(2)}, 120},
+	}
+	for _, test := range tests {
+		b.Run(test.name, func(b *testing.B) {
+			mpcg := newBenchmarkGroup(b, test.opts...)
+			m := addBenchmarkSource(b, mpcg, test.codec)
+			payload := make([]byte, test.payload)
+			payload[0] = 0x65 // an idr slice.
+			pkt := &rtp.Packet{
+				Header:  rtp.Header{Version: 2, PayloadType: 96, SSRC: 1},
+				Payload: payload,
+			}
+			b.SetBytes(int64(test.payload))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				pkt.SequenceNumber = uint16(i)
+				pkt.Timestamp = uint32(i) * 3000
+				if err := m.WriteRTP(pkt); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package balancer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ssrcGroup is an RFC 5576 ssrc-group of a media stream and the stream that
+// carries its repair packets or retransmissions. pion doesn't signal these so
+// they're added to the session descriptions on the way out.
+type ssrcGroup struct {
+	semantics  string
+	ssrc       uint32
+	associated uint32
+}
+
+// addSSRCGroup signals ssrc and associated as a group from the next
+// negotiation on.
+func (pc *ManagedPeerConnection) addSSRCGroup(semantics string, ssrc, associated uint32) {
+	pc.ssrcGroupsLock.Lock()
+	defer pc.ssrcGroupsLock.Unlock()
+
+	pc.ssrcGroups = append(pc.ssrcGroups, ssrcGroup{semantics: semantics, ssrc: ssrc, associated: associated})
+}
+
+// removeSSRCGroups removes the groups of the media stream ssrc.
+func (pc *ManagedPeerConnection) removeSSRCGroups(ssrc uint32) {
+	pc.ssrcGroupsLock.Lock()
+	defer pc.ssrcGroupsLock.Unlock()
+
+	cleaned := pc.ssrcGroups[:0]
+	for _, g := range pc.ssrcGroups {
+		if g.ssrc != ssrc {
+			cleaned = append(cleaned, g)
+		}
+	}
+	pc.ssrcGroups = cleaned
+}
+
+// ssrcGroupsOf returns a copy of the groups to signal.
+func (pc *ManagedPeerConnection) ssrcGroupsOf() []ssrcGroup {
+	pc.ssrcGroupsLock.Lock()
+	defer pc.ssrcGroupsLock.Unlock()
+
+	return append([]ssrcGroup{}, pc.ssrcGroups...)
+}
+
+// addSSRCGroups declares each group in the media section of its media stream,
+// the associated stream gets the same ssrc attributes as the media stream.
+func addSSRCGroups(sdp string, groups []ssrcGroup) string {
+	if len(groups) == 0 {
+		return sdp
+	}
+	lines := strings.SplitAfter(sdp, "\n")
+	out := make([]string, 0, len(lines)+4*len(groups))
+	declared := make(map[ssrcGroup]bool)
+	for _, line := range lines {
+		var ssrc uint32
+		if _, err := fmt.Sscanf(line, "a=ssrc:%d ", &ssrc); err != nil {
+			out = append(out, line)
+			continue
+		}
+		var associated []string
+		for _, g := range groups {
+			if g.ssrc != ssrc {
+				continue
+			}
+			if !declared[g] {
+				declared[g] = true
+				out = append(out, fmt.Sprintf("a=ssrc-group:%s %d %d\r\n", g.semantics, g.ssrc, g.associated))
+			}
+			associated = append(associated, fmt.Sprintf("a=ssrc:%d%s", g.associated, strings.TrimPrefix(line, fmt.Sprintf("a=ssrc:%d", ssrc))))
+		}
+		out = append(out, line)
+		out = append(out, associated...)
+	}
+	return strings.Join(out, "")
+}
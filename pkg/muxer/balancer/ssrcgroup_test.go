@@ -0,0 +1,22 @@
+package balancer
+
+import "testing"
+
+func TestAddSSRCGroups(t *testing.T) {
+	sdp := "m=video 9 UDP/TLS/RTP/SAVPF 96 118\r\n" +
+		"a=ssrc:1 cname:a\r\n" +
+		"a=ssrc:1 msid:a b\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96 118\r\n" +
+		"a=ssrc:3 cname:a\r\n"
+	want := "m=video 9 UDP/TLS/RTP/SAVPF 96 118\r\n" +
+		"a=ssrc-group:FEC-FR 1 2\r\n" +
+		"a=ssrc:1 cname:a\r\n" +
+		"a=ssrc:2 cname:a\r\n" +
+		"a=ssrc:1 msid:a b\r\n" +
+		"a=ssrc:2 msid:a b\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96 118\r\n" +
+		"a=ssrc:3 cname:a\r\n"
+	if got := addSSRCGroups(sdp, []ssrcGroup{{"FEC-FR", 1, 2}}); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,114 @@
+package balancer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// StandbyKeepaliveInterval is how often an RTCP keepalive is sent on standby
+// paths to keep NAT bindings open alongside ICE consent checks.
+var StandbyKeepaliveInterval = 1 * time.Second
+
+// StandbyConnectGracePeriod is how long a new active path may take to connect
+// before it counts as failed.
+var StandbyConnectGracePeriod = 10 * time.Second
+
+// IsStandby reports whether the connection is currently held in standby and
+// carries no media.
+func (pc *ManagedPeerConnection) IsStandby() bool {
+	return atomic.LoadInt32(&pc.standby) == 1
+}
+
+func (pc *ManagedPeerConnection) setStandby(standby bool) {
+	if standby {
+		atomic.StoreInt32(&pc.standby, 1)
+	} else {
+		atomic.StoreInt32(&pc.standby, 0)
+	}
+}
+
+func (pc *ManagedPeerConnection) isConnected() bool {
+	pc.connectionStateCond.L.Lock()
+	defer pc.connectionStateCond.L.Unlock()
+
+	return pc.connectionState == webrtc.PeerConnectionStateConnected
+}
+
+// isFailed reports whether the connection was lost, or never connected within
+// the grace period.
+func (pc *ManagedPeerConnection) isFailed(now time.Time) bool {
+	pc.connectionStateCond.L.Lock()
+	defer pc.connectionStateCond.L.Unlock()
+
+	switch {
+	case pc.connectionState == webrtc.PeerConnectionStateConnected:
+		return false
+	case pc.connectionState == webrtc.PeerConnectionStateFailed || pc.wasConnected:
+		return true
+	default:
+		return now.Sub(pc.created) > StandbyConnectGracePeriod
+	}
+}
+
+// keepalive sends empty receiver reports while the connection is in standby
+// until done is closed.
+func (pc *ManagedPeerConnection) keepalive(done <-chan struct{}) {
+	ticker := time.NewTicker(StandbyKeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !pc.IsStandby() || !pc.isConnected() {
+				continue
+			}
+			if err := pc.WriteRTCP([]rtcp.Packet{&rtcp.ReceiverReport{}}); err != nil {
+				log.Warn().Err(err).Msg("failed to send standby keepalive")
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// updateStandby promotes standby paths when the active paths fail or their
+// combined estimate falls below the threshold, and demotes them again once the
+// active paths have recovered. The caller must hold the group lock.
+func (mpcg *ManagedPeerConnectionGroup) updateStandby() {
+	if len(mpcg.standbyDevices) == 0 {
+		return
+	}
+	now := time.Now()
+	total := 0
+	failed := false
+	for device, conn := range mpcg.conns {
+		if mpcg.standbyDevices[device] {
+			continue
+		}
+		if conn.isFailed(now) {
+			failed = true
+			continue
+		}
+		total += conn.GetEstimatedBitrate()
+	}
+
+	promote := failed || total < mpcg.standbyThreshold
+	// demote with some headroom so the path doesn't flap.
+	demote := !failed && float64(total) > 1.5*float64(mpcg.standbyThreshold)
+	for device, conn := range mpcg.conns {
+		if !mpcg.standbyDevices[device] {
+			continue
+		}
+		switch {
+		case promote && conn.IsStandby():
+			log.Info().Str("Interface", device).Int("ActiveBitrate", total).Bool("Failed", failed).Msg("promoting standby path")
+			conn.setStandby(false)
+		case demote && !conn.IsStandby():
+			log.Info().Str("Interface", device).Int("ActiveBitrate", total).Msg("returning path to standby")
+			conn.setStandby(true)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package balancer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestIsFailed(t *testing.T) {
+	created := time.Unix(0, 0)
+	tests := []struct {
+		name         string
+		state        webrtc.PeerConnectionState
+		wasConnected bool
+		now          time.Time
+		want         bool
+	}{
+		{"Connecting", webrtc.PeerConnectionStateConnecting, false, created.Add(time.Second), false},
+		{"ConnectTimeout", webrtc.PeerConnectionStateConnecting, false, created.Add(StandbyConnectGracePeriod + time.Second), true},
+		{"Connected", webrtc.PeerConnectionStateConnected, true, created.Add(time.Hour), false},
+		{"Disconnected", webrtc.PeerConnectionStateDisconnected, true, created.Add(time.Second), true},
+		{"Failed", webrtc.PeerConnectionStateFailed, false, created, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pc := &ManagedPeerConnection{
+				connectionState:     test.state,
+				connectionStateCond: sync.NewCond(&sync.Mutex{}),
+				wasConnected:        test.wasConnected,
+				created:             created,
+			}
+			if got := pc.isFailed(test.now); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package balancer
+
+import (
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// localTrack is a TrackLocalStaticRTP that can also write the packets of an
+// associated stream, such as retransmissions, with their own ssrc and payload
+// type. They share the track's interceptors so they're paced and counted by
+// the congestion controller like the media.
+type localTrack struct {
+	*webrtc.TrackLocalStaticRTP
+
+	mu          sync.RWMutex
+	writeStream webrtc.TrackLocalWriter
+}
+
+func newLocalTrack(codec webrtc.RTPCodecCapability, id, streamID string) (*localTrack, error) {
+	tl, err := webrtc.NewTrackLocalStaticRTP(codec, id, streamID)
+	if err != nil {
+		return nil, err
+	}
+	return &localTrack{TrackLocalStaticRTP: tl}, nil
+}
+
+// Bind records the write stream of the binding, a track is only added to a
+// single peer connection.
+func (t *localTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	codec, err := t.TrackLocalStaticRTP.Bind(ctx)
+	if err != nil {
+		return codec, err
+	}
+	t.mu.Lock()
+	t.writeStream = ctx.WriteStream()
+	t.mu.Unlock()
+	return codec, nil
+}
+
+func (t *localTrack) Unbind(ctx webrtc.TrackLocalContext) error {
+	t.mu.Lock()
+	t.writeStream = nil
+	t.mu.Unlock()
+	return t.TrackLocalStaticRTP.Unbind(ctx)
+}
+
+// writeAssociated writes pkt without rewriting its ssrc and payload type. The
+// packet is dropped if the track isn't bound yet.
+func (t *localTrack) writeAssociated(pkt *rtp.Packet) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.writeStream == nil {
+		return nil
+	}
+	_, err := t.writeStream.WriteRTP(&pkt.Header, pkt.Payload)
+	return err
+}
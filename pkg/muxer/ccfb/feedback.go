@@ -0,0 +1,143 @@
+// Package ccfb negotiates and consumes RFC 8888 congestion control feedback.
+package ccfb
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pion/rtcp"
+)
+
+// FormatCCFB is the transport layer feedback message type of RFC 8888.
+const FormatCCFB = 11
+
+// Report is the feedback for a single packet.
+type Report struct {
+	Received bool
+	ECN      uint8
+	// ArrivalTimeOffset is how long before the report timestamp the packet
+	// arrived, in 1/1024 seconds. 0x1FFF means too far in the past and 0x1FFE
+	// at least 0x1FFE.
+	ArrivalTimeOffset uint16
+}
+
+// ReportBlock holds the reports for consecutive packets of one stream.
+type ReportBlock struct {
+	MediaSSRC     uint32
+	BeginSequence uint16
+	Reports       []Report
+}
+
+// Feedback is an RFC 8888 congestion control feedback packet.
+type Feedback struct {
+	SenderSSRC uint32
+	Blocks     []ReportBlock
+	// ReportTimestamp is the middle 32 bits of the NTP time the report was
+	// generated at.
+	ReportTimestamp uint32
+}
+
+func (f *Feedback) len() int {
+	n := 4 + 4 + 4
+	for _, b := range f.Blocks {
+		n += 8 + (len(b.Reports)+1)/2*4
+	}
+	return n
+}
+
+func (f *Feedback) Marshal() ([]byte, error) {
+	buf := make([]byte, f.len())
+	h := rtcp.Header{
+		Count:  FormatCCFB,
+		Type:   rtcp.TypeTransportSpecificFeedback,
+		Length: uint16(len(buf)/4 - 1),
+	}
+	hb, err := h.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	copy(buf, hb)
+	binary.BigEndian.PutUint32(buf[4:], f.SenderSSRC)
+	offset := 8
+	for _, b := range f.Blocks {
+		binary.BigEndian.PutUint32(buf[offset:], b.MediaSSRC)
+		binary.BigEndian.PutUint16(buf[offset+4:], b.BeginSequence)
+		binary.BigEndian.PutUint16(buf[offset+6:], uint16(len(b.Reports)))
+		offset += 8
+		for i, r := range b.Reports {
+			var v uint16
+			if r.Received {
+				v = 1<<15 | uint16(r.ECN&0x03)<<13 | r.ArrivalTimeOffset&0x1fff
+			}
+			binary.BigEndian.PutUint16(buf[offset+2*i:], v)
+		}
+		offset += (len(b.Reports) + 1) / 2 * 4
+	}
+	binary.BigEndian.PutUint32(buf[offset:], f.ReportTimestamp)
+	return buf, nil
+}
+
+func (f *Feedback) Unmarshal(buf []byte) error {
+	var h rtcp.Header
+	if err := h.Unmarshal(buf); err != nil {
+		return err
+	}
+	if h.Type != rtcp.TypeTransportSpecificFeedback || h.Count != FormatCCFB {
+		return fmt.Errorf("not a congestion control feedback packet")
+	}
+	end := 4 * (int(h.Length) + 1)
+	if len(buf) < end || end < 12 {
+		return fmt.Errorf("congestion control feedback too short: %d bytes", len(buf))
+	}
+	f.SenderSSRC = binary.BigEndian.Uint32(buf[4:])
+	f.ReportTimestamp = binary.BigEndian.Uint32(buf[end-4:])
+	f.Blocks = f.Blocks[:0]
+	offset := 8
+	for offset+8 <= end-4 {
+		b := ReportBlock{
+			MediaSSRC:     binary.BigEndian.Uint32(buf[offset:]),
+			BeginSequence: binary.BigEndian.Uint16(buf[offset+4:]),
+		}
+		count := int(binary.BigEndian.Uint16(buf[offset+6:]))
+		offset += 8
+		if offset+(count+1)/2*4 > end-4 {
+			return fmt.Errorf("congestion control feedback block overflows packet")
+		}
+		b.Reports = make([]Report, count)
+		for i := range b.Reports {
+			v := binary.BigEndian.Uint16(buf[offset+2*i:])
+			b.Reports[i] = Report{
+				Received:          v&(1<<15) != 0,
+				ECN:               uint8(v>>13) & 0x03,
+				ArrivalTimeOffset: v & 0x1fff,
+			}
+		}
+		offset += (count + 1) / 2 * 4
+		f.Blocks = append(f.Blocks, b)
+	}
+	return nil
+}
+
+func (f *Feedback) DestinationSSRC() []uint32 {
+	ssrcs := make([]uint32, len(f.Blocks))
+	for i, b := range f.Blocks {
+		ssrcs[i] = b.MediaSSRC
+	}
+	return ssrcs
+}
+
+// ParseFeedback returns the feedback carried by a packet that pion/rtcp
+// doesn't know, which it unmarshals as a raw packet.
+func ParseFeedback(p rtcp.Packet) (*Feedback, bool) {
+	raw, ok := p.(*rtcp.RawPacket)
+	if !ok {
+		return nil, false
+	}
+	f := &Feedback{}
+	if err := f.Unmarshal(*raw); err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+var _ rtcp.Packet = (*Feedback)(nil)
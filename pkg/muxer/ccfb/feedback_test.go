@@ -0,0 +1,78 @@
+package ccfb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pion/rtcp"
+)
+
+func TestFeedbackRoundTrip(t *testing.T) {
+	f := &Feedback{
+		SenderSSRC: 1,
+		Blocks: []ReportBlock{
+			{
+				// an odd number of reports is padded to a word.
+				MediaSSRC:     2,
+				BeginSequence: 65535,
+				Reports: []Report{
+					{Received: true, ECN: 1, ArrivalTimeOffset: 10},
+					{},
+					{Received: true, ECN: 3, ArrivalTimeOffset: 0x1fff},
+				},
+			},
+			{
+				MediaSSRC:     3,
+				BeginSequence: 7,
+				Reports: []Report{
+					{Received: true, ArrivalTimeOffset: 0},
+					{Received: true, ECN: 2, ArrivalTimeOffset: 1},
+				},
+			},
+		},
+		ReportTimestamp: 0x12345678,
+	}
+	buf, err := f.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 4 + 4 + (8 + 8) + (8 + 4) + 4; len(buf) != want {
+		t.Fatalf("got %d bytes, want %d", len(buf), want)
+	}
+	// the padding is zero.
+	if buf[8+8+6] != 0 || buf[8+8+7] != 0 {
+		t.Errorf("got padding %x, want zeros", buf[8+8+6:8+8+8])
+	}
+
+	// pion doesn't know the format so it parses as a raw packet.
+	pkts, err := rtcp.Unmarshal(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkts) != 1 {
+		t.Fatalf("got %d packets, want 1", len(pkts))
+	}
+	got, ok := ParseFeedback(pkts[0])
+	if !ok {
+		t.Fatalf("failed to parse %T", pkts[0])
+	}
+	if !reflect.DeepEqual(got, f) {
+		t.Errorf("got %+v, want %+v", got, f)
+	}
+	if want := []uint32{2, 3}; !reflect.DeepEqual(got.DestinationSSRC(), want) {
+		t.Errorf("got destinations %v, want %v", got.DestinationSSRC(), want)
+	}
+}
+
+func TestFeedbackUnmarshalOverflow(t *testing.T) {
+	f := &Feedback{Blocks: []ReportBlock{{MediaSSRC: 2, Reports: make([]Report, 4)}}}
+	buf, err := f.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// claim more reports than the packet holds.
+	buf[8+7] = 9
+	if err := (&Feedback{}).Unmarshal(buf); err == nil {
+		t.Error("parsed a block that overflows the packet")
+	}
+}
@@ -0,0 +1,296 @@
+package ccfb
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// transportCCURI is the transport wide sequence number extension used by the
+// send side estimator.
+const transportCCURI = "http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01"
+
+// FeedbackHandler receives each feedback packet translated into transport
+// wide feedback, along with the number of CE marked and ECN capable packets
+// it reported.
+type FeedbackHandler func(twcc []rtcp.Packet, ce, ect int)
+
+// InterceptorFactory is an interceptor.Factory for an Interceptor.
+type InterceptorFactory struct {
+	handler FeedbackHandler
+}
+
+// NewInterceptor constructs a new Interceptor.
+func (f *InterceptorFactory) NewInterceptor(id string) (interceptor.Interceptor, error) {
+	return &Interceptor{handler: f.handler, history: make(map[uint32]*history)}, nil
+}
+
+// NewInterceptor returns a factory for interceptors that pass RFC 8888
+// feedback to handler.
+func NewInterceptor(handler FeedbackHandler) (*InterceptorFactory, error) {
+	return &InterceptorFactory{handler: handler}, nil
+}
+
+// historySize is the number of packets of a stream whose transport wide
+// sequence number is kept. Feedback arrives within a few round trips, this
+// covers over a second at 1000 packets per second.
+const historySize = 1 << 11
+
+// historyEntry maps an rtp sequence number to its transport wide one.
+type historyEntry struct {
+	sequence  uint16
+	transport uint16
+	valid     bool
+}
+
+// history is a ring of the most recent packets of a stream.
+type history [historySize]historyEntry
+
+func (h *history) add(sequence, transport uint16) {
+	h[sequence%historySize] = historyEntry{sequence: sequence, transport: transport, valid: true}
+}
+
+// get returns the transport wide sequence number of an rtp sequence number
+// that's still in the window.
+func (h *history) get(sequence uint16) (uint16, bool) {
+	e := h[sequence%historySize]
+	if !e.valid || e.sequence != sequence {
+		return 0, false
+	}
+	return e.transport, true
+}
+
+// Interceptor translates RFC 8888 feedback into transport wide feedback so
+// the existing send side estimator can consume it. Every packet is tagged with
+// a transport wide sequence number; if the receiver didn't negotiate the
+// extension it's added for the estimator's benefit and stripped before the
+// packet is sent. It must be registered before the congestion controller and
+// the transport wide header extension interceptor.
+type Interceptor struct {
+	interceptor.NoOp
+	sync.Mutex
+
+	handler FeedbackHandler
+	history map[uint32]*history
+	count   uint8
+}
+
+// BindLocalStream records the transport wide sequence number of each packet.
+func (i *Interceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	var hdrExtID uint8
+	used := make(map[int]bool)
+	for _, e := range info.RTPHeaderExtensions {
+		used[e.ID] = true
+		if e.URI == transportCCURI {
+			hdrExtID = uint8(e.ID)
+		}
+	}
+	strip := false
+	if hdrExtID == 0 {
+		// one byte extension ids run from 1 to 14.
+		for id := 1; id <= 14; id++ {
+			if !used[id] {
+				hdrExtID = uint8(id)
+				break
+			}
+		}
+		if hdrExtID == 0 {
+			return writer
+		}
+		info.RTPHeaderExtensions = append(info.RTPHeaderExtensions, interceptor.RTPHeaderExtension{URI: transportCCURI, ID: int(hdrExtID)})
+		strip = true
+	}
+
+	i.Lock()
+	h, ok := i.history[info.SSRC]
+	if !ok {
+		h = &history{}
+		i.history[info.SSRC] = h
+	}
+	i.Unlock()
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		var ext rtp.TransportCCExtension
+		if err := ext.Unmarshal(header.GetExtension(hdrExtID)); err == nil {
+			i.Lock()
+			h.add(header.SequenceNumber, ext.TransportSequence)
+			i.Unlock()
+		}
+		if strip {
+			if err := header.DelExtension(hdrExtID); err == nil {
+				header.Extension = len(header.Extensions) > 0
+			}
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// UnbindLocalStream forgets the stream's history.
+func (i *Interceptor) UnbindLocalStream(info *interceptor.StreamInfo) {
+	i.Lock()
+	defer i.Unlock()
+
+	delete(i.history, info.SSRC)
+}
+
+// BindRTCPReader passes congestion control feedback to the handler.
+func (i *Interceptor) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return 0, nil, err
+		}
+		if attr == nil {
+			attr = make(interceptor.Attributes)
+		}
+		pkts, err := attr.GetRTCPPackets(b[:n])
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, p := range pkts {
+			if f, ok := ParseFeedback(p); ok {
+				twcc, ce, ect := i.translate(f)
+				i.handler(twcc, ce, ect)
+			}
+		}
+		return n, attr, nil
+	})
+}
+
+// arrival is a reported packet on the transport wide sequence.
+type arrival struct {
+	sequence uint16
+	received bool
+	// at is the arrival time on the receiver's clock in microseconds.
+	at int64
+}
+
+// translate converts the feedback into transport wide feedback packets, one
+// per run of consecutive transport wide sequence numbers.
+func (i *Interceptor) translate(f *Feedback) ([]rtcp.Packet, int, int) {
+	// the report timestamp is in 1/65536 seconds.
+	rts := int64(f.ReportTimestamp) * 1e6 >> 16
+
+	var arrivals []arrival
+	ce, ect := 0, 0
+	i.Lock()
+	for _, b := range f.Blocks {
+		h, ok := i.history[b.MediaSSRC]
+		if !ok {
+			continue
+		}
+		for j, r := range b.Reports {
+			if r.Received && r.ECN != 0 {
+				ect++
+				if r.ECN == 3 {
+					ce++
+				}
+			}
+			transport, ok := h.get(b.BeginSequence + uint16(j))
+			if !ok || r.ArrivalTimeOffset >= 0x1ffe {
+				continue
+			}
+			arrivals = append(arrivals, arrival{
+				sequence: transport,
+				received: r.Received,
+				at:       rts - int64(r.ArrivalTimeOffset)*1e6/1024,
+			})
+		}
+	}
+	i.count++
+	count := i.count
+	i.Unlock()
+
+	if len(arrivals) == 0 {
+		return nil, ce, ect
+	}
+	ref := arrivals[0].sequence
+	sort.Slice(arrivals, func(a, b int) bool {
+		return int16(arrivals[a].sequence-ref) < int16(arrivals[b].sequence-ref)
+	})
+
+	var pkts []rtcp.Packet
+	start := 0
+	for j := 1; j <= len(arrivals); j++ {
+		if j < len(arrivals) && arrivals[j].sequence == arrivals[j-1].sequence+1 {
+			continue
+		}
+		if pkt := transportFeedback(arrivals[start:j], count); pkt != nil {
+			pkts = append(pkts, pkt)
+		}
+		start = j
+	}
+	return pkts, ce, ect
+}
+
+// transportFeedback builds a transport wide feedback packet for consecutive
+// arrivals using run length chunks.
+func transportFeedback(arrivals []arrival, count uint8) *rtcp.TransportLayerCC {
+	var first int64
+	received := false
+	for _, a := range arrivals {
+		if a.received {
+			first, received = a.at, true
+			break
+		}
+	}
+	if !received {
+		return nil
+	}
+	const referenceUnit = 64000
+	pkt := &rtcp.TransportLayerCC{
+		Header: rtcp.Header{
+			Count: rtcp.FormatTCC,
+			Type:  rtcp.TypeTransportSpecificFeedback,
+		},
+		BaseSequenceNumber: arrivals[0].sequence,
+		PacketStatusCount:  uint16(len(arrivals)),
+		ReferenceTime:      uint32(first/referenceUnit) & 0xffffff,
+		FbPktCount:         count,
+	}
+
+	last := first / referenceUnit * referenceUnit
+	var chunk *rtcp.RunLengthChunk
+	for _, a := range arrivals {
+		symbol := uint16(rtcp.TypeTCCPacketNotReceived)
+		if a.received {
+			delta := (a.at - last) / rtcp.TypeTCCDeltaScaleFactor
+			if delta > 32767 {
+				delta = 32767
+			} else if delta < -32768 {
+				delta = -32768
+			}
+			symbol = rtcp.TypeTCCPacketReceivedLargeDelta
+			if delta >= 0 && delta <= 255 {
+				symbol = rtcp.TypeTCCPacketReceivedSmallDelta
+			}
+			pkt.RecvDeltas = append(pkt.RecvDeltas, &rtcp.RecvDelta{Type: symbol, Delta: delta * rtcp.TypeTCCDeltaScaleFactor})
+			last += delta * rtcp.TypeTCCDeltaScaleFactor
+		}
+		if chunk == nil || chunk.PacketStatusSymbol != symbol || chunk.RunLength == 0x1fff {
+			chunk = &rtcp.RunLengthChunk{Type: rtcp.TypeTCCRunLengthChunk, PacketStatusSymbol: symbol}
+			pkt.PacketChunks = append(pkt.PacketChunks, chunk)
+		}
+		chunk.RunLength++
+	}
+	pkt.Header.Length = pkt.Len()/4 - 1
+	return pkt
+}
+
+// ConfigureFeedback negotiates RFC 8888 feedback for audio and video and adds
+// the interceptor consuming it. It must be called before the congestion
+// controller is added to the registry.
+func ConfigureFeedback(m *webrtc.MediaEngine, i *interceptor.Registry, handler FeedbackHandler) error {
+	m.RegisterFeedback(webrtc.RTCPFeedback{Type: "ack", Parameter: "ccfb"}, webrtc.RTPCodecTypeVideo)
+	m.RegisterFeedback(webrtc.RTCPFeedback{Type: "ack", Parameter: "ccfb"}, webrtc.RTPCodecTypeAudio)
+	f, err := NewInterceptor(handler)
+	if err != nil {
+		return err
+	}
+	i.Add(f)
+	return nil
+}
@@ -0,0 +1,144 @@
+package ccfb
+
+import (
+	"testing"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+const mediaSSRC = 0x11223344
+
+// bind returns an interceptor with a stream bound that has no transport wide
+// extension negotiated, and a writer that tags packets as the transport wide
+// interceptor would.
+func bind(t *testing.T) (*Interceptor, func(seq, transport uint16)) {
+	f, err := NewInterceptor(func([]rtcp.Packet, int, int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := f.NewInterceptor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &interceptor.StreamInfo{SSRC: mediaSSRC}
+	writer := i.BindLocalStream(info, interceptor.RTPWriterFunc(func(header *rtp.Header, _ []byte, _ interceptor.Attributes) (int, error) {
+		if header.Extension {
+			t.Errorf("the transport wide extension wasn't stripped")
+		}
+		return 0, nil
+	}))
+	if len(info.RTPHeaderExtensions) != 1 {
+		t.Fatalf("got extensions %v, want the transport wide extension", info.RTPHeaderExtensions)
+	}
+	id := uint8(info.RTPHeaderExtensions[0].ID)
+	return i.(*Interceptor), func(seq, transport uint16) {
+		header := &rtp.Header{Version: 2, SequenceNumber: seq, SSRC: mediaSSRC}
+		ext, err := (&rtp.TransportCCExtension{TransportSequence: transport}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := header.SetExtension(id, ext); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write(header, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	i, write := bind(t)
+	// the rtp sequence wraps while the transport sequence doesn't.
+	for j := 0; j < 10; j++ {
+		write(65530+uint16(j), 5000+uint16(j))
+	}
+
+	// packets 3 and 4 are lost, the report is generated at 10s.
+	f := &Feedback{ReportTimestamp: 10 << 16}
+	block := ReportBlock{MediaSSRC: mediaSSRC, BeginSequence: 65530}
+	want := map[uint16]int64{}
+	for j := 0; j < 10; j++ {
+		if j == 3 || j == 4 {
+			block.Reports = append(block.Reports, Report{})
+			continue
+		}
+		r := Report{Received: true, ECN: 1, ArrivalTimeOffset: uint16(10-j) * 20}
+		if j == 7 {
+			r.ECN = 3
+		}
+		block.Reports = append(block.Reports, r)
+		want[5000+uint16(j)] = 10_000_000 - int64(r.ArrivalTimeOffset)*1e6/1024
+	}
+	f.Blocks = append(f.Blocks, block)
+
+	pkts, ce, ect := i.translate(f)
+	if ce != 1 || ect != 8 {
+		t.Errorf("got %d ce of %d ect, want 1 of 8", ce, ect)
+	}
+	if len(pkts) != 1 {
+		t.Fatalf("got %d packets, want 1", len(pkts))
+	}
+
+	// check the packet as pion's estimator would see it.
+	buf, err := rtcp.Marshal(pkts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := rtcp.Unmarshal(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcc, ok := parsed[0].(*rtcp.TransportLayerCC)
+	if !ok {
+		t.Fatalf("got %T, want transport wide feedback", parsed[0])
+	}
+	if tcc.BaseSequenceNumber != 5000 || tcc.PacketStatusCount != 10 {
+		t.Fatalf("got base %d and count %d, want 5000 and 10", tcc.BaseSequenceNumber, tcc.PacketStatusCount)
+	}
+	var statuses []uint16
+	for _, chunk := range tcc.PacketChunks {
+		c := chunk.(*rtcp.RunLengthChunk)
+		for k := uint16(0); k < c.RunLength; k++ {
+			statuses = append(statuses, c.PacketStatusSymbol)
+		}
+	}
+	at := int64(tcc.ReferenceTime) * 64000
+	deltas := tcc.RecvDeltas
+	for j, status := range statuses {
+		seq := 5000 + uint16(j)
+		expected, received := want[seq]
+		if received != (status != rtcp.TypeTCCPacketNotReceived) {
+			t.Fatalf("packet %d got status %d", seq, status)
+		}
+		if !received {
+			continue
+		}
+		at += deltas[0].Delta
+		deltas = deltas[1:]
+		if d := at - expected; d < -rtcp.TypeTCCDeltaScaleFactor || d > rtcp.TypeTCCDeltaScaleFactor {
+			t.Errorf("packet %d arrived at %dus, want %dus", seq, at, expected)
+		}
+	}
+}
+
+func TestTranslateWindow(t *testing.T) {
+	i, write := bind(t)
+	write(0, 1)
+	// the packet falls out of the window once its slot is reused.
+	write(historySize, 2)
+
+	f := &Feedback{ReportTimestamp: 10 << 16, Blocks: []ReportBlock{{
+		MediaSSRC:     mediaSSRC,
+		BeginSequence: 0,
+		Reports:       []Report{{Received: true}},
+	}}}
+	if pkts, _, _ := i.translate(f); len(pkts) != 0 {
+		t.Errorf("got %d packets for a packet outside the window", len(pkts))
+	}
+	f.Blocks[0].BeginSequence = historySize
+	if pkts, _, _ := i.translate(f); len(pkts) != 1 {
+		t.Errorf("got %d packets, want 1", len(pkts))
+	}
+}
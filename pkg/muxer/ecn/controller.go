@@ -0,0 +1,83 @@
+package ecn
+
+import (
+	"sync"
+)
+
+// Controller scales a path's target bitrate by the fraction of packets the
+// network marked CE, in the style of DCTCP and TCP Prague. Marks are applied
+// by shallow AQM queues so the rate backs off before a standing queue, and
+// with it delay or loss, builds up.
+type Controller struct {
+	sync.Mutex
+
+	// Gain is the weight of each feedback in the marking rate average.
+	Gain float64
+	// Increase is added to the scale for each feedback without marks.
+	Increase float64
+	// MinimumScale bounds the back off.
+	MinimumScale float64
+
+	alpha float64
+	scale float64
+	prev  map[uint32]Feedback
+}
+
+func NewController() *Controller {
+	return &Controller{
+		Gain:         1.0 / 16,
+		Increase:     0.02,
+		MinimumScale: 0.1,
+		scale:        1,
+		prev:         make(map[uint32]Feedback),
+	}
+}
+
+// Update applies an RFC 6679 feedback report.
+func (c *Controller) Update(f *Feedback) {
+	c.Lock()
+	prev, ok := c.prev[f.MediaSSRC]
+	c.prev[f.MediaSSRC] = *f
+	c.Unlock()
+	if !ok {
+		return
+	}
+	marked := uint32(f.CE - prev.CE)
+	c.Observe(int(marked), int((f.ECT0-prev.ECT0)+(f.ECT1-prev.ECT1)+marked))
+}
+
+// Observe applies the number of CE marked packets out of the ECN capable
+// packets received since the last report, as carried by RFC 8888 feedback.
+func (c *Controller) Observe(marked, total int) {
+	c.Lock()
+	defer c.Unlock()
+
+	if total == 0 {
+		return
+	}
+	c.alpha = (1-c.Gain)*c.alpha + c.Gain*float64(marked)/float64(total)
+	if marked > 0 {
+		c.scale *= 1 - c.alpha/2
+		if c.scale < c.MinimumScale {
+			c.scale = c.MinimumScale
+		}
+	} else if c.scale += c.Increase; c.scale > 1 {
+		c.scale = 1
+	}
+}
+
+// Scale returns the factor to apply to the target bitrate.
+func (c *Controller) Scale() float64 {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.scale
+}
+
+// MarkingRate returns the smoothed fraction of CE marked packets.
+func (c *Controller) MarkingRate() float64 {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.alpha
+}
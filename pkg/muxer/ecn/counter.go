@@ -0,0 +1,184 @@
+package ecn
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// Counter accumulates the codepoints of the packets received on one stream.
+type Counter struct {
+	sync.Mutex
+
+	ssrc     uint32
+	started  bool
+	cycles   uint32
+	base     uint32
+	highest  uint16
+	received uint32
+
+	ect0, ect1 uint32
+	ce, notECT uint16
+
+	// the counts at the last reception report.
+	prevExpected, prevReceived uint32
+}
+
+func NewCounter(ssrc uint32) *Counter {
+	return &Counter{ssrc: ssrc}
+}
+
+// Add counts a received packet.
+func (c *Counter) Add(seq uint16, codepoint uint8) {
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.started {
+		c.started = true
+		c.base = uint32(seq)
+		c.highest = seq
+	} else if diff := seq - c.highest; diff != 0 && diff < 1<<15 {
+		if seq < c.highest {
+			c.cycles += 1 << 16
+		}
+		c.highest = seq
+	}
+	c.received++
+
+	switch codepoint {
+	case ECT0:
+		c.ect0++
+	case ECT1:
+		c.ect1++
+	case CE:
+		c.ce++
+	default:
+		c.notECT++
+	}
+}
+
+// Feedback returns the cumulative counters as a feedback packet.
+func (c *Counter) Feedback(senderSSRC uint32) *Feedback {
+	c.Lock()
+	defer c.Unlock()
+
+	highest := c.cycles | uint32(c.highest)
+	lost := int64(highest-c.base+1) - int64(c.received)
+	if lost < 0 {
+		lost = 0
+	}
+	return &Feedback{
+		SenderSSRC:              senderSSRC,
+		MediaSSRC:               c.ssrc,
+		ExtendedHighestSequence: highest,
+		ECT0:                    c.ect0,
+		ECT1:                    c.ect1,
+		CE:                      c.ce,
+		NotECT:                  c.notECT,
+		Lost:                    uint16(lost),
+	}
+}
+
+// Report returns a reception report of the packets counted since the last
+// report. Jitter and the last sender report aren't tracked so they're zero.
+func (c *Counter) Report() rtcp.ReceptionReport {
+	c.Lock()
+	defer c.Unlock()
+
+	highest := c.cycles | uint32(c.highest)
+	expected := highest - c.base + 1
+	lost := int64(expected) - int64(c.received)
+	if lost < 0 {
+		lost = 0
+	}
+	var fraction uint8
+	interval := int64(expected - c.prevExpected)
+	if lostInterval := interval - int64(c.received-c.prevReceived); interval > 0 && lostInterval > 0 {
+		fraction = uint8(lostInterval * 255 / interval)
+	}
+	c.prevExpected, c.prevReceived = expected, c.received
+	return rtcp.ReceptionReport{
+		SSRC:               c.ssrc,
+		FractionLost:       fraction,
+		TotalLost:          uint32(lost),
+		LastSequenceNumber: highest,
+	}
+}
+
+// FeedbackInterval is how often a receiver should report to each sender.
+var FeedbackInterval = 50 * time.Millisecond
+
+// Receiver is a connection that counts the ECN codepoints of the RTP packets
+// read from it per stream. The RTP header isn't encrypted by SRTP so it can
+// sit below a DTLS-SRTP stack, for example as the connection of a pion ICE UDP
+// mux, as well as carry plain RTP.
+type Receiver struct {
+	*net.UDPConn
+
+	// oob is reused, ReadFrom must not be called concurrently.
+	oob []byte
+
+	mu       sync.Mutex
+	counters map[uint32]*Counter
+}
+
+// NewReceiver enables codepoint reporting on conn.
+func NewReceiver(conn *net.UDPConn) (*Receiver, error) {
+	if err := EnableReceive(conn); err != nil {
+		return nil, err
+	}
+	return &Receiver{
+		UDPConn:  conn,
+		oob:      make([]byte, OOBSize),
+		counters: make(map[uint32]*Counter),
+	}, nil
+}
+
+// ReadFrom reads a packet, counting its codepoint if it's RTP.
+func (r *Receiver) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, codepoint, err := ReadFrom(r.UDPConn, p, r.oob)
+	if err != nil {
+		return n, nil, err
+	}
+	// RTCP packet types overlap payload types 72-76 with the marker bit set.
+	if n >= 12 && p[0]&0xc0 == 0x80 && (p[1] < 192 || p[1] > 223) {
+		ssrc := binary.BigEndian.Uint32(p[8:12])
+		r.mu.Lock()
+		counter, ok := r.counters[ssrc]
+		if !ok {
+			counter = NewCounter(ssrc)
+			r.counters[ssrc] = counter
+		}
+		r.mu.Unlock()
+		counter.Add(binary.BigEndian.Uint16(p[2:4]), codepoint)
+	}
+	return n, addr, nil
+}
+
+// Feedback returns the feedback for the stream ssrc, or nil if none of its
+// packets were read. RFC 6679 section 5.1 sends the feedback in a compound
+// packet after a receiver report, which is also what lets SRTP stacks such as
+// pion route it to the stream's sender.
+func (r *Receiver) Feedback(ssrc, senderSSRC uint32) []rtcp.Packet {
+	r.mu.Lock()
+	counter, ok := r.counters[ssrc]
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return []rtcp.Packet{
+		&rtcp.ReceiverReport{SSRC: senderSSRC, Reports: []rtcp.ReceptionReport{counter.Report()}},
+		counter.Feedback(senderSSRC),
+	}
+}
+
+// Forget stops counting the stream ssrc.
+func (r *Receiver) Forget(ssrc uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.counters, ssrc)
+}
@@ -0,0 +1,125 @@
+package ecn
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+func TestCounterWrap(t *testing.T) {
+	c := NewCounter(1)
+	// 65533 is reordered after the wrap and 2 is lost.
+	for _, seq := range []uint16{65534, 65535, 0, 65533, 1, 3} {
+		c.Add(seq, ECT1)
+	}
+	c.Add(4, CE)
+
+	f := c.Feedback(2)
+	if want := uint32(1<<16 + 4); f.ExtendedHighestSequence != want {
+		t.Errorf("got highest %d, want %d", f.ExtendedHighestSequence, want)
+	}
+	if f.ECT1 != 6 || f.CE != 1 {
+		t.Errorf("got %d ect(1) and %d ce, want 6 and 1", f.ECT1, f.CE)
+	}
+	// the reordered packet is before the base so it makes up for the loss.
+	if f.Lost != 0 {
+		t.Errorf("got %d lost, want 0", f.Lost)
+	}
+}
+
+func TestCounterReport(t *testing.T) {
+	c := NewCounter(1)
+	for seq := uint16(65530); seq != 10; seq++ {
+		if seq%4 != 0 {
+			c.Add(seq, ECT1)
+		}
+	}
+	r := c.Report()
+	if want := uint32(1<<16 + 9); r.LastSequenceNumber != want {
+		t.Errorf("got highest %d, want %d", r.LastSequenceNumber, want)
+	}
+	if r.TotalLost != 4 {
+		t.Errorf("got %d lost, want 4", r.TotalLost)
+	}
+	// the fraction only covers the packets since the last report.
+	c.Add(10, ECT1)
+	c.Add(11, ECT1)
+	if r := c.Report(); r.FractionLost != 0 || r.TotalLost != 4 {
+		t.Errorf("got fraction %d and %d lost, want 0 and 4", r.FractionLost, r.TotalLost)
+	}
+	c.Add(14, ECT1)
+	if r := c.Report(); r.FractionLost != 170 {
+		t.Errorf("got fraction %d, want 170", r.FractionLost)
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r, err := NewReceiver(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	raw, err := sender.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(seq uint16, codepoint uint8) {
+		var sockErr error
+		if err := raw.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, int(codepoint))
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if sockErr != nil {
+			t.Fatal(sockErr)
+		}
+		buf, err := (&rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: seq, SSRC: 7}}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sender.Write(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(1, ECT1)
+	send(2, CE)
+	send(3, NotECT)
+	// a stun binding request isn't counted.
+	if _, err := sender.Write(append([]byte{0x00, 0x01, 0x00, 0x00, 0x21, 0x12, 0xa4, 0x42}, make([]byte, 12)...)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	for i := 0; i < 4; i++ {
+		if _, _, err := r.ReadFrom(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkts := r.Feedback(7, 9)
+	if len(pkts) != 2 {
+		t.Fatalf("got %d packets, want a report and feedback", len(pkts))
+	}
+	f := pkts[1].(*Feedback)
+	if f.ECT1 != 1 || f.CE != 1 || f.NotECT != 1 {
+		t.Errorf("got %+v, want one packet of each codepoint", f)
+	}
+	if r.Feedback(8, 9) != nil {
+		t.Error("got feedback for an unknown stream")
+	}
+}
@@ -0,0 +1,54 @@
+// Package ecn marks, reads and reports explicit congestion notification for
+// RTP over UDP as described in RFC 6679, and reacts to it L4S style by
+// backing off in proportion to the fraction of marked packets.
+package ecn
+
+import (
+	"net"
+	"syscall"
+)
+
+// Codepoints carried in the low two bits of the IPv4 TOS byte.
+const (
+	NotECT uint8 = 0
+	ECT1   uint8 = 1
+	ECT0   uint8 = 2
+	CE     uint8 = 3
+)
+
+// OOBSize is the ancillary buffer size needed by ReadFrom.
+var OOBSize = syscall.CmsgSpace(4)
+
+// EnableReceive asks the kernel to report the TOS byte of received packets.
+func EnableReceive(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVTOS, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// ReadFrom reads a packet and its ECN codepoint from a connection with
+// EnableReceive set. oob must be at least OOBSize bytes.
+func ReadFrom(conn *net.UDPConn, p, oob []byte) (int, *net.UDPAddr, uint8, error) {
+	n, oobn, _, addr, err := conn.ReadMsgUDP(p, oob)
+	if err != nil {
+		return 0, nil, NotECT, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return n, addr, NotECT, nil
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == syscall.IPPROTO_IP && msg.Header.Type == syscall.IP_TOS && len(msg.Data) > 0 {
+			return n, addr, msg.Data[0] & 0x03, nil
+		}
+	}
+	return n, addr, NotECT, nil
+}
@@ -0,0 +1,96 @@
+package ecn
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pion/rtcp"
+)
+
+// FormatECN is the transport layer feedback message type of RFC 6679
+// section 5.1.
+const FormatECN = 8
+
+const feedbackLength = 4 + 8 + 20
+
+// Feedback is an RFC 6679 RTCP ECN feedback packet. The counters are
+// cumulative since the start of the stream.
+type Feedback struct {
+	SenderSSRC uint32
+	MediaSSRC  uint32
+
+	ExtendedHighestSequence uint32
+	ECT0                    uint32
+	ECT1                    uint32
+	CE                      uint16
+	NotECT                  uint16
+	Lost                    uint16
+	Duplicates              uint16
+}
+
+func (f *Feedback) Marshal() ([]byte, error) {
+	buf := make([]byte, feedbackLength)
+	h := rtcp.Header{
+		Count:  FormatECN,
+		Type:   rtcp.TypeTransportSpecificFeedback,
+		Length: feedbackLength/4 - 1,
+	}
+	hb, err := h.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	copy(buf, hb)
+	binary.BigEndian.PutUint32(buf[4:], f.SenderSSRC)
+	binary.BigEndian.PutUint32(buf[8:], f.MediaSSRC)
+	binary.BigEndian.PutUint32(buf[12:], f.ExtendedHighestSequence)
+	binary.BigEndian.PutUint32(buf[16:], f.ECT0)
+	binary.BigEndian.PutUint32(buf[20:], f.ECT1)
+	binary.BigEndian.PutUint16(buf[24:], f.CE)
+	binary.BigEndian.PutUint16(buf[26:], f.NotECT)
+	binary.BigEndian.PutUint16(buf[28:], f.Lost)
+	binary.BigEndian.PutUint16(buf[30:], f.Duplicates)
+	return buf, nil
+}
+
+func (f *Feedback) Unmarshal(buf []byte) error {
+	var h rtcp.Header
+	if err := h.Unmarshal(buf); err != nil {
+		return err
+	}
+	if h.Type != rtcp.TypeTransportSpecificFeedback || h.Count != FormatECN {
+		return fmt.Errorf("not an ecn feedback packet")
+	}
+	if len(buf) < feedbackLength {
+		return fmt.Errorf("ecn feedback too short: %d bytes", len(buf))
+	}
+	f.SenderSSRC = binary.BigEndian.Uint32(buf[4:])
+	f.MediaSSRC = binary.BigEndian.Uint32(buf[8:])
+	f.ExtendedHighestSequence = binary.BigEndian.Uint32(buf[12:])
+	f.ECT0 = binary.BigEndian.Uint32(buf[16:])
+	f.ECT1 = binary.BigEndian.Uint32(buf[20:])
+	f.CE = binary.BigEndian.Uint16(buf[24:])
+	f.NotECT = binary.BigEndian.Uint16(buf[26:])
+	f.Lost = binary.BigEndian.Uint16(buf[28:])
+	f.Duplicates = binary.BigEndian.Uint16(buf[30:])
+	return nil
+}
+
+func (f *Feedback) DestinationSSRC() []uint32 {
+	return []uint32{f.MediaSSRC}
+}
+
+// ParseFeedback returns the feedback carried by a packet that pion/rtcp
+// doesn't know, which it unmarshals as a raw packet.
+func ParseFeedback(p rtcp.Packet) (*Feedback, bool) {
+	raw, ok := p.(*rtcp.RawPacket)
+	if !ok {
+		return nil, false
+	}
+	f := &Feedback{}
+	if err := f.Unmarshal(*raw); err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+var _ rtcp.Packet = (*Feedback)(nil)
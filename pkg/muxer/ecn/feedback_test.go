@@ -0,0 +1,61 @@
+package ecn
+
+import (
+	"testing"
+
+	"github.com/pion/rtcp"
+)
+
+func TestFeedbackRoundTrip(t *testing.T) {
+	f := &Feedback{
+		SenderSSRC:              0x01020304,
+		MediaSSRC:               0x05060708,
+		ExtendedHighestSequence: 1<<16 + 10,
+		ECT0:                    1,
+		ECT1:                    70000,
+		CE:                      65535,
+		NotECT:                  3,
+		Lost:                    4,
+		Duplicates:              5,
+	}
+	buf, err := f.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) != feedbackLength {
+		t.Fatalf("got %d bytes, want %d", len(buf), feedbackLength)
+	}
+
+	// pion doesn't know the format so it parses as a raw packet.
+	pkts, err := rtcp.Unmarshal(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkts) != 1 {
+		t.Fatalf("got %d packets, want 1", len(pkts))
+	}
+	got, ok := ParseFeedback(pkts[0])
+	if !ok {
+		t.Fatalf("failed to parse %T", pkts[0])
+	}
+	if *got != *f {
+		t.Errorf("got %+v, want %+v", got, f)
+	}
+}
+
+func TestFeedbackUnmarshalErrors(t *testing.T) {
+	nack, err := (&rtcp.TransportLayerNack{MediaSSRC: 1}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Feedback{}).Unmarshal(nack); err == nil {
+		t.Error("parsed a nack as ecn feedback")
+	}
+	buf, err := (&Feedback{}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Feedback{}).Unmarshal(buf[:feedbackLength-4]); err == nil {
+		t.Error("parsed truncated feedback")
+	}
+}
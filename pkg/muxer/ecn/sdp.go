@@ -0,0 +1,42 @@
+package ecn
+
+import "strings"
+
+const (
+	// CapabilityAttribute declares RFC 6679 ECN for RTP with ECT(1) marking,
+	// initiated through the ICE connectivity checks or the RTP stream itself.
+	CapabilityAttribute = "a=ecn-capable-rtp:ice,rtp ect=1"
+	// FeedbackAttribute asks for RTCP ECN feedback on every payload type.
+	FeedbackAttribute = "a=rtcp-fb:* nack ecn"
+)
+
+// AddCapability declares ECN support at the end of each audio and video
+// section of the session description.
+func AddCapability(sdp string) string {
+	lines := strings.SplitAfter(sdp, "\n")
+	out := make([]string, 0, len(lines)+4)
+	media := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "m=") || (line == "" && media) {
+			if media {
+				out = append(out, CapabilityAttribute+"\r\n", FeedbackAttribute+"\r\n")
+			}
+			media = strings.HasPrefix(line, "m=audio") || strings.HasPrefix(line, "m=video")
+		}
+		out = append(out, line)
+	}
+	if media {
+		out = append(out, CapabilityAttribute+"\r\n", FeedbackAttribute+"\r\n")
+	}
+	return strings.Join(out, "")
+}
+
+// Capable checks whether a session description declares ECN support.
+func Capable(sdp string) bool {
+	for _, line := range strings.Split(sdp, "\n") {
+		if strings.HasPrefix(line, "a=ecn-capable-rtp:") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package ecn
+
+import "testing"
+
+func TestAddCapability(t *testing.T) {
+	sdp := "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=mid:0\r\n" +
+		"m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\n" +
+		"a=mid:1\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=mid:2\r\n"
+	want := "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=mid:0\r\n" +
+		CapabilityAttribute + "\r\n" +
+		FeedbackAttribute + "\r\n" +
+		"m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\n" +
+		"a=mid:1\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=mid:2\r\n" +
+		CapabilityAttribute + "\r\n" +
+		FeedbackAttribute + "\r\n"
+	got := AddCapability(sdp)
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if Capable(sdp) {
+		t.Error("capable without the attribute")
+	}
+	if !Capable(got) {
+		t.Error("not capable with the attribute")
+	}
+}
@@ -0,0 +1,315 @@
+package fec
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	// MimeTypeFlexFEC is the media type for RFC 8627 repair streams.
+	MimeTypeFlexFEC = "video/flexfec"
+
+	// MinimumRatio and MaximumRatio bound the protection ratio, the number of
+	// repair packets per media packet. Groups are kept short so recovery isn't
+	// delayed.
+	MinimumRatio = 1.0 / 15
+	MaximumRatio = 1.0 / 2
+
+	headerSize = 12
+
+	// maximumSpan is the number of sequence numbers the flexible mask can
+	// cover from the base sequence number.
+	maximumSpan = 109
+)
+
+var (
+	errNotRecoverable = errors.New("fec: repair packet can't recover a packet")
+	errShortRepair    = errors.New("fec: repair packet too short")
+)
+
+// Encoder generates RFC 8627 FlexFEC repair packets for a single media stream
+// using a non-interleaved row scheme: every group of media packets is
+// protected by one repair packet. The group size follows the protection ratio.
+//
+// The media stream may have gaps, for example when it's spread over several
+// paths, the flexible mask only covers the packets that were pushed.
+type Encoder struct {
+	sync.Mutex
+
+	ssrc        uint32
+	payloadType uint8
+
+	ratio float64
+	loss  float64
+
+	// the running recovery fields of the current group.
+	protected uint32
+	base      uint16
+	offsets   []uint16
+	first     uint16
+	length    uint16
+	ts        uint32
+	payload   []byte
+	timestamp uint32
+
+	buf      []byte
+	sequence uint16
+
+	// the repair packet is reused, it's valid until the next Push.
+	out     rtp.Packet
+	outCSRC [1]uint32
+	outBuf  []byte
+}
+
+// NewEncoder creates an encoder whose repair packets are sent with the given
+// ssrc and payload type.
+func NewEncoder(ssrc uint32, payloadType uint8) *Encoder {
+	return &Encoder{ssrc: ssrc, payloadType: payloadType, ratio: MinimumRatio}
+}
+
+// SSRC returns the ssrc of the repair stream.
+func (e *Encoder) SSRC() uint32 {
+	return e.ssrc
+}
+
+// SetLoss updates the measured loss fraction in [0, 1]. The protection ratio
+// is set to twice the smoothed loss so a single loss per group is expected to
+// be recoverable.
+func (e *Encoder) SetLoss(loss float64) {
+	e.Lock()
+	defer e.Unlock()
+
+	e.loss = 0.8*e.loss + 0.2*loss
+	ratio := 2 * e.loss
+	if ratio < MinimumRatio {
+		ratio = MinimumRatio
+	}
+	if ratio > MaximumRatio {
+		ratio = MaximumRatio
+	}
+	e.ratio = ratio
+}
+
+// Ratio returns the current protection ratio.
+func (e *Encoder) Ratio() float64 {
+	e.Lock()
+	defer e.Unlock()
+
+	return e.ratio
+}
+
+// Push adds a media packet to the current group and returns a repair packet
+// once the group is complete, otherwise nil. The packet isn't retained and the
+// repair packet is only valid until the next call.
+func (e *Encoder) Push(pkt *rtp.Packet) (*rtp.Packet, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	return e.push(pkt)
+}
+
+// push is Push, it must be called with the lock held.
+func (e *Encoder) push(pkt *rtp.Packet) (*rtp.Packet, error) {
+	var repair *rtp.Packet
+	if n := len(e.offsets); n > 0 {
+		if pkt.SSRC != e.protected {
+			e.offsets = e.offsets[:0]
+		} else if d := pkt.SequenceNumber - (e.base + e.offsets[n-1]); d == 0 || d >= 0x8000 {
+			// retransmissions of protected packets aren't protected again.
+			return nil, nil
+		} else if pkt.SequenceNumber-e.base >= maximumSpan {
+			// the mask can't reach this packet, close the group early.
+			repair = e.repair()
+		}
+	}
+
+	size := pkt.MarshalSize()
+	if cap(e.buf) < size {
+		e.buf = make([]byte, size)
+	}
+	buf := e.buf[:size]
+	if _, err := pkt.MarshalTo(buf); err != nil {
+		return nil, err
+	}
+	if len(e.offsets) == 0 {
+		e.protected = pkt.SSRC
+		e.base = pkt.SequenceNumber
+		e.first, e.length, e.ts = 0, 0, 0
+		e.payload = e.payload[:0]
+	}
+	e.offsets = append(e.offsets, pkt.SequenceNumber-e.base)
+	e.first ^= binary.BigEndian.Uint16(buf[0:2])
+	e.length ^= uint16(size - headerSize)
+	e.ts ^= binary.BigEndian.Uint32(buf[4:8])
+	e.payload = xor(e.payload, buf[headerSize:])
+	e.timestamp = pkt.Timestamp
+
+	if repair == nil && float64(len(e.offsets))*e.ratio >= 1 {
+		repair = e.repair()
+	}
+	return repair, nil
+}
+
+// repair builds the repair packet protecting the current group and resets it.
+func (e *Encoder) repair() *rtp.Packet {
+	payload := append(e.outBuf[:0], make([]byte, 10)...)
+	// R=0, F=0 selects the flexible mask. The version bits carry no recovery
+	// information so they're cleared.
+	binary.BigEndian.PutUint16(payload[0:2], e.first&0x3fff)
+	binary.BigEndian.PutUint16(payload[2:4], e.length)
+	binary.BigEndian.PutUint32(payload[4:8], e.ts)
+	binary.BigEndian.PutUint16(payload[8:10], e.base)
+	payload = append(appendMask(payload, e.offsets), e.payload...)
+	e.outBuf = payload
+	e.offsets = e.offsets[:0]
+
+	e.sequence++
+	// RFC 8627 section 4.2.1: the protected ssrcs are in the csrc list.
+	e.outCSRC[0] = e.protected
+	e.out = rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    e.payloadType,
+			SequenceNumber: e.sequence,
+			Timestamp:      e.timestamp,
+			SSRC:           e.ssrc,
+			CSRC:           e.outCSRC[:],
+		},
+		Payload: payload,
+	}
+	return &e.out
+}
+
+// Recover reconstructs the one packet protected by repair that is missing from
+// packets, which holds the received packets of the protected stream.
+func Recover(repair *rtp.Packet, packets []*rtp.Packet) (*rtp.Packet, error) {
+	if len(repair.CSRC) != 1 || len(repair.Payload) < 12 {
+		return nil, errShortRepair
+	}
+	p := repair.Payload
+	base := binary.BigEndian.Uint16(p[8:10])
+	offsets, n, err := unmask(p[10:])
+	if err != nil {
+		return nil, err
+	}
+	received := make(map[uint16]*rtp.Packet, len(packets))
+	for _, pkt := range packets {
+		if pkt.SSRC == repair.CSRC[0] {
+			received[pkt.SequenceNumber] = pkt
+		}
+	}
+
+	first := binary.BigEndian.Uint16(p[0:2])
+	length := binary.BigEndian.Uint16(p[2:4])
+	ts := binary.BigEndian.Uint32(p[4:8])
+	payload := append([]byte{}, p[10+n:]...)
+	missing := -1
+	for _, offset := range offsets {
+		pkt, ok := received[base+offset]
+		if !ok {
+			if missing >= 0 {
+				return nil, errNotRecoverable
+			}
+			missing = int(offset)
+			continue
+		}
+		buf, err := pkt.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		first ^= binary.BigEndian.Uint16(buf[0:2])
+		length ^= uint16(len(buf) - headerSize)
+		ts ^= binary.BigEndian.Uint32(buf[4:8])
+		payload = xor(payload, buf[headerSize:])
+	}
+	if missing < 0 || int(length) > len(payload) {
+		return nil, errNotRecoverable
+	}
+
+	buf := make([]byte, headerSize+int(length))
+	binary.BigEndian.PutUint16(buf[0:2], first&0x3fff|0x8000)
+	binary.BigEndian.PutUint16(buf[2:4], base+uint16(missing))
+	binary.BigEndian.PutUint32(buf[4:8], ts)
+	binary.BigEndian.PutUint32(buf[8:12], repair.CSRC[0])
+	copy(buf[headerSize:], payload)
+	pkt := &rtp.Packet{}
+	if err := pkt.Unmarshal(buf); err != nil {
+		return nil, err
+	}
+	return pkt, nil
+}
+
+// xor xors b into a, growing a with zeros if b is longer.
+func xor(a, b []byte) []byte {
+	if n := len(b); n > len(a) {
+		a = append(a, make([]byte, n-len(a))...)
+	}
+	for i, v := range b {
+		a[i] ^= v
+	}
+	return a
+}
+
+// maskBit returns the position of offset in the flexible mask, which skips the
+// k bits at the start of the first two words.
+func maskBit(offset uint16) int {
+	switch {
+	case offset < 15:
+		return 1 + int(offset)
+	case offset < 46:
+		return 17 + int(offset-15)
+	default:
+		return 48 + int(offset-46)
+	}
+}
+
+// appendMask appends the shortest flexible mask covering the given ascending
+// offsets from the base sequence number to dst.
+func appendMask(dst []byte, offsets []uint16) []byte {
+	n := len(dst)
+	switch last := offsets[len(offsets)-1]; {
+	case last < 15:
+		dst = append(dst, make([]byte, 2)...)
+		dst[n] = 0x80
+	case last < 46:
+		dst = append(dst, make([]byte, 6)...)
+		dst[n+2] = 0x80
+	default:
+		dst = append(dst, make([]byte, 14)...)
+	}
+	m := dst[n:]
+	for _, offset := range offsets {
+		bit := maskBit(offset)
+		m[bit/8] |= 0x80 >> (bit % 8)
+	}
+	return dst
+}
+
+// unmask parses a flexible mask, returning the offsets it covers and its
+// length in bytes.
+func unmask(m []byte) ([]uint16, int, error) {
+	n := 14
+	switch {
+	case len(m) >= 2 && m[0]&0x80 != 0:
+		n = 2
+	case len(m) >= 6 && m[2]&0x80 != 0:
+		n = 6
+	}
+	if len(m) < n {
+		return nil, 0, errShortRepair
+	}
+	var offsets []uint16
+	for offset := uint16(0); offset < maximumSpan; offset++ {
+		bit := maskBit(offset)
+		if bit/8 >= n {
+			break
+		}
+		if m[bit/8]&(0x80>>(bit%8)) != 0 {
+			offsets = append(offsets, offset)
+		}
+	}
+	return offsets, n, nil
+}
@@ -0,0 +1,172 @@
+package fec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/muxable/rtpmagic/pkg/muxer/multipath"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+const (
+	mediaSSRC  = 0x11223344
+	repairSSRC = 0x55667788
+)
+
+// send writes packets with the given sequence numbers through the fec
+// interceptor followed by a header rewriting interceptor and returns the media
+// that reaches the wire and the repair packets handed to the repair writer.
+func send(t *testing.T, ratio float64, seqs []uint16) (media, repair []*rtp.Packet) {
+	factory := NewInterceptorFactory(func(ssrc uint32, pkt *rtp.Packet) error {
+		if ssrc != mediaSSRC {
+			t.Errorf("got repair for ssrc %x, want %x", ssrc, mediaSSRC)
+		}
+		// the packet is reused so it's copied.
+		buf, err := pkt.Marshal()
+		if err != nil {
+			return err
+		}
+		copied := &rtp.Packet{}
+		if err := copied.Unmarshal(buf); err != nil {
+			return err
+		}
+		repair = append(repair, copied)
+		return nil
+	})
+	encoder := factory.Protect(mediaSSRC, repairSSRC, 118)
+	encoder.ratio = ratio
+
+	f, err := factory.NewInterceptor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mf, err := multipath.NewHeaderExtensionInterceptor(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mf.NewInterceptor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := interceptor.NewChain([]interceptor.Interceptor{f, m})
+	info := &interceptor.StreamInfo{
+		SSRC:                mediaSSRC,
+		RTPHeaderExtensions: []interceptor.RTPHeaderExtension{{URI: multipath.URI, ID: 5}},
+	}
+	writer := chain.BindLocalStream(info, interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+		buf, err := (&rtp.Packet{Header: *header, Payload: payload}).Marshal()
+		if err != nil {
+			return 0, err
+		}
+		pkt := &rtp.Packet{}
+		if err := pkt.Unmarshal(buf); err != nil {
+			return 0, err
+		}
+		if pkt.SSRC == repairSSRC {
+			t.Error("repair packet written on the media path")
+		}
+		media = append(media, pkt)
+		return len(buf), nil
+	}))
+
+	for i, seq := range seqs {
+		payload := bytes.Repeat([]byte{byte(i)}, 100+7*i)
+		header := &rtp.Header{
+			Version:        2,
+			Marker:         i%3 == 0,
+			PayloadType:    96,
+			SequenceNumber: seq,
+			Timestamp:      uint32(3000 * i),
+			SSRC:           mediaSSRC,
+		}
+		if _, err := writer.Write(header, payload, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return media, repair
+}
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		name string
+		seqs []uint16
+	}{
+		{"Consecutive", []uint16{10, 11, 12, 13}},
+		{"Gaps", []uint16{65530, 65533, 2, 20}},
+		{"LongMask", []uint16{100, 120, 150, 200}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			media, repair := send(t, 1.0/4, test.seqs)
+			if len(repair) != 1 {
+				t.Fatalf("got %d repair packets, want 1", len(repair))
+			}
+			if got := repair[0].CSRC; len(got) != 1 || got[0] != mediaSSRC {
+				t.Fatalf("got csrc %v, want the protected ssrc", got)
+			}
+			for drop := range media {
+				received := append(append([]*rtp.Packet{}, media[:drop]...), media[drop+1:]...)
+				recovered, err := Recover(repair[0], received)
+				if err != nil {
+					t.Fatalf("drop %d: %v", drop, err)
+				}
+				want, err := media[drop].Marshal()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := recovered.Marshal()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("drop %d: recovered %v, want %v", drop, recovered, media[drop])
+				}
+				if recovered.GetExtension(5) == nil {
+					t.Errorf("drop %d: recovered packet is missing the rewritten extension", drop)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoverTwoLosses(t *testing.T) {
+	media, repair := send(t, 1.0/4, []uint16{1, 2, 3, 4})
+	if _, err := Recover(repair[0], media[:2]); err == nil {
+		t.Error("expected two losses to be unrecoverable")
+	}
+}
+
+func TestPushSpan(t *testing.T) {
+	// the second packet is out of the mask's reach so the first is repaired
+	// on its own.
+	_, repair := send(t, 1.0/4, []uint16{0, maximumSpan})
+	if len(repair) != 1 {
+		t.Fatalf("got %d repair packets, want 1", len(repair))
+	}
+	offsets, _, err := unmask(repair[0].Payload[10:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != 1 || offsets[0] != 0 {
+		t.Errorf("got offsets %v, want [0]", offsets)
+	}
+}
+
+func BenchmarkEncoderPush(b *testing.B) {
+	e := NewEncoder(repairSSRC, 118)
+	e.ratio = 1.0 / 4
+	pkt := &rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 96, SSRC: mediaSSRC},
+		Payload: make([]byte, 1200),
+	}
+	b.SetBytes(int64(len(pkt.Payload)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pkt.SequenceNumber = uint16(i)
+		pkt.Timestamp = uint32(i / 4 * 3000)
+		if _, err := e.Push(pkt); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
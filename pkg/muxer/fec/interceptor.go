@@ -0,0 +1,94 @@
+package fec
+
+import (
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+// RepairWriter sends a repair packet protecting the stream ssrc. The packet is
+// only valid for the duration of the call.
+type RepairWriter func(ssrc uint32, repair *rtp.Packet) error
+
+// InterceptorFactory generates repair packets for the media streams of a peer
+// connection that it's told to protect. It must be registered before any
+// interceptor that rewrites the header so that the repair packets cover the
+// packets as they're sent.
+type InterceptorFactory struct {
+	sync.Mutex
+
+	encoders map[uint32]*Encoder
+	write    RepairWriter
+}
+
+// Interceptor hands a repair packet to the factory's RepairWriter after the
+// media packet that completes a group, so that it can be sent on another path
+// than the media it protects.
+type Interceptor struct {
+	interceptor.NoOp
+
+	factory *InterceptorFactory
+}
+
+// NewInterceptorFactory creates a factory whose repair packets are sent with
+// write.
+func NewInterceptorFactory(write RepairWriter) *InterceptorFactory {
+	return &InterceptorFactory{encoders: make(map[uint32]*Encoder), write: write}
+}
+
+// NewInterceptor constructs a new Interceptor.
+func (f *InterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &Interceptor{factory: f}, nil
+}
+
+// Protect starts protecting the stream ssrc with repair packets sent on
+// repairSSRC.
+func (f *InterceptorFactory) Protect(ssrc, repairSSRC uint32, payloadType uint8) *Encoder {
+	f.Lock()
+	defer f.Unlock()
+
+	e := NewEncoder(repairSSRC, payloadType)
+	f.encoders[ssrc] = e
+	return e
+}
+
+// Unprotect stops protecting the stream ssrc.
+func (f *InterceptorFactory) Unprotect(ssrc uint32) {
+	f.Lock()
+	defer f.Unlock()
+
+	delete(f.encoders, ssrc)
+}
+
+func (f *InterceptorFactory) encoder(ssrc uint32) *Encoder {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.encoders[ssrc]
+}
+
+// BindLocalStream returns a writer that feeds the protected streams to their
+// encoders.
+func (i *Interceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		n, err := writer.Write(header, payload, attributes)
+		if err != nil {
+			return n, err
+		}
+		// other streams, such as retransmissions, may share the writer.
+		e := i.factory.encoder(header.SSRC)
+		if e == nil {
+			return n, nil
+		}
+		// the lock is held until the reused repair packet is written.
+		e.Lock()
+		defer e.Unlock()
+
+		repair, err := e.push(&rtp.Packet{Header: *header, Payload: payload})
+		if err != nil || repair == nil {
+			return n, err
+		}
+		return n, i.factory.write(header.SSRC, repair)
+	})
+}
@@ -1,21 +1,78 @@
 package ffmpeg
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muxable/rtpmagic/pkg/muxer/abscapture"
 	"github.com/muxable/rtpmagic/pkg/muxer/balancer"
 	"github.com/muxable/sfu/pkg/av"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog/log"
 )
 
 type Encoder struct {
+	sync.Mutex
+
+	decoders []*av.DecodeContext
 	encoders []*av.EncodeContext
-	device   *av.DemuxContext
+	configs  []*av.EncoderConfiguration
+	device   *av.DeviceContext
+
+	sink   *BalancerSink
+	ladder *Ladder
+
+	keyframes *keyframeLimiter
+}
+
+// rescaler is implemented by decoders whose filter graph can be replaced while
+// running.
+type rescaler interface {
+	SetFilterGraph(description string) error
+}
+
+// startTimer is implemented by devices that report the pts and wall clock
+// time of their first frame.
+type startTimer interface {
+	StartTime() time.Duration
+	StartTimeRealtime() time.Time
+}
+
+// baseTimestamper is implemented by muxers that report each stream's RTP
+// timestamp at pts zero.
+type baseTimestamper interface {
+	BaseTimestamps() ([]uint32, error)
 }
 
+var errRescaleUnsupported = errors.New("ffmpeg: the av decoders can't rescale video")
+
+// CodecNegotiationTimeout is how long encoder selection waits for the remote
+// to answer with the codecs it supports.
+var CodecNegotiationTimeout = 5 * time.Second
+
+// NewAudioVideoEncoder encodes the device's streams for the groups. The audio
+// and video configurations are candidates in order of preference, the one
+// used is the first in the remote's order of preference that every group
+// negotiated.
 func NewAudioVideoEncoder(
-	device *av.DemuxContext,
-	audioConfig, videoConfig *av.EncoderConfiguration,
-	mpcg *balancer.ManagedPeerConnectionGroup,
+	device *av.DeviceContext,
+	audioConfigs, videoConfigs []*av.EncoderConfiguration,
+	mpcgs []*balancer.ManagedPeerConnectionGroup,
 	cname string) (*Encoder, error) {
 
+	audioConfig, err := selectEncoder(webrtc.RTPCodecTypeAudio, audioConfigs, mpcgs)
+	if err != nil {
+		return nil, err
+	}
+	videoConfig, err := selectEncoder(webrtc.RTPCodecTypeVideo, videoConfigs, mpcgs)
+	if err != nil {
+		return nil, err
+	}
+
 	decoders, err := device.NewDecoders()
 	if err != nil {
 		return nil, err
@@ -36,10 +93,13 @@ func NewAudioVideoEncoder(
 	if err != nil {
 		return nil, err
 	}
-	balancerSink, err := NewBalancerSink(params, cname, mpcg)
+	balancerSink, err := NewBalancerSink(params, cname, mpcgs...)
 	if err != nil {
 		return nil, err
 	}
+	if err := setCaptureClocks(balancerSink, device, mux, params); err != nil {
+		return nil, err
+	}
 	// testSink, err := NewTestSink("100.105.100.81:5000")
 	// if err != nil {
 	// 	return nil, err
@@ -48,13 +108,141 @@ func NewAudioVideoEncoder(
 	// wire them together
 	mux.Sink = balancerSink
 
-	return &Encoder{
+	e := &Encoder{
+		decoders: decoders,
 		encoders: encoders,
+		configs:  configs,
 		device:   device,
-	}, nil
+		sink:     balancerSink,
+	}
+	e.keyframes = newKeyframeLimiter(KeyframeRequestInterval, e.forceKeyframe)
+	balancerSink.Handle(rtcp.TypePayloadSpecificFeedback, KeyframeRequestHandler(e.RequestKeyframe))
+
+	return e, nil
+}
+
+// setCaptureClocks stamps capture times derived from the pts, the muxer's
+// timestamps follow the demuxer's pts, which are anchored to the wall clock at
+// the device's first frame. Without pts times from av no capture times are
+// stamped.
+func setCaptureClocks(sink *BalancerSink, device *av.DeviceContext, mux *av.RTPMuxContext, params []*webrtc.RTPCodecParameters) error {
+	st, ok := interface{}(device).(startTimer)
+	bt, ok2 := interface{}(mux).(baseTimestamper)
+	if !ok || !ok2 {
+		log.Warn().Msg("av doesn't report pts times, capture times aren't stamped")
+		return nil
+	}
+	bases, err := bt.BaseTimestamps()
+	if err != nil {
+		return err
+	}
+	for i, p := range params {
+		if p == nil || p.ClockRate == 0 || i >= len(bases) {
+			continue
+		}
+		clock := abscapture.NewCaptureClock(p.ClockRate, bases[i], st.StartTime(), st.StartTimeRealtime())
+		sink.SetCaptureClock(uint8(p.PayloadType), clock)
+	}
+	return nil
+}
+
+// selectEncoder picks the configuration for the codec the remotes prefer. If
+// no group negotiates in time the first configuration is used.
+func selectEncoder(kind webrtc.RTPCodecType, configs []*av.EncoderConfiguration, mpcgs []*balancer.ManagedPeerConnectionGroup) (*av.EncoderConfiguration, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no %s encoders configured", kind)
+	}
+	// the groups negotiate independently so they're waited on together.
+	answers := make([][]webrtc.RTPCodecParameters, len(mpcgs))
+	var wg sync.WaitGroup
+	for i, mpcg := range mpcgs {
+		wg.Add(1)
+		go func(i int, mpcg *balancer.ManagedPeerConnectionGroup) {
+			defer wg.Done()
+			answers[i] = mpcg.RemoteCodecs(kind, CodecNegotiationTimeout)
+		}(i, mpcg)
+	}
+	wg.Wait()
+	var remotes [][]webrtc.RTPCodecParameters
+	for _, codecs := range answers {
+		if codecs != nil {
+			remotes = append(remotes, codecs)
+		}
+	}
+	if len(remotes) == 0 {
+		log.Warn().Str("Kind", kind.String()).Str("Codec", configs[0].Codec.MimeType).Msg("remote codecs unknown, using the preferred encoder")
+		return configs[0], nil
+	}
+	supported := func(codecs []webrtc.RTPCodecParameters, mimeType string) bool {
+		for _, c := range codecs {
+			if strings.EqualFold(c.MimeType, mimeType) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, codec := range remotes[0] {
+		for _, config := range configs {
+			if !strings.EqualFold(config.Codec.MimeType, codec.MimeType) {
+				continue
+			}
+			all := true
+			for _, remote := range remotes[1:] {
+				all = all && supported(remote, codec.MimeType)
+			}
+			if all {
+				log.Info().Str("Kind", kind.String()).Str("Codec", codec.MimeType).Str("Encoder", config.Name).Msg("selected encoder")
+				return config, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("remote supports none of the configured %s codecs", kind)
+}
+
+// RequestKeyframe asks the video encoders to emit an IDR frame. Requests are
+// rate limited to one per KeyframeRequestInterval.
+func (e *Encoder) RequestKeyframe() {
+	e.keyframes.Request()
+}
+
+func (e *Encoder) forceKeyframe() {
+	e.Lock()
+	defer e.Unlock()
+
+	for i, encoder := range e.encoders {
+		if strings.HasPrefix(e.configs[i].Codec.MimeType, "video/") {
+			encoder.RequestKeyframe()
+		}
+	}
+}
+
+// Sink returns the balancer sink, which can be used to register RTCP handlers.
+func (e *Encoder) Sink() *BalancerSink {
+	return e.sink
+}
+
+// SetLadder enables resolution and frame rate adaptation. The initial rung is
+// applied immediately.
+func (e *Encoder) SetLadder(ladder *Ladder) error {
+	e.Lock()
+	defer e.Unlock()
+
+	e.ladder = ladder
+	return e.applyRung(ladder.Current())
 }
 
 func (e *Encoder) SetBitrate(bitrate int64) error {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.ladder != nil {
+		if rung, changed := e.ladder.Update(bitrate); changed {
+			log.Info().Str("Rung", rung.String()).Int64("Bitrate", bitrate).Msg("switching quality rung")
+			if err := e.applyRung(rung); err != nil {
+				return err
+			}
+		}
+	}
 	effectiveBitrate := bitrate / int64(len(e.encoders))
 	for _, encoder := range e.encoders {
 		encoder.SetBitrate(effectiveBitrate)
@@ -62,6 +250,26 @@ func (e *Encoder) SetBitrate(bitrate int64) error {
 	return nil
 }
 
+// applyRung rescales the video to the given rung in the filter graph between
+// the decoders and encoders, and forces a keyframe so the receiver can decode
+// the new resolution immediately.
+func (e *Encoder) applyRung(rung Rung) error {
+	for i, encoder := range e.encoders {
+		if !strings.HasPrefix(e.configs[i].Codec.MimeType, "video/") {
+			continue
+		}
+		r, ok := interface{}(e.decoders[i]).(rescaler)
+		if !ok {
+			return errRescaleUnsupported
+		}
+		if err := r.SetFilterGraph(rung.FilterGraph()); err != nil {
+			return err
+		}
+		encoder.RequestKeyframe()
+	}
+	return nil
+}
+
 func (e *Encoder) Run() error {
 	return e.device.Run()
 }
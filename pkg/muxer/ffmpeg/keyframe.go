@@ -0,0 +1,50 @@
+package ffmpeg
+
+import (
+	"sync"
+	"time"
+)
+
+// KeyframeRequestInterval is the minimum time between two forced keyframes.
+var KeyframeRequestInterval = 500 * time.Millisecond
+
+// keyframeLimiter coalesces keyframe requests. A PLI from every path for the
+// same loss event results in a single keyframe, and requests that arrive while
+// rate limited are deferred to the end of the interval rather than dropped.
+type keyframeLimiter struct {
+	sync.Mutex
+
+	interval time.Duration
+	last     time.Time
+	pending  bool
+	force    func()
+}
+
+func newKeyframeLimiter(interval time.Duration, force func()) *keyframeLimiter {
+	return &keyframeLimiter{interval: interval, force: force}
+}
+
+func (k *keyframeLimiter) Request() {
+	k.Lock()
+	defer k.Unlock()
+
+	if k.pending {
+		return
+	}
+	if wait := k.interval - time.Since(k.last); wait > 0 {
+		k.pending = true
+		time.AfterFunc(wait, k.fire)
+		return
+	}
+	k.last = time.Now()
+	go k.force()
+}
+
+func (k *keyframeLimiter) fire() {
+	k.Lock()
+	k.pending = false
+	k.last = time.Now()
+	k.Unlock()
+
+	k.force()
+}
@@ -0,0 +1,140 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rung is a single step of the quality ladder. Bitrate is the minimum
+// estimated bitrate at which the rung is considered watchable.
+type Rung struct {
+	Width, Height        int
+	FrameRateNumerator   int
+	FrameRateDenominator int
+	Bitrate              int64
+}
+
+func (r Rung) String() string {
+	return fmt.Sprintf("%dx%d@%d/%d:%d", r.Width, r.Height, r.FrameRateNumerator, r.FrameRateDenominator, r.Bitrate)
+}
+
+// FilterGraph returns the libavfilter graph that scales and resamples video
+// to the rung.
+func (r Rung) FilterGraph() string {
+	return fmt.Sprintf("scale=w=%d:h=%d,fps=fps=%d/%d", r.Width, r.Height, r.FrameRateNumerator, r.FrameRateDenominator)
+}
+
+// Ladder selects a rung for a given bitrate estimate. Downswitches happen as
+// soon as the estimate drops below the current rung, upswitches only after the
+// estimate has comfortably exceeded the next rung for a hold period so that an
+// oscillating estimate doesn't flap the resolution.
+type Ladder struct {
+	rungs   []Rung
+	current int
+
+	// UpswitchMargin is the factor the estimate must exceed the next rung by.
+	UpswitchMargin float64
+	// UpswitchHold is how long the estimate must stay above the next rung.
+	UpswitchHold time.Duration
+
+	upSince time.Time
+	now     func() time.Time
+}
+
+func NewLadder(rungs []Rung) (*Ladder, error) {
+	if len(rungs) == 0 {
+		return nil, fmt.Errorf("ladder must have at least one rung")
+	}
+	sorted := make([]Rung, len(rungs))
+	copy(sorted, rungs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Bitrate < sorted[j].Bitrate })
+	return &Ladder{
+		rungs:          sorted,
+		UpswitchMargin: 1.25,
+		UpswitchHold:   5 * time.Second,
+		now:            time.Now,
+	}, nil
+}
+
+// ParseLadder parses a comma separated list of rungs of the form
+// WIDTHxHEIGHT@NUM[/DEN]:BITRATE, for example "1280x720@30:2000000".
+func ParseLadder(s string) (*Ladder, error) {
+	var rungs []Rung
+	for _, token := range strings.Split(s, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		var r Rung
+		size, rest, ok := cut(token, "@")
+		if !ok {
+			return nil, fmt.Errorf("invalid rung %q", token)
+		}
+		rate, bitrate, ok := cut(rest, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid rung %q", token)
+		}
+		if _, err := fmt.Sscanf(size, "%dx%d", &r.Width, &r.Height); err != nil {
+			return nil, fmt.Errorf("invalid rung size %q: %w", size, err)
+		}
+		num, den, ok := cut(rate, "/")
+		if !ok {
+			den = "1"
+		}
+		var err error
+		if r.FrameRateNumerator, err = strconv.Atoi(num); err != nil {
+			return nil, fmt.Errorf("invalid rung frame rate %q: %w", rate, err)
+		}
+		if r.FrameRateDenominator, err = strconv.Atoi(den); err != nil {
+			return nil, fmt.Errorf("invalid rung frame rate %q: %w", rate, err)
+		}
+		if r.Bitrate, err = strconv.ParseInt(bitrate, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid rung bitrate %q: %w", bitrate, err)
+		}
+		rungs = append(rungs, r)
+	}
+	return NewLadder(rungs)
+}
+
+func cut(s, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// Current returns the currently selected rung.
+func (l *Ladder) Current() Rung {
+	return l.rungs[l.current]
+}
+
+// Update feeds a new bitrate estimate into the ladder and returns the selected
+// rung and whether it changed.
+func (l *Ladder) Update(bitrate int64) (Rung, bool) {
+	prev := l.current
+
+	// step down until the estimate fits, the lowest rung is always allowed.
+	for l.current > 0 && bitrate < l.rungs[l.current].Bitrate {
+		l.current--
+	}
+	if l.current != prev {
+		l.upSince = time.Time{}
+		return l.rungs[l.current], true
+	}
+
+	if l.current+1 < len(l.rungs) && float64(bitrate) >= float64(l.rungs[l.current+1].Bitrate)*l.UpswitchMargin {
+		if l.upSince.IsZero() {
+			l.upSince = l.now()
+		} else if l.now().Sub(l.upSince) >= l.UpswitchHold {
+			l.current++
+			l.upSince = time.Time{}
+			return l.rungs[l.current], true
+		}
+	} else {
+		l.upSince = time.Time{}
+	}
+	return l.rungs[l.current], false
+}
@@ -0,0 +1,121 @@
+package ffmpeg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLadder(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []Rung
+		wantErr bool
+	}{
+		{
+			in:   "1280x720@30:2000000",
+			want: []Rung{{1280, 720, 30, 1, 2000000}},
+		},
+		{
+			in:   "1920x1080@30000/1001:4000000, 640x360@15:500000",
+			want: []Rung{{640, 360, 15, 1, 500000}, {1920, 1080, 30000, 1001, 4000000}},
+		},
+		{in: "", wantErr: true},
+		{in: "1280x720:2000000", wantErr: true},
+		{in: "1280x720@30", wantErr: true},
+		{in: "1280@30:2000000", wantErr: true},
+		{in: "1280x720@thirty:2000000", wantErr: true},
+		{in: "1280x720@30:fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		l, err := ParseLadder(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseLadder(%q) = %v, want error", tt.in, l.rungs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseLadder(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if len(l.rungs) != len(tt.want) {
+			t.Errorf("ParseLadder(%q) = %v, want %v", tt.in, l.rungs, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if l.rungs[i] != tt.want[i] {
+				t.Errorf("ParseLadder(%q) = %v, want %v", tt.in, l.rungs, tt.want)
+			}
+		}
+	}
+}
+
+func TestLadderUpdate(t *testing.T) {
+	type step struct {
+		after   time.Duration
+		bitrate int64
+		want    int64
+		changed bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "down switches immediately",
+			steps: []step{
+				{0, 400000, 500000, false},
+				{0, 5000000, 500000, false},
+				{6 * time.Second, 5000000, 1000000, true},
+				{0, 600000, 500000, true},
+			},
+		},
+		{
+			name: "down switches past several rungs",
+			steps: []step{
+				{0, 3000000, 500000, false},
+				{6 * time.Second, 3000000, 1000000, true},
+				{0, 3000000, 1000000, false},
+				{6 * time.Second, 3000000, 2000000, true},
+				{0, 100000, 500000, true},
+			},
+		},
+		{
+			name: "up switch needs the margin",
+			steps: []step{
+				{0, 1200000, 500000, false},
+				{10 * time.Second, 1200000, 500000, false},
+				{0, 1250000, 500000, false},
+				{5 * time.Second, 1250000, 1000000, true},
+			},
+		},
+		{
+			name: "dip resets the hold",
+			steps: []step{
+				{0, 1300000, 500000, false},
+				{4 * time.Second, 1300000, 500000, false},
+				{0, 900000, 500000, false},
+				{0, 1300000, 500000, false},
+				{4 * time.Second, 1300000, 500000, false},
+				{time.Second, 1300000, 1000000, true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := ParseLadder("1280x720@30:2000000,854x480@30:1000000,640x360@15:500000")
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Unix(0, 0)
+			l.now = func() time.Time { return now }
+			for i, s := range tt.steps {
+				now = now.Add(s.after)
+				rung, changed := l.Update(s.bitrate)
+				if rung.Bitrate != s.want || changed != s.changed {
+					t.Fatalf("step %d: Update(%d) = %d, %v, want %d, %v", i, s.bitrate, rung.Bitrate, changed, s.want, s.changed)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package ffmpeg
+
+import (
+	"github.com/muxable/rtpmagic/pkg/muxer/balancer"
+	"github.com/pion/rtcp"
+)
+
+// RTCPHandler handles a single RTCP packet received for a source.
+type RTCPHandler interface {
+	HandleRTCP(source *balancer.ManagedSource, pkt rtcp.Packet)
+}
+
+// RTCPHandlerFunc adapts a function to an RTCPHandler.
+type RTCPHandlerFunc func(source *balancer.ManagedSource, pkt rtcp.Packet)
+
+func (f RTCPHandlerFunc) HandleRTCP(source *balancer.ManagedSource, pkt rtcp.Packet) {
+	f(source, pkt)
+}
+
+// RTCPMiddleware wraps a handler, for example to observe or filter packets
+// before they are dispatched by type.
+type RTCPMiddleware func(next RTCPHandler) RTCPHandler
+
+// KeyframeRequestHandler calls request for every PLI and FIR.
+func KeyframeRequestHandler(request func()) RTCPHandler {
+	return RTCPHandlerFunc(func(_ *balancer.ManagedSource, pkt rtcp.Packet) {
+		switch pkt.(type) {
+		case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+			request()
+		}
+	})
+}
+
+// packetType returns the RTCP packet type of pkt.
+func packetType(pkt rtcp.Packet) rtcp.PacketType {
+	switch p := pkt.(type) {
+	case interface{ Header() rtcp.Header }:
+		return p.Header().Type
+	case *rtcp.TransportLayerCC:
+		return rtcp.TypeTransportSpecificFeedback
+	case *rtcp.ExtendedReport:
+		return rtcp.TypeExtendedReport
+	}
+	buf, err := pkt.Marshal()
+	if err != nil || len(buf) < 2 {
+		return 0
+	}
+	return rtcp.PacketType(buf[1])
+}
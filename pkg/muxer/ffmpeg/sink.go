@@ -3,9 +3,12 @@ package ffmpeg
 import (
 	"fmt"
 	"net"
+	"sync"
 
 	"github.com/google/uuid"
+	"github.com/muxable/rtpmagic/pkg/muxer/abscapture"
 	"github.com/muxable/rtpmagic/pkg/muxer/balancer"
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/rtpio/pkg/rtpio"
 	"github.com/pion/webrtc/v3"
@@ -43,43 +46,151 @@ func (s *TestSink) Close() error {
 }
 
 type BalancerSink struct {
-	sources map[uint8]*balancer.ManagedSource
-	mpcg   *balancer.ManagedPeerConnectionGroup
+	sync.RWMutex
+
+	// sources holds one source per group for each payload type, in the same
+	// order as mpcgs.
+	sources map[uint8][]*balancer.ManagedSource
+	mpcgs   []*balancer.ManagedPeerConnectionGroup
+
+	// clocks map each payload type's timestamps to capture times, frames
+	// holds the timestamp of the last frame stamped.
+	clocks map[uint8]*abscapture.CaptureClock
+	frames map[uint8]uint32
+
+	handlers   map[rtcp.PacketType][]RTCPHandler
+	middleware []RTCPMiddleware
 }
 
-func NewBalancerSink(params []*webrtc.RTPCodecParameters, sid string, mpcg *balancer.ManagedPeerConnectionGroup) (*BalancerSink, error) {
+// NewBalancerSink publishes the given codecs to every group, which allows
+// publishing to more than one sfu at once.
+func NewBalancerSink(params []*webrtc.RTPCodecParameters, sid string, mpcgs ...*balancer.ManagedPeerConnectionGroup) (*BalancerSink, error) {
 	// create local tracks.
-	sources := make(map[uint8]*balancer.ManagedSource)
+	s := &BalancerSink{
+		sources:  make(map[uint8][]*balancer.ManagedSource),
+		mpcgs:    mpcgs,
+		clocks:   make(map[uint8]*abscapture.CaptureClock),
+		frames:   make(map[uint8]uint32),
+		handlers: make(map[rtcp.PacketType][]RTCPHandler),
+	}
 	for _, p := range params {
 		if p == nil {
 			continue
 		}
-		source, err := mpcg.AddSource(p.RTPCodecCapability, uuid.NewString(), sid)
-		if err != nil {
-			return nil, err
+		id := uuid.NewString()
+		for _, mpcg := range mpcgs {
+			source, err := mpcg.AddSource(p.RTPCodecCapability, id, sid)
+			if err != nil {
+				return nil, err
+			}
+			s.sources[uint8(p.PayloadType)] = append(s.sources[uint8(p.PayloadType)], source)
+			go s.readRTCP(source)
 		}
-		sources[uint8(p.PayloadType)] = source
-		go rtpio.DiscardRTCP.ReadRTCPFrom(source)
 	}
 
-	return &BalancerSink{sources: sources, mpcg: mpcg}, nil
+	return s, nil
+}
+
+// SetCaptureClock stamps the first packet of each of the payload type's
+// frames with its capture time. It must be called before writing.
+func (s *BalancerSink) SetCaptureClock(payloadType uint8, clock *abscapture.CaptureClock) {
+	s.clocks[payloadType] = clock
+}
+
+// Handle registers a handler for RTCP packets of the given type. Handlers are
+// called in registration order.
+func (s *BalancerSink) Handle(typ rtcp.PacketType, h RTCPHandler) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.handlers[typ] = append(s.handlers[typ], h)
+}
+
+// HandleFunc registers a function as a handler for the given type.
+func (s *BalancerSink) HandleFunc(typ rtcp.PacketType, f func(*balancer.ManagedSource, rtcp.Packet)) {
+	s.Handle(typ, RTCPHandlerFunc(f))
+}
+
+// Use appends middleware that wraps dispatch for every RTCP packet. The first
+// middleware registered is the outermost.
+func (s *BalancerSink) Use(mw ...RTCPMiddleware) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.middleware = append(s.middleware, mw...)
+}
+
+// HandleRTCP dispatches pkt to the handlers registered for its type.
+func (s *BalancerSink) HandleRTCP(source *balancer.ManagedSource, pkt rtcp.Packet) {
+	s.RLock()
+	handlers := s.handlers[packetType(pkt)]
+	s.RUnlock()
+
+	for _, h := range handlers {
+		h.HandleRTCP(source, pkt)
+	}
+}
+
+func (s *BalancerSink) readRTCP(source *balancer.ManagedSource) {
+	for {
+		pkts, err := source.ReadRTCP()
+		if err != nil {
+			return
+		}
+		s.RLock()
+		var h RTCPHandler = s
+		for i := len(s.middleware) - 1; i >= 0; i-- {
+			h = s.middleware[i](h)
+		}
+		s.RUnlock()
+		for _, pkt := range pkts {
+			if compound, ok := pkt.(*rtcp.CompoundPacket); ok {
+				for _, p := range *compound {
+					h.HandleRTCP(source, p)
+				}
+				continue
+			}
+			h.HandleRTCP(source, pkt)
+		}
+	}
 }
 
 func (s *BalancerSink) WriteRTP(p *rtp.Packet) error {
-	source, ok := s.sources[p.PayloadType]
+	sources, ok := s.sources[p.PayloadType]
 	if !ok {
 		return fmt.Errorf("no track for payload type %d", p.PayloadType)
 	}
-	if err := source.WriteRTP(p); err != nil {
-		return err
+	if clock, ok := s.clocks[p.PayloadType]; ok && s.firstOfFrame(p) {
+		ext, err := abscapture.NewExtension(clock.Time(p.Timestamp)).Marshal()
+		if err != nil {
+			return err
+		}
+		if err := p.Header.SetExtension(abscapture.LocalID, ext); err != nil {
+			return err
+		}
+	}
+	for _, source := range sources {
+		if err := source.WriteRTP(p); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// firstOfFrame checks if p starts a new frame. Packets of a frame share the
+// timestamp and the muxer writes them in order.
+func (s *BalancerSink) firstOfFrame(p *rtp.Packet) bool {
+	last, ok := s.frames[p.PayloadType]
+	s.frames[p.PayloadType] = p.Timestamp
+	return !ok || last != p.Timestamp
+}
+
 func (s *BalancerSink) Close() error {
-	for _, source := range s.sources {
-		if err := s.mpcg.RemoveSource(source); err != nil {
-			return err
+	for _, sources := range s.sources {
+		for i, source := range sources {
+			if err := s.mpcgs[i].RemoveSource(source); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
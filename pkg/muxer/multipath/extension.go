@@ -0,0 +1,50 @@
+package multipath
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// URI identifies the multipath header extension in SDP.
+const URI = "urn:muxable:rtp-hdrext:multipath"
+
+const extensionSize = 5
+
+var errTooSmall = errors.New("buffer too small")
+
+// Extension carries the path a packet was sent on, its sequence number on that
+// path and its sequence number in the original stream. Receivers use it to
+// merge the per-path streams and to measure loss on each path independently.
+//
+//	 0                   1                   2                   3
+//	 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|    path id    |     path sequence number      |    stream     |
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|   sequence    |
+//	+-+-+-+-+-+-+-+-+
+type Extension struct {
+	PathID         uint8
+	PathSequence   uint16
+	StreamSequence uint16
+}
+
+// Marshal serializes the extension.
+func (e Extension) Marshal() ([]byte, error) {
+	buf := make([]byte, extensionSize)
+	buf[0] = e.PathID
+	binary.BigEndian.PutUint16(buf[1:3], e.PathSequence)
+	binary.BigEndian.PutUint16(buf[3:5], e.StreamSequence)
+	return buf, nil
+}
+
+// Unmarshal parses the extension.
+func (e *Extension) Unmarshal(buf []byte) error {
+	if len(buf) < extensionSize {
+		return errTooSmall
+	}
+	e.PathID = buf[0]
+	e.PathSequence = binary.BigEndian.Uint16(buf[1:3])
+	e.StreamSequence = binary.BigEndian.Uint16(buf[3:5])
+	return nil
+}
@@ -0,0 +1,97 @@
+package multipath
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// HeaderExtensionInterceptorFactory is an interceptor.Factory for a
+// HeaderExtensionInterceptor.
+type HeaderExtensionInterceptorFactory struct {
+	pathID uint8
+	rtx    map[uint8]bool
+}
+
+// NewInterceptor constructs a new HeaderExtensionInterceptor.
+func (h *HeaderExtensionInterceptorFactory) NewInterceptor(id string) (interceptor.Interceptor, error) {
+	return &HeaderExtensionInterceptor{pathID: h.pathID, rtx: h.rtx}, nil
+}
+
+// NewHeaderExtensionInterceptor returns a factory for interceptors that tag
+// packets with the given path id. Retransmissions with one of the rtx payload
+// types are tagged with their original sequence number.
+func NewHeaderExtensionInterceptor(pathID uint8, rtxPayloadTypes ...uint8) (*HeaderExtensionInterceptorFactory, error) {
+	rtx := make(map[uint8]bool, len(rtxPayloadTypes))
+	for _, pt := range rtxPayloadTypes {
+		rtx[pt] = true
+	}
+	return &HeaderExtensionInterceptorFactory{pathID: pathID, rtx: rtx}, nil
+}
+
+// HeaderExtensionInterceptor adds the multipath header extension to each
+// outgoing packet. The path sequence number is shared by all streams on the
+// peer connection.
+type HeaderExtensionInterceptor struct {
+	interceptor.NoOp
+	pathID         uint8
+	rtx            map[uint8]bool
+	nextSequenceNr uint32
+}
+
+// BindLocalStream returns a writer that adds the multipath extension.
+func (h *HeaderExtensionInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	var hdrExtID uint8
+	for _, e := range info.RTPHeaderExtensions {
+		if e.URI == URI {
+			hdrExtID = uint8(e.ID)
+			break
+		}
+	}
+	if hdrExtID == 0 {
+		return writer
+	}
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		sequenceNumber := atomic.AddUint32(&h.nextSequenceNr, 1) - 1
+
+		streamSequence := header.SequenceNumber
+		if header.SSRC != info.SSRC && h.rtx[header.PayloadType] && len(payload) >= 2 {
+			// RFC 4588 retransmissions are written through the media stream
+			// and carry the original sequence number, which is what the
+			// receiver merges the paths by.
+			streamSequence = binary.BigEndian.Uint16(payload)
+		}
+		ext, err := (&Extension{
+			PathID:         h.pathID,
+			PathSequence:   uint16(sequenceNumber),
+			StreamSequence: streamSequence,
+		}).Marshal()
+		if err != nil {
+			return 0, err
+		}
+		if err := header.SetExtension(hdrExtID, ext); err != nil {
+			return 0, err
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// ConfigureHeaderExtensionSender registers the multipath extension for audio
+// and video and adds the interceptor that populates it.
+func ConfigureHeaderExtensionSender(m *webrtc.MediaEngine, i *interceptor.Registry, pathID uint8, rtxPayloadTypes ...uint8) error {
+	if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: URI}, webrtc.RTPCodecTypeVideo); err != nil {
+		return err
+	}
+	if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: URI}, webrtc.RTPCodecTypeAudio); err != nil {
+		return err
+	}
+	f, err := NewHeaderExtensionInterceptor(pathID, rtxPayloadTypes...)
+	if err != nil {
+		return err
+	}
+	i.Add(f)
+	return nil
+}
@@ -0,0 +1,83 @@
+package nack
+
+import (
+	"sync"
+	"time"
+)
+
+// Budget is a token bucket limiting retransmissions to a fraction of the
+// estimated bitrate. Low priority retransmissions may only use the bucket down
+// to a reserve so that keyframe and audio retransmissions still get through
+// under load.
+type Budget struct {
+	sync.Mutex
+
+	fraction float64
+	depth    time.Duration
+	reserve  float64
+	bitrate  func() int
+
+	tokens float64
+	last   time.Time
+
+	sent, dropped, duplicates uint64
+}
+
+// NewBudget creates a budget of fraction times the bitrate returned by
+// bitrate, allowing bursts of up to depth worth of budget.
+func NewBudget(fraction float64, depth time.Duration, bitrate func() int) *Budget {
+	return &Budget{
+		fraction: fraction,
+		depth:    depth,
+		reserve:  0.25,
+		bitrate:  bitrate,
+		last:     time.Now(),
+	}
+}
+
+// Allow reports whether a retransmission of size bytes fits in the budget and
+// consumes it if so.
+func (b *Budget) Allow(size int, priority bool) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+	rate := b.fraction * float64(b.bitrate())
+	capacity := rate * b.depth.Seconds()
+	b.tokens += rate * now.Sub(b.last).Seconds()
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.last = now
+
+	floor := 0.0
+	if !priority {
+		floor = b.reserve * capacity
+	}
+	bits := float64(size * 8)
+	if b.tokens-bits < floor {
+		b.dropped++
+		return false
+	}
+	b.tokens -= bits
+	b.sent++
+	return true
+}
+
+// Duplicate records a retransmission request that was ignored because the
+// packet was already resent within the last round trip.
+func (b *Budget) Duplicate() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.duplicates++
+}
+
+// Counters returns the number of retransmissions sent, dropped for exceeding
+// the budget and ignored as duplicates.
+func (b *Budget) Counters() (sent, dropped, duplicates uint64) {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.sent, b.dropped, b.duplicates
+}
@@ -0,0 +1,25 @@
+package nack
+
+import (
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// RTT computes the round trip time from a reception report as described in
+// RFC 3550 section 6.4.1. It returns false if the report doesn't reference a
+// sender report.
+func RTT(report rtcp.ReceptionReport, now time.Time) (time.Duration, bool) {
+	if report.LastSenderReport == 0 {
+		return 0, false
+	}
+	// the middle 32 bits of the ntp timestamp, in units of 1/65536 seconds.
+	secs := uint64(now.Unix() + 2208988800)
+	frac := uint64(now.Nanosecond()) << 32 / 1e9
+	ntp := uint32(secs<<16 | frac>>16)
+	rtt := ntp - report.LastSenderReport - report.Delay
+	if rtt > 1<<31 {
+		return 0, false
+	}
+	return time.Duration(rtt) * time.Second / 65536, true
+}
@@ -0,0 +1,276 @@
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Period string
+
+const (
+	Daily   Period = "daily"
+	Monthly Period = "monthly"
+)
+
+// start returns the beginning of the period containing t.
+func (p Period) start(t time.Time) time.Time {
+	switch p {
+	case Daily:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// Quota limits the bytes an interface may transfer per period.
+type Quota struct {
+	Bytes  uint64
+	Period Period
+	// Warning is the fraction of the quota at which a warning is logged.
+	Warning float64
+	// Disable stops the interface from carrying media once the quota is
+	// reached, otherwise it is only deprioritised.
+	Disable bool
+}
+
+type State int
+
+const (
+	OK State = iota
+	Warning
+	Exhausted
+)
+
+// RolloverInterval is how often the accountant checks whether the quota
+// periods have rolled over.
+var RolloverInterval = time.Minute
+
+type usage struct {
+	// Bytes is accessed atomically.
+	Bytes       uint64    `json:"bytes"`
+	PeriodStart time.Time `json:"periodStart"`
+
+	warned int32
+}
+
+// Accountant counts bytes per interface and persists the counts so they
+// survive restarts. Counting is lock free once an interface has been seen,
+// the periods are rolled over by a ticker.
+type Accountant struct {
+	sync.RWMutex
+
+	path   string
+	quotas map[string]Quota
+	usage  map[string]*usage
+	dirty  int32
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Open loads the usage stored at path, if any.
+func Open(path string, quotas map[string]Quota) (*Accountant, error) {
+	a := &Accountant{path: path, quotas: quotas, usage: make(map[string]*usage), done: make(chan struct{})}
+	buf, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(buf, &a.usage); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+	a.rollover(time.Now())
+	go a.run()
+	return a, nil
+}
+
+func (a *Accountant) run() {
+	ticker := time.NewTicker(RolloverInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			a.rollover(now)
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// Close stops rolling over the periods, it's safe to call more than once.
+func (a *Accountant) Close() {
+	a.closeOnce.Do(func() { close(a.done) })
+}
+
+// rollover resets the usage of the interfaces whose period has ended.
+func (a *Accountant) rollover(now time.Time) {
+	a.Lock()
+	defer a.Unlock()
+
+	for iface, u := range a.usage {
+		start := a.quotas[iface].Period.start(now)
+		if !u.PeriodStart.Equal(start) {
+			atomic.StoreUint64(&u.Bytes, 0)
+			atomic.StoreInt32(&u.warned, 0)
+			u.PeriodStart = start
+			atomic.StoreInt32(&a.dirty, 1)
+		}
+	}
+}
+
+// get returns the usage for iface, creating it if it's new.
+func (a *Accountant) get(iface string) *usage {
+	a.RLock()
+	u, ok := a.usage[iface]
+	a.RUnlock()
+	if ok {
+		return u
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if u, ok := a.usage[iface]; ok {
+		return u
+	}
+	u = &usage{PeriodStart: a.quotas[iface].Period.start(time.Now())}
+	a.usage[iface] = u
+	return u
+}
+
+// Add records n bytes transferred on iface.
+func (a *Accountant) Add(iface string, n uint64) {
+	u := a.get(iface)
+	bytes := atomic.AddUint64(&u.Bytes, n)
+	atomic.StoreInt32(&a.dirty, 1)
+
+	q, ok := a.quotas[iface]
+	if ok && q.Warning > 0 && float64(bytes) >= q.Warning*float64(q.Bytes) && atomic.CompareAndSwapInt32(&u.warned, 0, 1) {
+		log.Warn().Str("Interface", iface).Uint64("Bytes", bytes).Uint64("Quota", q.Bytes).Msg("approaching data quota")
+	}
+}
+
+// State returns the quota state of iface.
+func (a *Accountant) State(iface string) State {
+	q, ok := a.quotas[iface]
+	if !ok || q.Bytes == 0 {
+		return OK
+	}
+	bytes := a.Usage(iface)
+	switch {
+	case bytes >= q.Bytes:
+		return Exhausted
+	case q.Warning > 0 && float64(bytes) >= q.Warning*float64(q.Bytes):
+		return Warning
+	}
+	return OK
+}
+
+// Quota returns the quota configured for iface.
+func (a *Accountant) Quota(iface string) (Quota, bool) {
+	q, ok := a.quotas[iface]
+	return q, ok
+}
+
+// Usage returns the bytes transferred on iface in the current period.
+func (a *Accountant) Usage(iface string) uint64 {
+	return atomic.LoadUint64(&a.get(iface).Bytes)
+}
+
+// Save writes the usage to disk if it changed since the last save. The usage
+// is written again by the next save if this one fails.
+func (a *Accountant) Save() (err error) {
+	if !atomic.CompareAndSwapInt32(&a.dirty, 1, 0) {
+		return nil
+	}
+	defer func() {
+		if err != nil {
+			atomic.StoreInt32(&a.dirty, 1)
+		}
+	}()
+	a.RLock()
+	snapshot := make(map[string]usage, len(a.usage))
+	for iface, u := range a.usage {
+		snapshot[iface] = usage{Bytes: atomic.LoadUint64(&u.Bytes), PeriodStart: u.PeriodStart}
+	}
+	a.RUnlock()
+	buf, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a crash doesn't truncate the counts.
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), filepath.Base(a.path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), a.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// ParseQuotas parses a comma separated list of quotas of the form
+// IFACE=SIZE/PERIOD, for example "usb0=2GB/monthly,usb1=500MB/daily".
+func ParseQuotas(s string, warning float64, disable bool) (map[string]Quota, error) {
+	quotas := make(map[string]Quota)
+	for _, token := range strings.Split(s, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		eq := strings.Index(token, "=")
+		slash := strings.LastIndex(token, "/")
+		if eq < 0 || slash < eq {
+			return nil, fmt.Errorf("invalid quota %q", token)
+		}
+		size, err := parseSize(token[eq+1 : slash])
+		if err != nil {
+			return nil, fmt.Errorf("invalid quota %q: %w", token, err)
+		}
+		period := Period(token[slash+1:])
+		if period != Daily && period != Monthly {
+			return nil, fmt.Errorf("invalid quota period %q", period)
+		}
+		quotas[token[:eq]] = Quota{Bytes: size, Period: period, Warning: warning, Disable: disable}
+	}
+	return quotas, nil
+}
+
+func parseSize(s string) (uint64, error) {
+	units := []struct {
+		suffix string
+		scale  uint64
+	}{{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}, {"B", 1}}
+	s = strings.ToUpper(strings.TrimSpace(s))
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(s, u.suffix), 64)
+			if err != nil {
+				return 0, err
+			}
+			return uint64(v * float64(u.scale)), nil
+		}
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
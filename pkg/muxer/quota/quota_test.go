@@ -0,0 +1,128 @@
+package quota
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAccountantAdd(t *testing.T) {
+	a, err := Open(filepath.Join(t.TempDir(), "usage.json"), map[string]Quota{
+		"usb0": {Bytes: 1000, Period: Daily, Warning: 0.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				a.Add("usb0", 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := a.Usage("usb0"); got != 800 {
+		t.Errorf("got usage %d, want 800", got)
+	}
+	if got := a.State("usb0"); got != Warning {
+		t.Errorf("got state %v, want %v", got, Warning)
+	}
+	a.Add("usb0", 200)
+	if got := a.State("usb0"); got != Exhausted {
+		t.Errorf("got state %v, want %v", got, Exhausted)
+	}
+	if got := a.State("eth0"); got != OK {
+		t.Errorf("got state %v for an interface without a quota, want %v", got, OK)
+	}
+}
+
+func TestAccountantRollover(t *testing.T) {
+	a, err := Open(filepath.Join(t.TempDir(), "usage.json"), map[string]Quota{
+		"usb0": {Bytes: 1000, Period: Daily},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	a.Add("usb0", 1000)
+	a.rollover(time.Now())
+	if got := a.Usage("usb0"); got != 1000 {
+		t.Errorf("got usage %d within the period, want 1000", got)
+	}
+	a.rollover(time.Now().Add(24 * time.Hour))
+	if got := a.Usage("usb0"); got != 0 {
+		t.Errorf("got usage %d after the period, want 0", got)
+	}
+}
+
+func TestAccountantSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	quotas := map[string]Quota{"usb0": {Bytes: 1000, Period: Monthly}}
+	a, err := Open(path, quotas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Add("usb0", 123)
+	if err := a.Save(); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+
+	b, err := Open(path, quotas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if got := b.Usage("usb0"); got != 123 {
+		t.Errorf("got usage %d after reopening, want 123", got)
+	}
+}
+
+func TestAccountantSaveRetries(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	path := filepath.Join(dir, "usage.json")
+	quotas := map[string]Quota{"usb0": {Bytes: 1000, Period: Monthly}}
+	a, err := Open(path, quotas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	a.Add("usb0", 123)
+	if err := a.Save(); err == nil {
+		t.Fatal("saved to a missing directory")
+	}
+	// the failed save leaves the usage to be saved again.
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Open(path, quotas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if got := b.Usage("usb0"); got != 123 {
+		t.Errorf("got usage %d after reopening, want 123", got)
+	}
+}
+
+func TestAccountantCloseTwice(t *testing.T) {
+	a, err := Open(filepath.Join(t.TempDir(), "usage.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+	a.Close()
+}
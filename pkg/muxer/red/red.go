@@ -0,0 +1,92 @@
+package red
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pion/rtp"
+)
+
+// MimeTypeRED is the media type for RFC 2198 redundant audio.
+const MimeTypeRED = "audio/red"
+
+const (
+	maxTimestampOffset = 1<<14 - 1
+	maxBlockLength     = 1<<10 - 1
+)
+
+// FmtpLine returns the fmtp parameters advertising depth redundant copies of
+// payloadType, for example "97/97/97" for a depth of two.
+func FmtpLine(payloadType uint8, depth int) string {
+	pts := make([]string, depth+1)
+	for i := range pts {
+		pts[i] = fmt.Sprint(payloadType)
+	}
+	return strings.Join(pts, "/")
+}
+
+type block struct {
+	timestamp uint32
+	payload   []byte
+}
+
+// Encoder wraps packets of a single payload type in RFC 2198 RED, carrying up
+// to depth previous payloads alongside the primary one.
+type Encoder struct {
+	sync.Mutex
+
+	payloadType uint8
+	depth       int
+	history     []block
+}
+
+func NewEncoder(payloadType uint8, depth int) *Encoder {
+	return &Encoder{payloadType: payloadType, depth: depth}
+}
+
+// Encode returns a new packet with the RED payload. The header is copied from
+// pkt, the payload type is left for the track to rewrite.
+func (e *Encoder) Encode(pkt *rtp.Packet) *rtp.Packet {
+	return &rtp.Packet{Header: pkt.Header.Clone(), Payload: e.AppendPayload(nil, pkt)}
+}
+
+// AppendPayload appends the RED payload for pkt to dst and returns it. The
+// history reuses its buffers so a reused dst makes encoding allocation free.
+func (e *Encoder) AppendPayload(dst []byte, pkt *rtp.Packet) []byte {
+	e.Lock()
+	defer e.Unlock()
+
+	// redundant blocks that can no longer be expressed are skipped.
+	usable := func(b block) bool {
+		return pkt.Timestamp-b.timestamp <= maxTimestampOffset && len(b.payload) <= maxBlockLength
+	}
+	var header [4]byte
+	for _, b := range e.history {
+		if usable(b) {
+			binary.BigEndian.PutUint32(header[:], 1<<31|uint32(e.payloadType)<<24|(pkt.Timestamp-b.timestamp)<<10|uint32(len(b.payload)))
+			dst = append(dst, header[:]...)
+		}
+	}
+	dst = append(dst, e.payloadType)
+	for _, b := range e.history {
+		if usable(b) {
+			dst = append(dst, b.payload...)
+		}
+	}
+	dst = append(dst, pkt.Payload...)
+
+	if e.depth == 0 {
+		return dst
+	}
+	// the oldest block's buffer is reused for the new one.
+	var primary []byte
+	if len(e.history) == e.depth {
+		primary = e.history[0].payload[:0]
+		copy(e.history, e.history[1:])
+		e.history = e.history[:len(e.history)-1]
+	}
+	e.history = append(e.history, block{timestamp: pkt.Timestamp, payload: append(primary, pkt.Payload...)})
+	return dst
+}
@@ -0,0 +1,135 @@
+package red
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+// redBlock is a decoded RFC 2198 block.
+type redBlock struct {
+	offset  uint32
+	payload []byte
+}
+
+// decode splits a RED payload into its redundant blocks and primary payload.
+func decode(t *testing.T, payload []byte, payloadType uint8) ([]redBlock, []byte) {
+	var blocks []redBlock
+	for len(payload) > 0 && payload[0]&0x80 != 0 {
+		if len(payload) < 4 {
+			t.Fatalf("short block header %x", payload)
+		}
+		header := binary.BigEndian.Uint32(payload)
+		if pt := uint8(header>>24) & 0x7f; pt != payloadType {
+			t.Errorf("block payload type %d, want %d", pt, payloadType)
+		}
+		blocks = append(blocks, redBlock{offset: header >> 10 & maxTimestampOffset, payload: make([]byte, header&maxBlockLength)})
+		payload = payload[4:]
+	}
+	if len(payload) == 0 || payload[0] != payloadType {
+		t.Fatalf("missing primary header %x", payload)
+	}
+	payload = payload[1:]
+	for i := range blocks {
+		if len(payload) < len(blocks[i].payload) {
+			t.Fatalf("short block %d", i)
+		}
+		payload = payload[copy(blocks[i].payload, payload):]
+	}
+	return blocks, payload
+}
+
+func TestFmtpLine(t *testing.T) {
+	for depth, want := range []string{"97", "97/97", "97/97/97"} {
+		if got := FmtpLine(97, depth); got != want {
+			t.Errorf("FmtpLine(97, %d) = %s, want %s", depth, got, want)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	e := NewEncoder(97, 2)
+	for i := 0; i < 4; i++ {
+		pkt := &rtp.Packet{
+			Header:  rtp.Header{SequenceNumber: uint16(i), Timestamp: uint32(960 * i), PayloadType: 111},
+			Payload: bytes.Repeat([]byte{byte(i)}, 10+i),
+		}
+		out := e.Encode(pkt)
+		if out.SequenceNumber != pkt.SequenceNumber || out.Timestamp != pkt.Timestamp {
+			t.Errorf("header %v not copied from %v", out.Header, pkt.Header)
+		}
+		blocks, primary := decode(t, out.Payload, 97)
+		if !bytes.Equal(primary, pkt.Payload) {
+			t.Errorf("packet %d primary %x, want %x", i, primary, pkt.Payload)
+		}
+		want := i
+		if want > 2 {
+			want = 2
+		}
+		if len(blocks) != want {
+			t.Fatalf("packet %d has %d redundant blocks, want %d", i, len(blocks), want)
+		}
+		// the blocks are the previous payloads, oldest first.
+		for j, b := range blocks {
+			k := i - len(blocks) + j
+			if b.offset != uint32(960*(i-k)) {
+				t.Errorf("packet %d block %d offset %d, want %d", i, j, b.offset, 960*(i-k))
+			}
+			if !bytes.Equal(b.payload, bytes.Repeat([]byte{byte(k)}, 10+k)) {
+				t.Errorf("packet %d block %d payload %x", i, j, b.payload)
+			}
+		}
+	}
+}
+
+func TestEncodeDepthZero(t *testing.T) {
+	e := NewEncoder(97, 0)
+	for i := 0; i < 2; i++ {
+		out := e.Encode(&rtp.Packet{Header: rtp.Header{Timestamp: uint32(960 * i)}, Payload: []byte{1, 2, 3}})
+		if !bytes.Equal(out.Payload, []byte{97, 1, 2, 3}) {
+			t.Errorf("payload %x, want a lone primary block", out.Payload)
+		}
+	}
+}
+
+func TestEncodeSkipsUnrepresentable(t *testing.T) {
+	e := NewEncoder(97, 1)
+
+	// a block too long for the length field isn't carried.
+	e.Encode(&rtp.Packet{Header: rtp.Header{Timestamp: 0}, Payload: make([]byte, maxBlockLength+1)})
+	blocks, _ := decode(t, e.Encode(&rtp.Packet{Header: rtp.Header{Timestamp: 960}, Payload: []byte{1}}).Payload, 97)
+	if len(blocks) != 0 {
+		t.Errorf("carried %d blocks after an oversized payload", len(blocks))
+	}
+
+	// neither is one too old for the timestamp offset field.
+	blocks, _ = decode(t, e.Encode(&rtp.Packet{Header: rtp.Header{Timestamp: 960 + maxTimestampOffset + 1}, Payload: []byte{2}}).Payload, 97)
+	if len(blocks) != 0 {
+		t.Errorf("carried %d blocks after a timestamp gap", len(blocks))
+	}
+
+	// and the encoder recovers once the history is representable again.
+	blocks, _ = decode(t, e.Encode(&rtp.Packet{Header: rtp.Header{Timestamp: 1920 + maxTimestampOffset + 1}, Payload: []byte{3}}).Payload, 97)
+	if len(blocks) != 1 || !bytes.Equal(blocks[0].payload, []byte{2}) {
+		t.Errorf("blocks %v, want the previous payload", blocks)
+	}
+}
+
+func TestAppendPayloadReusesBuffers(t *testing.T) {
+	e := NewEncoder(97, 2)
+	pkt := &rtp.Packet{Payload: make([]byte, 100)}
+	var dst []byte
+	for i := 0; i < 3; i++ {
+		pkt.Timestamp += 960
+		dst = e.AppendPayload(dst[:0], pkt)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		pkt.Timestamp += 960
+		dst = e.AppendPayload(dst[:0], pkt)
+	})
+	if allocs != 0 {
+		t.Errorf("AppendPayload allocated %v times, want 0", allocs)
+	}
+}
@@ -0,0 +1,16 @@
+package route
+
+import (
+	"encoding/binary"
+	"unsafe"
+)
+
+// nativeEndian is the host byte order, which netlink and /proc/net/route use.
+// binary.NativeEndian needs go 1.21.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	v := uint16(1)
+	if *(*byte)(unsafe.Pointer(&v)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
@@ -0,0 +1,254 @@
+// Package route installs source based policy routing so that sockets bound to
+// an interface's address leave through that interface. This replaces
+// SO_BINDTODEVICE, which requires CAP_NET_RAW, with a one-off setup that only
+// the process installing the routes needs CAP_NET_ADMIN for.
+package route
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+const (
+	// TableBase is added to the interface index to pick its routing table.
+	TableBase = 1000
+	// RulePriority is the priority of the source rules, it must be lower than
+	// the main table's 32766.
+	RulePriority = 1000
+
+	// rule attributes from linux/fib_rules.h.
+	fraSrc      = 2
+	fraPriority = 6
+	fraTable    = 15
+	frActToTbl  = 1
+)
+
+// Route is the source route for a single interface.
+type Route struct {
+	Device  string
+	Index   int
+	Source  net.IP
+	Gateway net.IP
+}
+
+func (r Route) String() string {
+	return fmt.Sprintf("from %s via %s dev %s table %d", r.Source, r.Gateway, r.Device, r.Table())
+}
+
+// Table returns the routing table holding the interface's default route.
+func (r Route) Table() int {
+	return TableBase + r.Index
+}
+
+// NewRoute looks up the interface and its default gateway. The gateway is nil
+// for point to point links.
+func NewRoute(device string, source net.IP) (Route, error) {
+	iface, err := net.InterfaceByName(device)
+	if err != nil {
+		return Route{}, err
+	}
+	gateways, err := Gateways()
+	if err != nil {
+		return Route{}, err
+	}
+	return Route{Device: device, Index: iface.Index, Source: source.To4(), Gateway: gateways[device]}, nil
+}
+
+// Gateways returns the default gateway of each interface from the main table.
+func Gateways() (map[string]net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gateways := make(map[string]net.IP)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[1] != "00000000" {
+			continue
+		}
+		gw, err := hex.DecodeString(fields[2])
+		if err != nil || len(gw) != 4 {
+			continue
+		}
+		// the kernel prints addresses in host byte order.
+		ip := make(net.IP, 4)
+		nativeEndian.PutUint32(ip, binary.BigEndian.Uint32(gw))
+		if !ip.Equal(net.IPv4zero) {
+			gateways[fields[0]] = ip
+		}
+	}
+	return gateways, scanner.Err()
+}
+
+// Install adds the interface's default route to its table and a rule sending
+// traffic from its source address to that table, replacing any previous rules
+// for the table.
+func Install(r Route) error {
+	s, err := dial()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if err := s.deleteRules(r); err != nil {
+		return err
+	}
+	if err := s.request(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE, routeMessage(r)); err != nil {
+		return fmt.Errorf("failed to add route %s: %w", r, err)
+	}
+	if err := s.request(syscall.RTM_NEWRULE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, ruleMessage(r, true)); err != nil {
+		return fmt.Errorf("failed to add rule %s: %w", r, err)
+	}
+	return nil
+}
+
+// Remove deletes the interface's rules and route.
+func Remove(r Route) error {
+	s, err := dial()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if err := s.deleteRules(r); err != nil {
+		return err
+	}
+	if err := s.request(syscall.RTM_DELROUTE, 0, routeMessage(r)); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("failed to delete route %s: %w", r, err)
+	}
+	return nil
+}
+
+func routeMessage(r Route) []byte {
+	scope := uint8(syscall.RT_SCOPE_UNIVERSE)
+	if r.Gateway == nil {
+		scope = syscall.RT_SCOPE_LINK
+	}
+	// struct rtmsg, the table is passed as an attribute since it may not fit.
+	b := []byte{syscall.AF_INET, 0, 0, 0, syscall.RT_TABLE_UNSPEC, syscall.RTPROT_STATIC, scope, syscall.RTN_UNICAST, 0, 0, 0, 0}
+	b = appendAttr(b, syscall.RTA_TABLE, uint32Bytes(uint32(r.Table())))
+	b = appendAttr(b, syscall.RTA_OIF, uint32Bytes(uint32(r.Index)))
+	b = appendAttr(b, syscall.RTA_PREFSRC, r.Source.To4())
+	if r.Gateway != nil {
+		b = appendAttr(b, syscall.RTA_GATEWAY, r.Gateway.To4())
+	}
+	return b
+}
+
+func ruleMessage(r Route, source bool) []byte {
+	srcLen := uint8(0)
+	if source {
+		srcLen = 32
+	}
+	// struct fib_rule_hdr.
+	b := []byte{syscall.AF_INET, 0, srcLen, 0, syscall.RT_TABLE_UNSPEC, 0, 0, frActToTbl, 0, 0, 0, 0}
+	b = appendAttr(b, fraTable, uint32Bytes(uint32(r.Table())))
+	b = appendAttr(b, fraPriority, uint32Bytes(RulePriority))
+	if source {
+		b = appendAttr(b, fraSrc, r.Source.To4())
+	}
+	return b
+}
+
+func uint32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	nativeEndian.PutUint32(b, v)
+	return b
+}
+
+func appendAttr(b []byte, typ uint16, data []byte) []byte {
+	attr := make([]byte, syscall.SizeofRtAttr, rtaAlign(syscall.SizeofRtAttr+len(data)))
+	nativeEndian.PutUint16(attr[0:2], uint16(syscall.SizeofRtAttr+len(data)))
+	nativeEndian.PutUint16(attr[2:4], typ)
+	attr = append(attr, data...)
+	return append(b, attr[:cap(attr)]...)
+}
+
+func rtaAlign(n int) int {
+	return (n + syscall.RTA_ALIGNTO - 1) &^ (syscall.RTA_ALIGNTO - 1)
+}
+
+type socket struct {
+	fd int
+}
+
+var sequence uint32
+
+func dial() (*socket, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &socket{fd: fd}, nil
+}
+
+func (s *socket) Close() error {
+	return syscall.Close(s.fd)
+}
+
+// deleteRules removes every rule at our priority pointing at the table, which
+// includes rules for addresses the interface no longer has.
+func (s *socket) deleteRules(r Route) error {
+	for {
+		err := s.request(syscall.RTM_DELRULE, 0, ruleMessage(r, false))
+		if err == syscall.ENOENT {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete rule %s: %w", r, err)
+		}
+	}
+}
+
+// request sends a netlink request and waits for its acknowledgement.
+func (s *socket) request(typ uint16, flags int, body []byte) error {
+	seq := atomic.AddUint32(&sequence, 1)
+	b := make([]byte, syscall.NLMSG_HDRLEN, syscall.NLMSG_HDRLEN+len(body))
+	nativeEndian.PutUint32(b[0:4], uint32(syscall.NLMSG_HDRLEN+len(body)))
+	nativeEndian.PutUint16(b[4:6], typ)
+	nativeEndian.PutUint16(b[6:8], uint16(syscall.NLM_F_REQUEST|syscall.NLM_F_ACK|flags))
+	nativeEndian.PutUint32(b[8:12], seq)
+	b = append(b, body...)
+	if err := syscall.Sendto(s.fd, b, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(s.fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if msg.Header.Seq != seq || msg.Header.Type != syscall.NLMSG_ERROR {
+				continue
+			}
+			if len(msg.Data) < 4 {
+				return fmt.Errorf("short netlink error")
+			}
+			if errno := int32(nativeEndian.Uint32(msg.Data[0:4])); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package scream
+
+import (
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// ack is the fate of a single packet reported by transport wide feedback.
+type ack struct {
+	sequence uint16
+	received bool
+	// arrival is on the receiver's clock.
+	arrival time.Duration
+}
+
+// unpack expands transport wide feedback into per packet acks.
+func unpack(fb *rtcp.TransportLayerCC) []ack {
+	acks := make([]ack, 0, fb.PacketStatusCount)
+	arrival := time.Duration(fb.ReferenceTime) * 64 * time.Millisecond
+	deltas := fb.RecvDeltas
+	seq := fb.BaseSequenceNumber
+	add := func(symbol uint16) bool {
+		if len(acks) >= int(fb.PacketStatusCount) {
+			return false
+		}
+		a := ack{sequence: seq}
+		if symbol == rtcp.TypeTCCPacketReceivedSmallDelta || symbol == rtcp.TypeTCCPacketReceivedLargeDelta {
+			if len(deltas) == 0 {
+				return false
+			}
+			arrival += time.Duration(deltas[0].Delta) * time.Microsecond
+			deltas = deltas[1:]
+			a.received = true
+			a.arrival = arrival
+		}
+		acks = append(acks, a)
+		seq++
+		return true
+	}
+	for _, chunk := range fb.PacketChunks {
+		switch chunk := chunk.(type) {
+		case *rtcp.RunLengthChunk:
+			for i := uint16(0); i < chunk.RunLength; i++ {
+				if !add(chunk.PacketStatusSymbol) {
+					return acks
+				}
+			}
+		case *rtcp.StatusVectorChunk:
+			for _, symbol := range chunk.SymbolList {
+				if !add(symbol) {
+					return acks
+				}
+			}
+		}
+	}
+	return acks
+}
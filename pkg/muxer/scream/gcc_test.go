@@ -0,0 +1,384 @@
+package scream
+
+import (
+	"math"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+type gccState int
+
+const (
+	stateIncrease gccState = iota
+	stateDecrease
+	stateHold
+)
+
+type usage int
+
+const (
+	usageNormal usage = iota
+	usageOver
+	usageUnder
+)
+
+func (s gccState) transition(u usage) gccState {
+	switch {
+	case u == usageOver:
+		return stateDecrease
+	case u == usageUnder:
+		return stateHold
+	case s == stateDecrease:
+		return stateHold
+	}
+	return stateIncrease
+}
+
+type arrivalAck struct {
+	departure time.Time
+	arrival   time.Duration
+	size      int
+}
+
+type arrivalGroup struct {
+	departure time.Time
+	arrival   time.Duration
+}
+
+// referenceGCC is pion's gcc.SendSideBWE from interceptor v0.1.10 collapsed
+// into a single goroutine. pion's runs as a pipeline of goroutines reading the
+// wall clock so it can't run on the simulation's clock, the delay and loss
+// controllers and their constants are otherwise the same.
+type referenceGCC struct {
+	now func() time.Time
+
+	history [1 << 16]sent
+
+	// arrival group accumulator and rate calculator.
+	group, lastGroup arrivalGroup
+	grouped, delayed bool
+	received         []arrivalAck
+	receivedRate     float64
+	rtt              time.Duration
+
+	// kalman filter.
+	delayEstimate          time.Duration
+	processUncertainty     float64
+	estimateError          float64
+	measurementUncertainty float64
+
+	// overuse detector and adaptive threshold.
+	lastDetect         time.Time
+	lastEstimate       time.Duration
+	increasingDuration time.Duration
+	increasingCounter  int
+	threshold          time.Duration
+	numDeltas          int
+	lastThreshold      time.Time
+
+	// rate controller.
+	state                     gccState
+	started                   bool
+	delayTarget               float64
+	lastUpdate                time.Time
+	decreaseMean, decreaseVar float64
+	decreaseDeviation         float64
+	minBitrate                float64
+	target                    float64
+
+	// loss controller.
+	lossTarget                       float64
+	averageLoss                      float64
+	lastLoss, lastIncrease, lastDrop time.Time
+}
+
+func newReferenceGCC(initial float64, now func() time.Time) *referenceGCC {
+	return &referenceGCC{
+		now:                now,
+		processUncertainty: 1e-3,
+		estimateError:      0.1,
+		threshold:          12500 * time.Microsecond,
+		delayTarget:        initial,
+		lossTarget:         initial,
+		minBitrate:         100_000,
+		target:             initial,
+	}
+}
+
+func (g *referenceGCC) AddStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		var ext rtp.TransportCCExtension
+		if err := ext.Unmarshal(header.GetExtension(transportCCID)); err == nil {
+			g.history[ext.TransportSequence] = sent{departure: g.now(), size: header.MarshalSize() + len(payload), valid: true}
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+func (g *referenceGCC) WriteRTCP(pkts []rtcp.Packet, attributes interceptor.Attributes) error {
+	for _, pkt := range pkts {
+		if fb, ok := pkt.(*rtcp.TransportLayerCC); ok {
+			g.onFeedback(g.now(), unpack(fb))
+		}
+	}
+	return nil
+}
+
+func (g *referenceGCC) onFeedback(now time.Time, acks []ack) {
+	var lost, total int
+	var latest time.Time
+	for _, a := range acks {
+		s := &g.history[a.sequence]
+		if !s.valid {
+			continue
+		}
+		s.valid = false
+		total++
+		if !a.received {
+			lost++
+			continue
+		}
+		if s.departure.After(latest) {
+			latest = s.departure
+		}
+		g.onAck(now, arrivalAck{departure: s.departure, arrival: a.arrival, size: s.size})
+	}
+	if !latest.IsZero() {
+		g.rtt = now.Sub(latest)
+	}
+	if total > 0 {
+		g.updateLoss(now, float64(lost)/float64(total))
+	}
+}
+
+// onAck measures the received rate and groups packets sent in bursts, the
+// delay variation between groups drives the delay controller.
+func (g *referenceGCC) onAck(now time.Time, a arrivalAck) {
+	g.received = append(g.received, a)
+	n, bytes := 0, 0
+	for _, r := range g.received {
+		if r.arrival >= a.arrival-500*time.Millisecond {
+			break
+		}
+		n++
+	}
+	g.received = g.received[n:]
+	for _, r := range g.received {
+		bytes += r.size
+	}
+	if dt := a.arrival - g.received[0].arrival; dt > 0 {
+		g.receivedRate = float64(bytes*8) / dt.Seconds()
+	} else {
+		g.receivedRate = float64(a.size * 8)
+	}
+
+	if !g.grouped {
+		g.group = arrivalGroup{departure: a.departure, arrival: a.arrival}
+		g.grouped = true
+		return
+	}
+	if a.arrival < g.group.arrival || !a.departure.After(g.group.departure) {
+		return
+	}
+	interArrival := a.arrival - g.group.arrival
+	if a.departure.Sub(g.group.departure) <= 5*time.Millisecond ||
+		interArrival <= 5*time.Millisecond && interArrival-a.departure.Sub(g.group.departure) < 0 {
+		g.group = arrivalGroup{departure: a.departure, arrival: a.arrival}
+		return
+	}
+	if g.delayed {
+		variation := (g.group.arrival - g.lastGroup.arrival) - g.group.departure.Sub(g.lastGroup.departure)
+		g.detect(now, g.updateEstimate(variation))
+	}
+	g.lastGroup, g.delayed = g.group, true
+	g.group = arrivalGroup{departure: a.departure, arrival: a.arrival}
+}
+
+// updateEstimate is the slope estimator's kalman filter.
+func (g *referenceGCC) updateEstimate(measurement time.Duration) time.Duration {
+	zms := float64((measurement - g.delayEstimate).Microseconds()) / 1000.0
+
+	alpha := math.Pow(1-0.001, 30.0/(1000.0*5*float64(time.Millisecond)))
+	root3 := 3 * math.Sqrt(g.measurementUncertainty)
+	if zms > root3 {
+		g.measurementUncertainty = math.Max(alpha*g.measurementUncertainty+(1-alpha)*root3*root3, 1)
+	}
+	g.measurementUncertainty = math.Max(alpha*g.measurementUncertainty+(1-alpha)*zms*zms, 1)
+
+	estimateUncertainty := g.estimateError + g.processUncertainty
+	gain := estimateUncertainty / (estimateUncertainty + g.measurementUncertainty)
+	g.delayEstimate += time.Duration(gain * zms * float64(time.Millisecond))
+	g.estimateError = (1 - gain) * estimateUncertainty
+	return g.delayEstimate
+}
+
+// detect is the overuse detector.
+func (g *referenceGCC) detect(now time.Time, estimate time.Duration) {
+	var delta time.Duration
+	if !g.lastDetect.IsZero() {
+		delta = now.Sub(g.lastDetect)
+	}
+	g.lastDetect = now
+
+	thresholdUse, estimate := g.compare(now, estimate)
+	use := usageNormal
+	switch thresholdUse {
+	case usageOver:
+		if g.increasingDuration == 0 {
+			g.increasingDuration = delta / 2
+		} else {
+			g.increasingDuration += delta
+		}
+		g.increasingCounter++
+		if g.increasingDuration > 10*time.Millisecond && g.increasingCounter > 1 && estimate > g.lastEstimate {
+			use = usageOver
+		}
+	case usageUnder:
+		g.increasingDuration, g.increasingCounter = 0, 0
+		use = usageUnder
+	default:
+		g.increasingDuration, g.increasingCounter = 0, 0
+	}
+	g.lastEstimate = estimate
+	g.updateRate(now, use)
+}
+
+// compare checks the estimate against the adaptive threshold and adapts it.
+func (g *referenceGCC) compare(now time.Time, estimate time.Duration) (usage, time.Duration) {
+	g.numDeltas++
+	if g.numDeltas < 2 {
+		return usageNormal, estimate
+	}
+	deltas := g.numDeltas
+	if deltas > 60 {
+		deltas = 60
+	}
+	t := time.Duration(deltas) * estimate
+	use := usageNormal
+	if t > g.threshold {
+		use = usageOver
+	} else if t < -g.threshold {
+		use = usageUnder
+	}
+
+	if g.lastThreshold.IsZero() {
+		g.lastThreshold = now
+	}
+	abs := time.Duration(math.Abs(float64(t.Microseconds()))) * time.Microsecond
+	if abs > g.threshold+15*time.Millisecond {
+		g.lastThreshold = now
+		return use, t
+	}
+	k := 0.01
+	if abs < g.threshold {
+		k = 0.00018
+	}
+	timeDelta := now.Sub(g.lastThreshold).Milliseconds()
+	if timeDelta > 100 {
+		timeDelta = 100
+	}
+	add := k * float64((abs - g.threshold).Milliseconds()) * float64(timeDelta)
+	g.threshold += time.Duration(add) * time.Millisecond
+	if g.threshold < 6*time.Millisecond {
+		g.threshold = 6 * time.Millisecond
+	}
+	if g.threshold > 600*time.Millisecond {
+		g.threshold = 600 * time.Millisecond
+	}
+	g.lastThreshold = now
+	return use, t
+}
+
+// updateRate is the rate controller, the target is the smaller of its rate
+// and the loss controller's.
+func (g *referenceGCC) updateRate(now time.Time, use usage) {
+	if !g.started {
+		g.started = true
+		g.lastUpdate = now
+		return
+	}
+	g.state = g.state.transition(use)
+	switch g.state {
+	case stateHold:
+		return
+	case stateIncrease:
+		g.delayTarget = g.increase(now)
+	case stateDecrease:
+		g.delayTarget = 0.85 * g.receivedRate
+		if g.decreaseMean == 0 {
+			g.decreaseMean = g.receivedRate
+		} else {
+			x := g.receivedRate - g.decreaseMean
+			g.decreaseMean += 0.95 * x
+			g.decreaseVar = (1 - 0.95) * (g.decreaseVar + 0.95*x*x)
+			g.decreaseDeviation = math.Sqrt(g.decreaseVar)
+		}
+		g.lastUpdate = now
+	}
+	g.delayTarget = math.Max(g.minBitrate, g.delayTarget)
+	g.lossTarget = math.Min(g.lossTarget, g.delayTarget)
+	g.target = math.Min(g.delayTarget, g.lossTarget)
+}
+
+// increase is additive near the received rate of past decreases and
+// multiplicative otherwise.
+func (g *referenceGCC) increase(now time.Time) float64 {
+	if g.decreaseMean > 0 && g.receivedRate > g.decreaseMean-3*g.decreaseDeviation &&
+		g.receivedRate < g.decreaseMean+3*g.decreaseDeviation {
+		bitsPerFrame := g.delayTarget / 30.0
+		packetsPerFrame := math.Ceil(bitsPerFrame / (1200 * 8))
+		expectedPacketSizeBits := bitsPerFrame / packetsPerFrame
+
+		responseTime := 100*time.Millisecond + g.rtt
+		alpha := 0.5 * math.Min(float64(now.Sub(g.lastUpdate).Milliseconds())/float64(responseTime.Milliseconds()), 1.0)
+		increase := math.Max(1000.0, alpha*expectedPacketSizeBits)
+		g.lastUpdate = now
+		return math.Min(g.delayTarget+increase, 1.5*g.receivedRate)
+	}
+	eta := math.Pow(1.08, math.Min(float64(now.Sub(g.lastUpdate).Milliseconds())/1000, 1.0))
+	g.lastUpdate = now
+
+	rate := eta * g.delayTarget
+	if received := 1.5 * g.receivedRate; rate > received && received > g.delayTarget {
+		return received
+	}
+	return math.Max(rate, g.delayTarget)
+}
+
+// updateLoss is the loss controller.
+func (g *referenceGCC) updateLoss(now time.Time, ratio float64) {
+	g.averageLoss = ratio + math.Exp(-float64(now.Sub(g.lastLoss).Milliseconds())/200.0)*(g.averageLoss-ratio)
+	g.lastLoss = now
+
+	increaseLoss := math.Max(g.averageLoss, ratio)
+	decreaseLoss := math.Min(g.averageLoss, ratio)
+	if increaseLoss < 0.02 && now.Sub(g.lastIncrease) > 200*time.Millisecond {
+		g.lastIncrease = now
+		g.lossTarget *= 1.05
+	} else if decreaseLoss > 0.1 && now.Sub(g.lastDrop) > 200*time.Millisecond {
+		g.lastDrop = now
+		g.lossTarget *= 1 - 0.5*decreaseLoss
+	}
+	g.lossTarget = math.Max(g.minBitrate, g.lossTarget)
+}
+
+func (g *referenceGCC) GetTargetBitrate() int {
+	return int(g.target)
+}
+
+func (g *referenceGCC) GetStats() map[string]interface{} {
+	return map[string]interface{}{
+		"targetBitrate": int(g.target),
+		"delayEstimate": float64(g.delayEstimate.Microseconds()) / 1000.0,
+		"threshold":     float64(g.threshold.Microseconds()) / 1000.0,
+	}
+}
+
+func (g *referenceGCC) OnTargetBitrateChange(f func(bitrate int)) {}
+
+func (g *referenceGCC) Close() error {
+	return nil
+}
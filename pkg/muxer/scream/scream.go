@@ -0,0 +1,428 @@
+// Package scream implements a congestion controller in the style of SCReAM,
+// RFC 8298. A congestion window is driven by the estimated queuing delay
+// rather than by delay gradients, which keeps the rate steady on cellular
+// links with deep, jittery buffers where GCC tends to oscillate. The media
+// target bitrate follows the window over the smoothed round trip time, and
+// the sender is self-clocked: it holds packets back while CanSend reports the
+// window is full.
+package scream
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+const (
+	transportCCURI = "http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01"
+
+	mss = 1200
+	// minimumCwnd keeps enough packets in flight for feedback to flow.
+	minimumCwnd = 3 * mss
+
+	// baseDelayWindow is the span of each base delay minimum, the base delay
+	// is the smallest of baseDelayWindows of them.
+	baseDelayWindow  = 10 * time.Second
+	baseDelayWindows = 6
+
+	// postCongestionDelay is how long after a backoff the window is kept out
+	// of fast increase.
+	postCongestionDelay = 2 * time.Second
+
+	// the target bitrate increases by at most rampUpScale of itself, or
+	// minimumRampUp, per second.
+	rampUpScale   = 0.25
+	minimumRampUp = 200_000
+
+	// windowHeadroom is the ratio of the window to the bytes the target
+	// bitrate keeps in flight.
+	windowHeadroom = 1.25
+
+	// feedbackTimeout is how long the window stays closed without feedback
+	// before the packets in flight are presumed lost, so a lost feedback
+	// packet can't stall the sender.
+	feedbackTimeout = time.Second
+)
+
+// DefaultQueueDelayTarget is the queuing delay the window is steered towards
+// unless QueueDelayTarget is given.
+const DefaultQueueDelayTarget = 100 * time.Millisecond
+
+// Option configures an Estimator.
+type Option func(*Estimator) error
+
+// InitialBitrate sets the starting target bitrate.
+func InitialBitrate(rate int) Option {
+	return func(e *Estimator) error {
+		e.target = float64(rate)
+		return nil
+	}
+}
+
+// BitrateLimits bounds the target bitrate.
+func BitrateLimits(min, max int) Option {
+	return func(e *Estimator) error {
+		e.minBitrate, e.maxBitrate = float64(min), float64(max)
+		return nil
+	}
+}
+
+// QueueDelayTarget sets the queuing delay the window is steered towards.
+func QueueDelayTarget(d time.Duration) Option {
+	return func(e *Estimator) error {
+		e.qdelayTarget = d
+		return nil
+	}
+}
+
+// withClock replaces the wall clock, the simulation tests run on their own.
+func withClock(now func() time.Time) Option {
+	return func(e *Estimator) error {
+		e.now = now
+		return nil
+	}
+}
+
+type sent struct {
+	departure time.Time
+	size      int
+	// total is the number of bytes sent up to and including this packet.
+	total uint64
+	valid bool
+}
+
+// Estimator is a cc.BandwidthEstimator using SCReAM's window based network
+// congestion control.
+type Estimator struct {
+	lock sync.Mutex
+	now  func() time.Time
+
+	minBitrate, maxBitrate float64
+	qdelayTarget           time.Duration
+
+	history   [1 << 16]sent
+	totalSent uint64
+	totalAckd uint64
+
+	cwnd        float64
+	maxInFlight float64
+	// delivered is the smoothed rate acknowledged bytes arrive at.
+	delivered float64
+
+	baseDelays  [baseDelayWindows]time.Duration
+	baseDelayAt time.Time
+	qdelay      time.Duration
+	srtt        time.Duration
+	lastBackoff time.Time
+	// pastTarget is the queuing delay when it was last found past its
+	// target, zero once it's back under.
+	pastTarget   time.Duration
+	lastFeedback time.Time
+	lost         uint64
+
+	target   float64
+	onChange func(int)
+	// lastMax is the target bitrate at the last backoff.
+	lastMax float64
+
+	// blockedSince is when CanSend started holding packets back, zero while
+	// the window is open. It stands in for the delay of the send queue.
+	blockedSince time.Time
+}
+
+// NewEstimator returns a SCReAM estimator starting at 1 Mbps by default.
+func NewEstimator(opts ...Option) (*Estimator, error) {
+	e := &Estimator{
+		minBitrate:   100_000,
+		maxBitrate:   50_000_000,
+		qdelayTarget: DefaultQueueDelayTarget,
+		target:       1_000_000,
+		srtt:         100 * time.Millisecond,
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, err
+		}
+	}
+	e.baseDelayAt = e.now()
+	for i := range e.baseDelays {
+		e.baseDelays[i] = 1<<63 - 1
+	}
+	// start with the window matching the initial rate.
+	e.cwnd = e.target / 8 * e.srtt.Seconds()
+	if e.cwnd < minimumCwnd {
+		e.cwnd = minimumCwnd
+	}
+	return e, nil
+}
+
+// AddStream records the departure of packets carrying a transport wide
+// sequence number.
+func (e *Estimator) AddStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	var hdrExtID uint8
+	for _, ext := range info.RTPHeaderExtensions {
+		if ext.URI == transportCCURI {
+			hdrExtID = uint8(ext.ID)
+			break
+		}
+	}
+	if hdrExtID == 0 {
+		return writer
+	}
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		var ext rtp.TransportCCExtension
+		if err := ext.Unmarshal(header.GetExtension(hdrExtID)); err == nil {
+			size := header.MarshalSize() + len(payload)
+			e.lock.Lock()
+			e.totalSent += uint64(size)
+			e.history[ext.TransportSequence] = sent{departure: e.now(), size: size, total: e.totalSent, valid: true}
+			e.lock.Unlock()
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// WriteRTCP applies transport wide feedback.
+func (e *Estimator) WriteRTCP(pkts []rtcp.Packet, attributes interceptor.Attributes) error {
+	for _, pkt := range pkts {
+		if fb, ok := pkt.(*rtcp.TransportLayerCC); ok {
+			e.onFeedback(e.now(), unpack(fb))
+		}
+	}
+	return nil
+}
+
+func (e *Estimator) onFeedback(now time.Time, acks []ack) {
+	e.lock.Lock()
+
+	var newlyAcked uint64
+	var latest *sent
+	loss := false
+	for _, a := range acks {
+		s := &e.history[a.sequence]
+		if !s.valid {
+			continue
+		}
+		s.valid = false
+		if !a.received {
+			loss = true
+			e.lost++
+			continue
+		}
+		newlyAcked += uint64(s.size)
+		if s.total > e.totalAckd {
+			e.totalAckd = s.total
+		}
+		if latest == nil || s.departure.After(latest.departure) {
+			latest = s
+		}
+		e.updateDelay(now, a.arrival-time.Duration(s.departure.UnixNano()))
+	}
+	if latest != nil {
+		rtt := now.Sub(latest.departure)
+		e.srtt = e.srtt*7/8 + rtt/8
+	}
+	inFlight := float64(e.totalSent - e.totalAckd)
+	if inFlight > e.maxInFlight {
+		e.maxInFlight = inFlight
+	} else {
+		e.maxInFlight = 0.95*e.maxInFlight + 0.05*inFlight
+	}
+
+	if !e.lastFeedback.IsZero() {
+		if dt := now.Sub(e.lastFeedback).Seconds(); dt > 0 {
+			e.delivered = 0.75*e.delivered + 0.25*float64(newlyAcked)*8/dt
+		}
+	}
+	e.updateWindow(now, loss, float64(newlyAcked))
+	e.updateTarget(now)
+	e.lastFeedback = now
+	target, onChange := int(e.target), e.onChange
+	e.lock.Unlock()
+
+	if onChange != nil {
+		onChange(target)
+	}
+}
+
+// updateDelay tracks the base one way delay and the queuing delay above it,
+// the clock offset between sender and receiver cancels out.
+func (e *Estimator) updateDelay(now time.Time, owd time.Duration) {
+	if now.Sub(e.baseDelayAt) > baseDelayWindow {
+		copy(e.baseDelays[1:], e.baseDelays[:baseDelayWindows-1])
+		e.baseDelays[0] = 1<<63 - 1
+		e.baseDelayAt = now
+	}
+	if owd < e.baseDelays[0] {
+		e.baseDelays[0] = owd
+	}
+	base := e.baseDelays[0]
+	for _, d := range e.baseDelays[1:] {
+		if d < base {
+			base = d
+		}
+	}
+	e.qdelay = (e.qdelay*7 + (owd - base)) / 8
+}
+
+// updateWindow applies RFC 8298 section 4.1.2: multiplicative decrease on
+// loss at most once per round trip, otherwise the window moves in proportion
+// to how far the queuing delay is from its target.
+func (e *Estimator) updateWindow(now time.Time, loss bool, newlyAcked float64) {
+	canBackoff := now.Sub(e.lastBackoff) > e.srtt
+	if e.qdelay <= e.qdelayTarget {
+		e.pastTarget = 0
+	}
+	switch {
+	case loss && canBackoff:
+		e.cwnd *= 0.8
+		e.lastBackoff = now
+		e.lastMax = e.target
+	case e.qdelay > e.qdelayTarget && canBackoff:
+		// the queue is past target, back off in proportion to how far past
+		// rather than waiting for it to drain slowly. If it has drained since
+		// the last round trip the last backoff is left to take effect,
+		// backing off again would undershoot.
+		if e.qdelay >= e.pastTarget {
+			e.cwnd *= 1 - 0.25*math.Min(float64(e.qdelay-e.qdelayTarget)/float64(e.qdelayTarget), 1)
+			e.lastMax = e.target
+		}
+		e.lastBackoff = now
+		e.pastTarget = e.qdelay
+	case e.qdelay < e.qdelayTarget/4 && now.Sub(e.lastBackoff) > postCongestionDelay:
+		// fast increase while the queue has been empty for a while.
+		e.cwnd += newlyAcked
+	default:
+		offTarget := float64(e.qdelayTarget-e.qdelay) / float64(e.qdelayTarget)
+		e.cwnd += offTarget * newlyAcked * mss / e.cwnd
+	}
+	// don't let the window grow far past what's actually been used.
+	if limit := 1.5*e.maxInFlight + 2*mss; e.cwnd > limit && limit > minimumCwnd {
+		e.cwnd = limit
+	}
+	if e.cwnd < minimumCwnd {
+		e.cwnd = minimumCwnd
+	}
+}
+
+// updateTarget steers the media bitrate towards the window's rate. Decreases
+// are immediate, increases are rate limited so the encoder isn't overshot.
+func (e *Estimator) updateTarget(now time.Time) {
+	srtt := e.srtt
+	if srtt < 10*time.Millisecond {
+		srtt = 10 * time.Millisecond
+	}
+	// leave headroom in the window for frames larger than the average, they
+	// would otherwise wait in the send queue.
+	rate := e.cwnd * 8 / srtt.Seconds() / windowHeadroom
+	// packets held back by the window wait in the send queue, RFC 8298
+	// section 4.1.2.5, so that delay is steered as well. Past the target the
+	// rate drops below what the link delivers to leave room for the queues
+	// to drain, but never far enough to undershoot the link.
+	qdelay := e.qdelay
+	if !e.blockedSince.IsZero() {
+		qdelay += now.Sub(e.blockedSince)
+	}
+	if qdelay > e.qdelayTarget && e.delivered > 0 {
+		scale := math.Max(0.85, 1-0.5*float64(qdelay-e.qdelayTarget)/float64(e.qdelayTarget))
+		if drain := e.delivered * scale; rate > drain {
+			rate = drain
+		}
+	}
+	if rate < e.target {
+		e.target = rate
+	} else if !e.lastFeedback.IsZero() {
+		rampUp := e.target * rampUpScale
+		if rampUp < minimumRampUp {
+			rampUp = minimumRampUp
+		}
+		// slow down near the rate of the last backoff rather than overshoot
+		// it again, RFC 8298 section 4.1.2.5.
+		if e.lastMax > 0 {
+			scale := 4 * (e.target - e.lastMax) / e.lastMax
+			rampUp *= math.Max(0.2, math.Min(1, scale*scale))
+		}
+		if max := e.target + rampUp*now.Sub(e.lastFeedback).Seconds(); rate > max {
+			rate = max
+		}
+		e.target = rate
+	}
+	if e.target < e.minBitrate {
+		e.target = e.minBitrate
+	}
+	if e.target > e.maxBitrate {
+		e.target = e.maxBitrate
+	}
+}
+
+// CanSend checks whether a packet of size bytes fits in the congestion window,
+// RFC 8298 section 4.1.1. The window always admits a packet when nothing is in
+// flight.
+func (e *Estimator) CanSend(size int) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	inFlight := float64(e.totalSent - e.totalAckd)
+	if inFlight == 0 || inFlight+float64(size) <= e.cwnd {
+		e.blockedSince = time.Time{}
+		return true
+	}
+	now := e.now()
+	if e.blockedSince.IsZero() {
+		e.blockedSince = now
+	}
+	last := e.lastFeedback
+	if last.Before(e.blockedSince) {
+		last = e.blockedSince
+	}
+	if now.Sub(last) > feedbackTimeout {
+		e.totalAckd = e.totalSent
+		e.cwnd = minimumCwnd
+		e.blockedSince = time.Time{}
+		return true
+	}
+	return false
+}
+
+// GetTargetBitrate returns the target bitrate in bits per second.
+func (e *Estimator) GetTargetBitrate() int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return int(e.target)
+}
+
+// GetStats returns internal statistics of the estimator.
+func (e *Estimator) GetStats() map[string]interface{} {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return map[string]interface{}{
+		"targetBitrate": int(e.target),
+		"cwnd":          int(e.cwnd),
+		"bytesInFlight": int(e.totalSent - e.totalAckd),
+		"queueDelay":    float64(e.qdelay.Microseconds()) / 1000.0,
+		"rtt":           float64(e.srtt.Microseconds()) / 1000.0,
+		"lost":          e.lost,
+	}
+}
+
+// OnTargetBitrateChange sets the callback called on every feedback.
+func (e *Estimator) OnTargetBitrateChange(f func(bitrate int)) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.onChange = f
+}
+
+func (e *Estimator) Close() error {
+	return nil
+}
+
+var _ cc.BandwidthEstimator = (*Estimator)(nil)
@@ -0,0 +1,487 @@
+package scream
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/twcc"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+const (
+	fixture       = "../../../test/sample.ivf.pcap"
+	fixtureRate   = 90000
+	transportCCID = 5
+	mtu           = 1200
+
+	// the link's one way propagation delay and its tail drop buffer, cellular
+	// links buffer deeply.
+	linkDelay  = 40 * time.Millisecond
+	linkBuffer = 500 * time.Millisecond
+	// a packet is retransmitted by the radio with harqProbability, taking
+	// harqDelay longer to arrive and holding up the packets behind it.
+	harqProbability = 0.1
+	harqDelay       = 8 * time.Millisecond
+
+	// capacityPeriod is how long the link stays at each capacity level, the
+	// target bitrates are compared once both have reacted to a step, from
+	// settleTime into each period.
+	capacityPeriod = 5 * time.Second
+	settleTime     = 1 * time.Second
+
+	// vbv caps the largest frame at a multiple of the mean, like an encoder's
+	// rate control would.
+	vbv = 4
+)
+
+type frame struct {
+	timestamp uint32
+	size      int
+}
+
+// readFrames reads the RTP payload bytes per frame from an ethernet pcap of
+// UDP over IPv4.
+func readFrames(path string) ([]frame, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 24 || binary.LittleEndian.Uint32(data) != 0xa1b2c3d4 {
+		return nil, errors.New("not a little endian pcap")
+	}
+	if linkType := binary.LittleEndian.Uint32(data[20:]); linkType != 1 {
+		return nil, fmt.Errorf("unsupported link type %d", linkType)
+	}
+	var frames []frame
+	for offset := 24; offset+16 <= len(data); {
+		n := int(binary.LittleEndian.Uint32(data[offset+8:]))
+		offset += 16
+		if offset+n > len(data) {
+			break
+		}
+		pkt := data[offset : offset+n]
+		offset += n
+
+		// ethernet, ipv4 and udp headers.
+		if len(pkt) < 14+20 || binary.BigEndian.Uint16(pkt[12:]) != 0x0800 {
+			continue
+		}
+		ip := pkt[14:]
+		ihl := int(ip[0]&0x0f) * 4
+		if ip[9] != 17 || len(ip) < ihl+8 {
+			continue
+		}
+		p := &rtp.Packet{}
+		if err := p.Unmarshal(ip[ihl+8:]); err != nil {
+			continue
+		}
+		if len(frames) > 0 && frames[len(frames)-1].timestamp == p.Timestamp {
+			frames[len(frames)-1].size += len(p.Payload)
+			continue
+		}
+		frames = append(frames, frame{timestamp: p.Timestamp, size: len(p.Payload)})
+	}
+	if len(frames) < 2 {
+		return nil, errors.New("no rtp frames")
+	}
+	return frames, nil
+}
+
+// capacity is a cellular-like capacity trace: a few seconds at a level, then
+// a step to another, with some fast fading on top.
+func capacity(elapsed time.Duration) float64 {
+	levels := []float64{6e6, 2e6, 4e6, 1.5e6}
+	level := levels[int(elapsed/capacityPeriod)%len(levels)]
+	fading := 1 + 0.2*math.Sin(2*math.Pi*elapsed.Seconds()/1.7)
+	return level * fading
+}
+
+// clock is the simulation's clock, it only moves when the simulation steps it.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+type arrival struct {
+	sequence uint16
+	at       time.Time
+}
+
+type feedback struct {
+	at   time.Time
+	pkts []rtcp.Packet
+}
+
+// link is a bottleneck with a tail drop buffer, it reports arrivals back to
+// the sender with transport wide feedback.
+type link struct {
+	clock     *clock
+	start     time.Time
+	estimator cc.BandwidthEstimator
+	rng       *rand.Rand
+
+	busyUntil   time.Time
+	lastArrival time.Time
+	pending     []arrival
+	recorder    *twcc.Recorder
+	feedbacks   []feedback
+
+	sent, dropped int
+	bytes         int
+	queueDelays   []time.Duration
+}
+
+func (l *link) Write(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+	var ext rtp.TransportCCExtension
+	if err := ext.Unmarshal(header.GetExtension(transportCCID)); err != nil {
+		return 0, err
+	}
+	size := header.MarshalSize() + len(payload)
+
+	now := l.clock.Now()
+	l.sent++
+	if l.busyUntil.Before(now) {
+		l.busyUntil = now
+	}
+	queued := l.busyUntil.Sub(now)
+	if queued > linkBuffer {
+		l.dropped++
+		return size, nil
+	}
+	l.busyUntil = l.busyUntil.Add(time.Duration(float64(size*8) / capacity(now.Sub(l.start)) * float64(time.Second)))
+	at := l.busyUntil.Add(linkDelay)
+	if l.rng.Float64() < harqProbability {
+		at = at.Add(harqDelay)
+	}
+	// the link delivers in order.
+	if at.Before(l.lastArrival) {
+		at = l.lastArrival
+	}
+	l.lastArrival = at
+	l.pending = append(l.pending, arrival{sequence: ext.TransportSequence, at: at})
+	l.queueDelays = append(l.queueDelays, queued)
+	l.bytes += size
+	return size, nil
+}
+
+// feedback records the packets that have arrived and sends feedback for them
+// back over the uncongested return path.
+func (l *link) feedback() {
+	now := l.clock.Now()
+	n := 0
+	for _, a := range l.pending {
+		if a.at.After(now) {
+			break
+		}
+		l.recorder.Record(1, a.sequence, a.at.Sub(l.start).Microseconds())
+		n++
+	}
+	l.pending = l.pending[n:]
+	l.feedbacks = append(l.feedbacks, feedback{at: now.Add(linkDelay), pkts: l.recorder.BuildFeedbackPacket()})
+}
+
+// deliver hands the feedback that has made it back to the estimator.
+func (l *link) deliver() error {
+	now := l.clock.Now()
+	n := 0
+	for _, f := range l.feedbacks {
+		if f.at.After(now) {
+			break
+		}
+		if err := l.estimator.WriteRTCP(f.pkts, nil); err != nil {
+			return err
+		}
+		n++
+	}
+	l.feedbacks = l.feedbacks[n:]
+	return nil
+}
+
+// stats returns and resets the counters of the last interval.
+func (l *link) stats() (sent, dropped, bytes int, queueDelays []time.Duration) {
+	sent, dropped, bytes, queueDelays = l.sent, l.dropped, l.bytes, l.queueDelays
+	l.sent, l.dropped, l.bytes, l.queueDelays = 0, 0, 0, nil
+	return
+}
+
+type queued struct {
+	header   *rtp.Header
+	size     int
+	enqueued time.Time
+}
+
+// sender packetizes frames scaled to its estimator's target bitrate, as an
+// encoder following the estimate would. A window based estimator holds the
+// packets in a send queue until they fit in its window.
+type sender struct {
+	name      string
+	estimator cc.BandwidthEstimator
+	clock     *clock
+	link      *link
+	writer    interceptor.RTPWriter
+
+	queue             []queued
+	sequence          uint16
+	transportSequence uint16
+
+	// totals over the whole run.
+	utilization float64
+	intervals   int
+	queueDelays []time.Duration
+	sendDelays  []time.Duration
+	sent        int
+	dropped     int
+	bits        int
+	// targets are the target bitrates sampled in each capacity period.
+	targets [][]float64
+}
+
+func newSender(name string, estimator cc.BandwidthEstimator, c *clock) *sender {
+	l := &link{clock: c, start: c.Now(), estimator: estimator, rng: rand.New(rand.NewSource(2)), recorder: twcc.NewRecorder(0)}
+	info := &interceptor.StreamInfo{
+		SSRC:                1,
+		RTPHeaderExtensions: []interceptor.RTPHeaderExtension{{URI: transportCCURI, ID: transportCCID}},
+	}
+	return &sender{name: name, estimator: estimator, clock: c, link: l, writer: estimator.AddStream(info, l)}
+}
+
+func (s *sender) send(timestamp uint32, size int) error {
+	now := s.clock.Now()
+	for size > 0 {
+		n := size
+		if n > mtu {
+			n = mtu
+		}
+		size -= n
+		s.sequence++
+		header := &rtp.Header{
+			Version:        2,
+			PayloadType:    96,
+			SequenceNumber: s.sequence,
+			Timestamp:      timestamp,
+			SSRC:           1,
+			Marker:         size == 0,
+		}
+		s.queue = append(s.queue, queued{header: header, size: n, enqueued: now})
+	}
+	return s.flush()
+}
+
+// flush writes the queued packets that the window admits.
+func (s *sender) flush() error {
+	w, windowed := s.estimator.(interface{ CanSend(int) bool })
+	for len(s.queue) > 0 {
+		q := s.queue[0]
+		if windowed && !w.CanSend(q.header.MarshalSize()+q.size) {
+			return nil
+		}
+		s.queue = s.queue[1:]
+		s.transportSequence++
+		ext, err := (&rtp.TransportCCExtension{TransportSequence: s.transportSequence}).Marshal()
+		if err != nil {
+			return err
+		}
+		if err := q.header.SetExtension(transportCCID, ext); err != nil {
+			return err
+		}
+		s.sendDelays = append(s.sendDelays, s.clock.Now().Sub(q.enqueued))
+		if _, err := s.writer.Write(q.header, make([]byte, q.size), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sample records the target bitrate in the capacity period.
+func (s *sender) sample(period int) {
+	for len(s.targets) <= period {
+		s.targets = append(s.targets, nil)
+	}
+	s.targets[period] = append(s.targets[period], float64(s.estimator.GetTargetBitrate()))
+}
+
+// oscillation is how far the target bitrate moves back and forth within each
+// capacity period, relative to its mean and averaged over the periods. A rate
+// that only ramps towards the capacity doesn't oscillate.
+func (s *sender) oscillation() float64 {
+	var sum float64
+	for _, targets := range s.targets {
+		var mean, moved float64
+		for i, t := range targets {
+			mean += t
+			if i > 0 {
+				moved += math.Abs(t - targets[i-1])
+			}
+		}
+		mean /= float64(len(targets))
+		net := math.Abs(targets[len(targets)-1] - targets[0])
+		sum += (moved - net) / mean
+	}
+	return sum / float64(len(s.targets))
+}
+
+// collect adds the link's counters of the last interval to the totals.
+func (s *sender) collect(available float64) {
+	sent, dropped, bytes, queueDelays := s.link.stats()
+	s.utilization += math.Min(float64(bytes*8)/available, 1)
+	s.intervals++
+	s.queueDelays = append(s.queueDelays, queueDelays...)
+	s.sent += sent
+	s.dropped += dropped
+	s.bits += bytes * 8
+}
+
+func (s *sender) String() string {
+	return fmt.Sprintf("%-6s %6.2fM %5.1f%% utilization, oscillation %.2f, link queue mean %dms p95 %dms, send queue p95 %dms, %.2f%% loss",
+		s.name, float64(s.bits)/float64(s.intervals)/1e6, 100*s.utilization/float64(s.intervals), s.oscillation(),
+		mean(s.queueDelays).Milliseconds(), percentile(s.queueDelays, 0.95).Milliseconds(),
+		percentile(s.sendDelays, 0.95).Milliseconds(), 100*float64(s.dropped)/float64(s.sent))
+}
+
+func percentile(delays []time.Duration, p float64) time.Duration {
+	if len(delays) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, delays...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+func mean(delays []time.Duration) time.Duration {
+	if len(delays) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, d := range delays {
+		sum += d
+	}
+	return sum / time.Duration(len(delays))
+}
+
+// TestSimulatedCellularLink replays the fixture over a simulated cellular link
+// with GCC and SCReAM, each on its own copy of the link. The simulation steps
+// its own clock a millisecond at a time so it runs in a fraction of the time
+// it simulates and gives the same result on every run.
+func TestSimulatedCellularLink(t *testing.T) {
+	frames, err := readFrames(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtureBytes int
+	for _, f := range frames[:len(frames)-1] {
+		fixtureBytes += f.size
+	}
+	// the fixture's keyframes are far larger than a rate controlled encoder
+	// would produce.
+	maxFrame := vbv * fixtureBytes / (len(frames) - 1)
+	fixtureBytes = 0
+	for i := range frames {
+		if frames[i].size > maxFrame {
+			frames[i].size = maxFrame
+		}
+		if i < len(frames)-1 {
+			fixtureBytes += frames[i].size
+		}
+	}
+	fixtureDuration := float64(frames[len(frames)-1].timestamp-frames[0].timestamp) / fixtureRate
+	fixtureBitrate := float64(fixtureBytes*8) / fixtureDuration
+
+	c := &clock{now: time.Unix(1_600_000_000, 0)}
+	start := c.Now()
+	s, err := NewEstimator(InitialBitrate(1_000_000), withClock(c.Now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	senders := []*sender{newSender("gcc", newReferenceGCC(1_000_000, c.Now), c), newSender("scream", s, c)}
+
+	rng := rand.New(rand.NewSource(1))
+	// schedule frames on their rtp timestamps, looping the fixture.
+	var offset, nextFrame time.Duration
+	next := 0
+	for elapsed := time.Duration(0); elapsed < 4*capacityPeriod; elapsed += time.Millisecond {
+		c.now = start.Add(elapsed)
+		for _, s := range senders {
+			if err := s.link.deliver(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for elapsed >= nextFrame {
+			f := frames[next]
+			for _, s := range senders {
+				// jitter the frame size a little so the streams don't lock step.
+				scale := float64(s.estimator.GetTargetBitrate()) / fixtureBitrate * (0.9 + 0.2*rng.Float64())
+				if err := s.send(f.timestamp, int(float64(f.size)*scale)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			next++
+			if next == len(frames)-1 {
+				offset += time.Duration(fixtureDuration * float64(time.Second))
+				next = 0
+			}
+			nextFrame = offset + time.Duration(float64(frames[next].timestamp-frames[0].timestamp)/fixtureRate*float64(time.Second))
+		}
+		for _, s := range senders {
+			if err := s.flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if elapsed%(50*time.Millisecond) == 0 {
+			for _, s := range senders {
+				s.link.feedback()
+			}
+		}
+		// sample the targets once both have settled after a capacity step.
+		if elapsed%(100*time.Millisecond) == 0 && elapsed%capacityPeriod >= settleTime {
+			for _, s := range senders {
+				s.sample(int(elapsed / capacityPeriod))
+			}
+		}
+		if elapsed%time.Second == 0 && elapsed > 0 {
+			for _, s := range senders {
+				s.collect(capacity(elapsed))
+			}
+		}
+	}
+
+	gccSender, screamSender := senders[0], senders[1]
+	for _, s := range senders {
+		t.Log(s)
+	}
+	// SCReAM tracks the capacity steps with a steadier rate than GCC's
+	// sawtooth, using the link as well while keeping its queue near the target
+	// rather than letting the deep buffer fill.
+	if got, limit := screamSender.oscillation(), gccSender.oscillation(); got >= limit {
+		t.Errorf("scream oscillation %.3f not below gcc's %.3f", got, limit)
+	}
+	if got, limit := screamSender.utilization, gccSender.utilization; got < limit {
+		t.Errorf("scream utilization %.1f%% below gcc's %.1f%%",
+			100*got/float64(screamSender.intervals), 100*limit/float64(gccSender.intervals))
+	}
+	if got := mean(screamSender.queueDelays); got > DefaultQueueDelayTarget {
+		t.Errorf("scream mean link queue delay %v above the target", got)
+	}
+	if got, limit := percentile(screamSender.queueDelays, 0.95), percentile(gccSender.queueDelays, 0.95); got > limit {
+		t.Errorf("scream p95 link queue delay %v above gcc's %v", got, limit)
+	}
+	if got := percentile(screamSender.sendDelays, 0.95); got > linkBuffer {
+		t.Errorf("scream p95 send queue delay %v above the link's buffer", got)
+	}
+	if loss := float64(screamSender.dropped) / float64(screamSender.sent); loss > 0.01 {
+		t.Errorf("scream lost %.2f%% of packets", 100*loss)
+	}
+}
@@ -1,3 +1,21 @@
 #!/bin/bash
+# Runs the muxer without root. Sockets are bound to the interface addresses
+# and the routes helper installs the policy routes that keep each address on
+# its interface, so only the helper needs CAP_NET_ADMIN. Extra arguments are
+# passed to the muxer.
 
-sudo GST_DEBUG=3 /usr/local/go/bin/go run cmd/muxer/main.go -dest 34.86.30.237:5000
+set -e
+cd "$(dirname "$0")"
+
+mkdir -p bin
+go build -o bin/routes ./cmd/routes
+go build -o bin/muxer ./cmd
+
+# granting the capability is the only privileged step.
+sudo setcap cap_net_admin=ep bin/routes
+
+bin/routes &
+routes=$!
+trap 'kill $routes' EXIT
+
+bin/muxer -binding source -dest 34.86.30.237:5000 "$@"